@@ -0,0 +1,109 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"strings"
+)
+
+// A FileChange describes a file changed by an Updater.
+type FileChange struct {
+	Handle Handle      // zero for removed files
+	Old    *FileHeader // in the opened archive; nil for added files
+	New    *FileHeader // nil for removed files
+}
+
+// Changes describes the changes of an Updater to the opened archive.
+// A file may be both renamed and modified, or renamed and edited.
+type Changes struct {
+	Added        []FileChange
+	Removed      []FileChange
+	Renamed      []FileChange
+	Modified     []FileChange // the contents are changed
+	MetadataOnly []FileChange // only the metadata, such as the comment, is changed
+
+	// EstimatedSize is the estimated size of the archive written by SaveAs.
+	// Files added from sources that are not read yet are counted with
+	// their uncompressed sizes, when they are known.
+	EstimatedSize int64
+}
+
+// Changes returns the changes to the opened archive (or to the last
+// saved archive, after SaveInPlace or Save), without saving them.
+func (u *Updater) Changes() *Changes {
+	c := new(Changes)
+	kept := make(map[*File]bool, len(u.entries))
+	for _, e := range u.entries {
+		change := FileChange{Handle: e.id, New: e.header}
+		if e.orig == nil {
+			c.Added = append(c.Added, change)
+			continue
+		}
+		kept[e.orig] = true
+		change.Old = &e.orig.FileHeader
+
+		if e.header.Name != e.orig.Name {
+			c.Renamed = append(c.Renamed, change)
+		}
+		if e.origin != e.orig || e.recompress {
+			c.Modified = append(c.Modified, change)
+		} else if !equalMetadata(e.header, &e.orig.FileHeader) {
+			c.MetadataOnly = append(c.MetadataOnly, change)
+		}
+	}
+	if u.r != nil {
+		for _, zf := range u.r.File {
+			if !kept[zf] {
+				c.Removed = append(c.Removed, FileChange{Old: &zf.FileHeader})
+			}
+		}
+	}
+
+	c.EstimatedSize = u.estimateSize()
+	return c
+}
+
+// estimateSize returns the estimated size of the archive written by SaveAs.
+func (u *Updater) estimateSize() int64 {
+	size := int64(directoryEndLen + len(u.Comment))
+	for _, e := range u.entries {
+		fh := e.header
+		size += int64(fileHeaderLen + len(fh.Name) + len(fh.Extra))
+		size += int64(directoryHeaderLen + len(fh.Name) + len(fh.Extra) + len(fh.Comment))
+
+		n := int64(fh.CompressedSize64)
+		if e.source != nil && !strings.HasSuffix(fh.Name, "/") {
+			n = int64(fh.UncompressedSize64)
+		}
+		size += n
+		if fh.Flags&FlagDataDescriptor != 0 || e.source != nil {
+			size += dataDescriptorLen
+		}
+		if fh.isZip64() {
+			size += 2 * 28 // zip64 extra fields
+		}
+	}
+	if len(u.entries) >= uint16max || size >= uint32max {
+		size += directory64EndLen + directory64LocLen
+	}
+	return size
+}
+
+// equalMetadata reports whether a and b are the same,
+// ignoring their names.
+func equalMetadata(a, b *FileHeader) bool {
+	return a.Comment == b.Comment &&
+		a.NonUTF8 == b.NonUTF8 &&
+		a.CreatorVersion == b.CreatorVersion &&
+		a.ReaderVersion == b.ReaderVersion &&
+		a.Flags == b.Flags &&
+		a.Method == b.Method &&
+		a.Modified.Equal(b.Modified) &&
+		a.ModifiedTime == b.ModifiedTime &&
+		a.ModifiedDate == b.ModifiedDate &&
+		a.ExternalAttrs == b.ExternalAttrs &&
+		bytes.Equal(removeExtra(a.Extra, zip64ExtraID), removeExtra(b.Extra, zip64ExtraID))
+}
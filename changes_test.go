@@ -0,0 +1,111 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testChangeNames(t *testing.T, kind string, changes []FileChange, want []string) {
+	t.Helper()
+
+	if len(changes) != len(want) {
+		t.Fatalf("%s: count=%d, want %d", kind, len(changes), len(want))
+	}
+	for i, c := range changes {
+		name := ""
+		if c.New != nil {
+			name = c.New.Name
+		} else {
+			name = c.Old.Name
+		}
+		if name != want[i] {
+			t.Fatalf("%s: name=%q, want %q", kind, name, want[i])
+		}
+	}
+}
+
+func TestUpdaterChanges(t *testing.T) {
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+	updatefile := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	handles := z.Handles()
+	c := z.Changes()
+	if n := len(c.Added) + len(c.Removed) + len(c.Renamed) + len(c.Modified) + len(c.MetadataOnly); n != 0 {
+		t.Fatalf("change count=%d, want %d", n, 0)
+	}
+	st, _ := file.Stat()
+	if c.EstimatedSize != st.Size() {
+		t.Fatalf("estimated size=%d, want %d", c.EstimatedSize, st.Size())
+	}
+
+	// edit
+	testAddFile(t, z, addfile)
+	testUpdateFile(t, z, updatefile)
+	if err := z.Rename("hello", "hello2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Remove("readonly"); err != nil {
+		t.Fatal(err)
+	}
+	err := z.UpdateHeader("dir/empty/", func(fh *FileHeader) {
+		fh.Comment = "empty directory"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = z.Changes()
+	testChangeNames(t, "added", c.Added, []string{"test"})
+	testChangeNames(t, "removed", c.Removed, []string{"readonly"})
+	testChangeNames(t, "renamed", c.Renamed, []string{"hello2"})
+	testChangeNames(t, "modified", c.Modified, []string{"dir/bar"})
+	testChangeNames(t, "metadata", c.MetadataOnly, []string{"dir/empty/"})
+	if c.Renamed[0].Old.Name != "hello" || c.Renamed[0].Handle != handles[0] {
+		t.Fatalf("renamed=%v, want hello", c.Renamed[0])
+	}
+	if c.Removed[0].Handle != 0 || c.Removed[0].New != nil {
+		t.Fatalf("removed=%v, want no new file", c.Removed[0])
+	}
+
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.EstimatedSize - int64(wdump.Len()); d < -64 || d > 64 {
+		t.Fatalf("estimated size=%d, want about %d", c.EstimatedSize, wdump.Len())
+	}
+
+	// cancel
+	if err := z.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, z, updateTest.File)
+	for i, h := range z.Handles() {
+		if h != handles[i] {
+			t.Fatalf("handle=%v, want %v", h, handles[i])
+		}
+	}
+	c = z.Changes()
+	if n := len(c.Added) + len(c.Removed) + len(c.Renamed) + len(c.Modified) + len(c.MetadataOnly); n != 0 {
+		t.Fatalf("change count=%d, want %d", n, 0)
+	}
+
+	// the Updater is still usable
+	testAddFile(t, z, addfile)
+	compareContents(t, z, append(updateTest.File[:len(updateTest.File):len(updateTest.File)], addfile))
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// A ReadWriterAt is a random-access file that can be truncated,
+// such as *os.File.
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+}
+
+// Compact removes the space that is not referenced by the central
+// directory from the archive f of the given size, such as entries left
+// behind by Updater.SaveInPlace or by appending to the archive.
+// Entries are moved towards the beginning of f, then the central
+// directory and the end record are rewritten and f is truncated.
+// Data before the first entry, such as a self-extracting stub, is kept.
+//
+// It returns the new size of the archive.
+// Compact is not crash-safe: if it fails, the archive may be corrupted.
+func Compact(f ReadWriterAt, size int64) (int64, error) {
+	zr, err := NewReader(f, size)
+	if err != nil {
+		return 0, err
+	}
+	end, err := readDirectoryEnd(f, size, nil)
+	if err != nil {
+		return 0, err
+	}
+	dirOffset := int64(end.directoryOffset)
+
+	files := make([]*File, len(zr.File))
+	copy(files, zr.File)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].headerOffset < files[j].headerOffset
+	})
+
+	// Keep a prefix, unless it is an unreferenced entry.
+	dest := dirOffset
+	if len(files) > 0 {
+		dest = files[0].headerOffset
+	}
+	if dest > 0 {
+		var buf [4]byte
+		if _, err := f.ReadAt(buf[:], 0); err != nil {
+			return 0, err
+		}
+		if b := readBuf(buf[:]); b.uint32() == fileHeaderSignature {
+			dest = 0
+		}
+	}
+
+	// Slide the entries down.
+	offsets := make(map[*File]int64, len(files))
+	buf := make([]byte, 32*1024)
+	for i, zf := range files {
+		start := zf.headerOffset
+		stop, err := zf.endOffset()
+		if err != nil {
+			return 0, err
+		}
+		// The data descriptor may have no signature.
+		if i+1 < len(files) && files[i+1].headerOffset < stop {
+			stop = files[i+1].headerOffset
+		}
+		if stop > dirOffset {
+			stop = dirOffset
+		}
+		if stop < start {
+			return 0, errors.New("zip: entries overlap")
+		}
+		if i > 0 && files[i-1].headerOffset == start {
+			// Entries sharing their data are moved only once.
+			offsets[zf] = offsets[files[i-1]]
+			continue
+		}
+
+		if start != dest {
+			if err := moveRange(f, dest, start, stop-start, buf); err != nil {
+				return 0, err
+			}
+		}
+		offsets[zf] = dest
+		dest += stop - start
+	}
+
+	// Write the central directory and the end record.
+	z := NewWriter(&offsetWriter{w: f, off: dest})
+	z.SetOffset(dest)
+	if err := z.SetComment(zr.Comment); err != nil {
+		return 0, err
+	}
+	for _, zf := range zr.File {
+		fh := zf.FileHeader
+		fh.Extra = removeExtra(fh.Extra, zip64ExtraID) // regenerated by Writer
+		z.dir = append(z.dir, &header{
+			FileHeader: &fh,
+			offset:     uint64(offsets[zf]),
+		})
+	}
+	if err := z.Close(); err != nil {
+		return 0, err
+	}
+
+	newSize := z.cw.count
+	if newSize < size {
+		if err := f.Truncate(newSize); err != nil {
+			return 0, err
+		}
+	}
+	return newSize, nil
+}
+
+// moveRange copies n bytes of f from src to dest, where dest < src.
+func moveRange(f ReadWriterAt, dest, src, n int64, buf []byte) error {
+	for n > 0 {
+		b := buf
+		if int64(len(b)) > n {
+			b = b[:n]
+		}
+		if _, err := f.ReadAt(b, src); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(b, dest); err != nil {
+			return err
+		}
+		src += int64(len(b))
+		dest += int64(len(b))
+		n -= int64(len(b))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	updatefile := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+	removename := "hello"
+
+	// open file
+	file, z := testOpenTempFile(t, "testdata/"+updateTest.Name)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	defer z.Close()
+
+	// make dead space
+	testUpdateFile(t, z, updatefile)
+	if err := z.Remove(removename); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := file.Stat()
+	oldSize := st.Size()
+
+	// compact
+	size, err := Compact(file, oldSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size >= oldSize {
+		t.Fatalf("file size=%d, want < %d", size, oldSize)
+	}
+	st, _ = file.Stat()
+	if st.Size() != size {
+		t.Fatalf("file size=%d, want %d", st.Size(), size)
+	}
+
+	// check file
+	zr, err := NewUpdater(file, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcase := make([]ZipTestFile, 0)
+	for _, zf := range updateTest.File {
+		switch zf.Name {
+		case removename:
+		case updatefile.Name:
+			testcase = append(testcase, updatefile)
+		default:
+			testcase = append(testcase, zf)
+		}
+	}
+	compareContents(t, zr, testcase)
+
+	// compact again
+	size2, err := Compact(file, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size2 != size {
+		t.Fatalf("file size=%d, want %d", size2, size)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Mkdir adds the directory name, and its parent directories that do
+// not exist yet. It does nothing if the directory already exists.
+func (u *Updater) Mkdir(name string) error {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" {
+		return errors.New("zip: invalid directory name")
+	}
+
+	var dirs []string
+	for dir := name; dir != "" && dir != "."; dir = path.Dir(dir) {
+		if u.lookup(dir) != nil {
+			return fmt.Errorf("zip: %s is not a directory", dir)
+		}
+		if u.lookup(dir+"/") != nil {
+			break
+		}
+		dirs = append(dirs, dir+"/")
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fh := &FileHeader{Name: dirs[i]}
+		fh.SetMode(os.ModeDir | 0755)
+		fh.Modified = time.Now()
+
+		e := &entry{header: fh}
+		u.add(e)
+		if err := u.bufferSource(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameDir moves the directory oldDir, and all the files in it, to newDir.
+// The directory does not need to have its own entry.
+func (u *Updater) RenameDir(oldDir, newDir string) error {
+	oldDir = strings.TrimSuffix(oldDir, "/") + "/"
+	newDir = strings.TrimSuffix(newDir, "/") + "/"
+	if newDir == "/" {
+		return errors.New("zip: invalid directory name")
+	}
+
+	renames := make(map[*entry]string)
+	for _, e := range u.entries {
+		if name := e.header.Name; strings.HasPrefix(name, oldDir) {
+			renames[e] = newDir + strings.TrimPrefix(name, oldDir)
+		}
+	}
+	if len(renames) == 0 {
+		return errors.New("not found file name")
+	}
+	return u.renameFiles(renames)
+}
+
+// RemoveAll removes all the files whose names begin with prefix,
+// such as the directory "dir/" and the files in it.
+// It returns nil if there is no such file.
+func (u *Updater) RemoveAll(prefix string) error {
+	for _, e := range u.matchFiles(func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	}) {
+		u.remove(e)
+	}
+	return nil
+}
+
+// RemoveGlob removes all the files whose names match pattern, using the
+// syntax of path.Match. Directories are matched without their trailing
+// slash, and are removed with the files in them, even if they do not
+// have their own entries.
+func (u *Updater) RemoveGlob(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	for _, e := range u.matchFiles(func(name string) bool {
+		return matchPath(name, func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}) >= 0
+	}) {
+		u.remove(e)
+	}
+	return nil
+}
+
+// RenameGlob renames all the files whose names match pattern, using the
+// syntax of path.Match. In replacement, $1, $2, ... (or ${1}, ...) are
+// replaced with the text matched by the corresponding '*', '?' or
+// character class of pattern, and $$ with a literal $; any other use of
+// $ is an error. Directories are matched without their trailing slash,
+// and the files in them are moved with them.
+func (u *Updater) RenameGlob(pattern, replacement string) error {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return err
+	}
+
+	renames := make(map[*entry]string)
+	for _, e := range u.entries {
+		name := e.header.Name
+		n := matchPath(name, re.MatchString)
+		if n < 0 {
+			continue
+		}
+		m := re.FindStringSubmatchIndex(name[:n])
+		b, err := expandGlob(nil, replacement, name[:n], m)
+		if err != nil {
+			return err
+		}
+		newName := string(b)
+		if newName == "" || strings.HasSuffix(newName, "/") {
+			return fmt.Errorf("zip: invalid new name %q for %s", newName, name)
+		}
+		renames[e] = newName + name[n:]
+	}
+	if len(renames) == 0 {
+		return errors.New("not found file name")
+	}
+	return u.renameFiles(renames)
+}
+
+// matchPath returns the length of the shortest leading part of name,
+// which is either one of its parent directories or name itself without
+// trailing slash, for which match returns true. It returns -1 if there
+// is none.
+func matchPath(name string, match func(string) bool) int {
+	trimmed := strings.TrimSuffix(name, "/")
+	for i := 1; i <= len(trimmed); i++ {
+		if (i == len(trimmed) || trimmed[i] == '/') && match(trimmed[:i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchFiles returns the files whose names match.
+func (u *Updater) matchFiles(match func(name string) bool) []*entry {
+	var entries []*entry
+	for _, e := range u.entries {
+		if match(e.header.Name) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// renameFiles renames the files at once, from the keys of renames to
+// their values. It fails without renaming any file if a new name is
+// used by a file that is not renamed, or by two files, or if a file
+// and a directory would have the same name.
+func (u *Updater) renameFiles(renames map[*entry]string) error {
+	// Files sharing a name may move to the same new name,
+	// as they shared the old one.
+	oldNames := make(map[string]string, len(renames))
+	for e, newName := range renames {
+		if old, ok := oldNames[newName]; ok && old != e.header.Name {
+			return fmt.Errorf("zip: %s is used twice", newName)
+		}
+		oldNames[newName] = e.header.Name
+		for _, e := range u.names[newName] {
+			if _, moved := renames[e]; !moved {
+				return fmt.Errorf("zip: %s already exists", newName)
+			}
+		}
+	}
+
+	// The names after renaming, and their parent directories.
+	names := make(map[string]bool, len(u.names))
+	for _, e := range u.entries {
+		if newName, ok := renames[e]; ok {
+			names[newName] = true
+		} else {
+			names[e.header.Name] = true
+		}
+	}
+	dirs := make(map[string]bool)
+	for name := range names {
+		for dir := parentDir(name); dir != "" && !dirs[dir]; dir = parentDir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for newName := range oldNames {
+		for dir := parentDir(newName); dir != ""; dir = parentDir(dir) {
+			if names[dir] {
+				return fmt.Errorf("zip: %s is not a directory", dir)
+			}
+		}
+		if dirs[newName] {
+			return fmt.Errorf("zip: %s is a directory", newName)
+		}
+	}
+
+	for e := range renames {
+		u.unname(e)
+	}
+	for e, newName := range renames {
+		e.header.Name = newName
+		u.names[newName] = append(u.names[newName], e)
+	}
+	for newName := range oldNames {
+		u.sortNames(newName)
+	}
+	return nil
+}
+
+// parentDir returns the parent directory of name, without trailing
+// slash, or "" if name is at the top level.
+func parentDir(name string) string {
+	dir := path.Dir(strings.TrimSuffix(name, "/"))
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// expandGlob appends template to dst, replacing $1, $2, ... and ${1},
+// ${2}, ... with the text of the groups of src at match, as by
+// regexp.Regexp.Expand, and $$ with a literal $. Unlike Expand, it
+// accepts only group numbers, so that "$1_old" is not read as the
+// group named "1_old", and it reports the references to no group.
+func expandGlob(dst []byte, template, src string, match []int) ([]byte, error) {
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			break
+		}
+		dst = append(dst, template[:i]...)
+		template = template[i+1:]
+		if strings.HasPrefix(template, "$") {
+			dst = append(dst, '$')
+			template = template[1:]
+			continue
+		}
+
+		braced := strings.HasPrefix(template, "{")
+		if braced {
+			template = template[1:]
+		}
+		n := 0
+		for n < len(template) && '0' <= template[n] && template[n] <= '9' {
+			n++
+		}
+		num := template[:n]
+		template = template[n:]
+		if braced {
+			if !strings.HasPrefix(template, "}") {
+				num = ""
+			}
+			template = strings.TrimPrefix(template, "}")
+		}
+		group := -1
+		if num != "" && len(num) < 4 {
+			group, _ = strconv.Atoi(num)
+		}
+		if group < 0 || 2*group+1 >= len(match) {
+			return nil, errors.New("zip: invalid group reference in replacement")
+		}
+		if match[2*group] >= 0 {
+			dst = append(dst, src[match[2*group]:match[2*group+1]]...)
+		}
+	}
+	return append(dst, template...), nil
+}
+
+// globRegexp converts the path.Match pattern to a regular expression
+// matching the whole name, with a group for each '*', '?' and
+// character class.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString("([^/]*)")
+		case '?':
+			b.WriteString("([^/])")
+		case '[':
+			b.WriteString("([")
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			for ; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '-' {
+					b.WriteByte('-')
+					continue
+				}
+				if pattern[i] == '\\' && i+1 < len(pattern) {
+					i++
+				}
+				writeClassChar(&b, pattern[i])
+			}
+			b.WriteString("])")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// writeClassChar writes c to a character class of a regular expression.
+func writeClassChar(b *strings.Builder, c byte) {
+	if c < utf8.RuneSelf && !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+		b.WriteByte('\\')
+	}
+	b.WriteByte(c)
+}
@@ -0,0 +1,318 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"testing"
+)
+
+var dirTest = []ZipTestFile{
+	{Name: "docs/", Content: []byte{}},
+	{Name: "docs/a.txt", Content: []byte("a\n")},
+	{Name: "docs/b.md", Content: []byte("b\n")},
+	{Name: "docs/sub/c.txt", Content: []byte("c\n")},
+	{Name: "docs2.txt", Content: []byte("docs2\n")},
+	{Name: "img/x.png", Content: []byte("x\n")},
+}
+
+func testOpenDirUpdater(t *testing.T) *Updater {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, ztf := range dirTest {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func testFileNames(z *Updater) []string {
+	var names []string
+	for _, fh := range z.Files() {
+		names = append(names, fh.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func testCompareNames(t *testing.T, z *Updater, want []string) {
+	t.Helper()
+
+	names := testFileNames(z)
+	sort.Strings(want)
+	if len(names) != len(want) {
+		t.Fatalf("names=%q, want %q", names, want)
+	}
+	for i := range names {
+		if names[i] != want[i] {
+			t.Fatalf("names=%q, want %q", names, want)
+		}
+	}
+}
+
+func TestUpdaterMkdir(t *testing.T) {
+	z := testOpenDirUpdater(t)
+	defer z.Close()
+
+	if err := z.Mkdir("docs/sub/deep/er"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Mkdir("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Mkdir("docs2.txt/x"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	testCompareNames(t, z, []string{
+		"docs/", "docs/a.txt", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x.png",
+		"docs/sub/", "docs/sub/deep/", "docs/sub/deep/er/",
+	})
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File[len(dirTest):] {
+		if !zf.Mode().IsDir() {
+			t.Fatalf("%s: mode=%v, want directory", zf.Name, zf.Mode())
+		}
+	}
+}
+
+func TestUpdaterRenameDir(t *testing.T) {
+	z := testOpenDirUpdater(t)
+	defer z.Close()
+
+	if err := z.RenameDir("docs", "manual/docs/"); err != nil {
+		t.Fatal(err)
+	}
+	testCompareNames(t, z, []string{
+		"manual/docs/", "manual/docs/a.txt", "manual/docs/b.md", "manual/docs/sub/c.txt",
+		"docs2.txt", "img/x.png",
+	})
+
+	// into its own subdirectory
+	if err := z.RenameDir("manual/", "manual/v1"); err != nil {
+		t.Fatal(err)
+	}
+	// directory without its own entry
+	if err := z.RenameDir("img", "images"); err != nil {
+		t.Fatal(err)
+	}
+	testCompareNames(t, z, []string{
+		"manual/v1/docs/", "manual/v1/docs/a.txt", "manual/v1/docs/b.md", "manual/v1/docs/sub/c.txt",
+		"docs2.txt", "images/x.png",
+	})
+
+	if err := z.RenameDir("not-exist", "dir"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.Mkdir("other/docs/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RenameDir("manual/v1/docs", "other/docs"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	// a file and a directory cannot have the same name
+	if err := z.RenameDir("images", "docs2.txt"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.RenameDir("manual/v1/docs/sub", "docs2.txt/sub"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.RenameGlob("docs2.txt", "images"); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContent(t, zu, ZipTestFile{Name: "manual/v1/docs/sub/c.txt", Content: []byte("c\n")})
+}
+
+func TestUpdaterRenameDirDuplicate(t *testing.T) {
+	testcase := []ZipTestFile{
+		{Name: "old/a.txt", Content: []byte("first a")},
+		{Name: "old/b.txt", Content: []byte("b")},
+		{Name: "old/a.txt", Content: []byte("second a")},
+		{Name: "old/a.txt", Content: []byte("third a")},
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, ztf := range testcase {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewUpdater(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	// the files sharing a name keep sharing it, in file order
+	for i := 0; i < 10; i++ {
+		if err := z.RenameDir("old", "new"); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.RenameGlob("new/*.txt", "old/$1.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handles := z.Lookup("old/a.txt")
+	if len(handles) != 3 {
+		t.Fatalf("handle count=%d, want %d", len(handles), 3)
+	}
+	for i, j := range []int{0, 2, 3} {
+		testHandleContent(t, z, handles[i], testcase[j].Content)
+	}
+	compareContent(t, z, testcase[3])
+
+	// a new name of another file is still used twice
+	if err := z.RenameGlob("old/?.txt", "old/c.txt"); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestUpdaterRemoveAll(t *testing.T) {
+	z := testOpenDirUpdater(t)
+	defer z.Close()
+
+	if err := z.RemoveAll("docs/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RemoveAll("not-exist/"); err != nil {
+		t.Fatal(err)
+	}
+	testCompareNames(t, z, []string{"docs2.txt", "img/x.png"})
+}
+
+func TestUpdaterRemoveGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"docs/*.txt", []string{"docs/", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x.png"}},
+		{"docs/sub", []string{"docs/", "docs/a.txt", "docs/b.md", "docs2.txt", "img/x.png"}},
+		{"docs", []string{"docs2.txt", "img/x.png"}},
+		{"*/?.[pm][nd]*", []string{"docs/", "docs/a.txt", "docs/sub/c.txt", "docs2.txt"}},
+	}
+
+	for _, test := range tests {
+		z := testOpenDirUpdater(t)
+		if err := z.RemoveGlob(test.pattern); err != nil {
+			t.Fatal(err)
+		}
+		testCompareNames(t, z, test.want)
+		z.Close()
+	}
+
+	z := testOpenDirUpdater(t)
+	defer z.Close()
+	if err := z.RemoveGlob("[docs"); err != path.ErrBadPattern {
+		t.Fatalf("err=%v, want %v", err, path.ErrBadPattern)
+	}
+}
+
+func TestUpdaterRenameGlob(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		replacement string
+		want        []string
+	}{
+		{
+			"docs/*.txt", "docs/$1.text",
+			[]string{"docs/", "docs/a.text", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x.png"},
+		},
+		{
+			"*/?.[pm][nd]*", "${1}_$2.$3$4$5",
+			[]string{"docs/", "docs/a.txt", "docs_b.md", "docs/sub/c.txt", "docs2.txt", "img_x.png"},
+		},
+		{
+			"doc*", "old-doc$1",
+			[]string{"old-docs/", "old-docs/a.txt", "old-docs/b.md", "old-docs/sub/c.txt", "old-docs2.txt", "img/x.png"},
+		},
+		{
+			"docs/*.\\txt", "$1",
+			[]string{"docs/", "a", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x.png"},
+		},
+		{
+			"img/*.png", "img/$1_old.$$png",
+			[]string{"docs/", "docs/a.txt", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x_old.$png"},
+		},
+	}
+
+	for _, test := range tests {
+		z := testOpenDirUpdater(t)
+		if err := z.RenameGlob(test.pattern, test.replacement); err != nil {
+			t.Fatal(err)
+		}
+		testCompareNames(t, z, test.want)
+		z.Close()
+	}
+
+	z := testOpenDirUpdater(t)
+	defer z.Close()
+
+	// collisions
+	if err := z.RenameGlob("docs/*.*", "docs/file"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.RenameGlob("docs/a.txt", "docs2.txt"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.RenameGlob("nothing*", "$1"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	// invalid group references
+	for _, replacement := range []string{"docs/$2.txt", "docs/$name.txt", "docs/${1.txt", "docs/$"} {
+		if err := z.RenameGlob("docs/*.txt", replacement); err == nil {
+			t.Fatalf("%s: need raise error", replacement)
+		}
+	}
+	testCompareNames(t, z, []string{"docs/", "docs/a.txt", "docs/b.md", "docs/sub/c.txt", "docs2.txt", "img/x.png"})
+
+	// a name may be kept
+	if err := z.RenameGlob("docs/[ab].*", "docs/$1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	compareContent(t, z, ZipTestFile{Name: "docs/a.txt", Content: []byte("a\n")})
+	compareContent(t, z, ZipTestFile{Name: "docs/b.txt", Content: []byte("b\n")})
+}
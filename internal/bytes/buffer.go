@@ -1,24 +1,60 @@
 package bytes
 
 import (
-	goBytes "bytes"
+	"errors"
+	"io"
 )
 
+// BufferAt is a variable-sized buffer of bytes
+// with Write, WriteAt and ReadAt methods.
 type BufferAt struct {
-	goBytes.Buffer
+	buf []byte
 }
 
+// Write appends the contents of p to the buffer.
+func (b *BufferAt) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+// WriteAt writes p at offset off, growing the buffer as needed.
+// The gap between the end of the buffer and off is filled with zeros.
 func (b *BufferAt) WriteAt(p []byte, off int64) (int, error) {
-	size := int64(len(p)) + off
-	if int64(b.Len()) < size {
-		padsize := size - int64(b.Len())
-		padding := make([]byte, padsize)
-
-		if _, err := b.Write(padding); err != nil {
-			return 0, err
-		}
+	if off < 0 {
+		return 0, errors.New("bytes.BufferAt.WriteAt: negative offset")
+	}
+	size := off + int64(len(p))
+	if int64(len(b.buf)) < size {
+		b.buf = append(b.buf, make([]byte, size-int64(len(b.buf)))...)
 	}
 
-	n := copy(b.Bytes()[off:], p)
+	n := copy(b.buf[off:], p)
 	return n, nil
 }
+
+// ReadAt reads len(p) bytes from offset off.
+func (b *BufferAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("bytes.BufferAt.ReadAt: negative offset")
+	}
+	if off >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Bytes returns the contents of the buffer.
+func (b *BufferAt) Bytes() []byte { return b.buf }
+
+// Len returns the number of bytes of the buffer.
+func (b *BufferAt) Len() int { return len(b.buf) }
+
+// String returns the contents of the buffer as a string.
+func (b *BufferAt) String() string { return string(b.buf) }
+
+// Reset resets the buffer to be empty.
+func (b *BufferAt) Reset() { b.buf = b.buf[:0] }
@@ -1,6 +1,7 @@
 package bytes
 
 import (
+	"io"
 	"testing"
 )
 
@@ -30,3 +31,28 @@ func TestBufferAt(t *testing.T) {
 		t.Fatalf("write: get %q, want %q", get, expected)
 	}
 }
+
+func TestBufferAtGrow(t *testing.T) {
+	buf := new(BufferAt)
+
+	n, err := buf.WriteAt([]byte("abc"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("write size: get %d, want %d", n, 3)
+	}
+	expected := "\x00\x00\x00\x00abc"
+	if get := buf.String(); get != expected {
+		t.Fatalf("write: get %q, want %q", get, expected)
+	}
+
+	p := make([]byte, 4)
+	n, err = buf.ReadAt(p, 5)
+	if err != io.EOF {
+		t.Fatalf("read error: get %v, want %v", err, io.EOF)
+	}
+	if get := string(p[:n]); get != "bc" {
+		t.Fatalf("read: get %q, want %q", get, "bc")
+	}
+}
@@ -0,0 +1,152 @@
+package bzip2
+
+// sortRotations sorts the cyclic rotations of data by prefix doubling,
+// and returns the sorted start positions in sa.
+// The slices sa, rank, tmp and cnt must have the length of data.
+func sortRotations(data []byte, sa, rank, tmp, cnt []int32) {
+	n := int32(len(data))
+
+	var start [256]int32
+	for _, b := range data {
+		start[b]++
+	}
+	sum := int32(0)
+	for i, c := range start {
+		start[i] = sum
+		sum += c
+	}
+	for i, b := range data {
+		sa[start[b]] = int32(i)
+		start[b]++
+	}
+
+	classes := int32(1)
+	rank[sa[0]] = 0
+	for j := int32(1); j < n; j++ {
+		if data[sa[j]] != data[sa[j-1]] {
+			classes++
+		}
+		rank[sa[j]] = classes - 1
+	}
+
+	for k := int32(1); k < n && classes < n; k <<= 1 {
+		// order by the second half, then sort stably by the first half
+		for j, s := range sa {
+			if s -= k; s < 0 {
+				s += n
+			}
+			tmp[j] = s
+		}
+		c := cnt[:classes]
+		for i := range c {
+			c[i] = 0
+		}
+		for _, s := range tmp {
+			c[rank[s]]++
+		}
+		for i := int32(1); i < classes; i++ {
+			c[i] += c[i-1]
+		}
+		for j := n - 1; j >= 0; j-- {
+			s := tmp[j]
+			c[rank[s]]--
+			sa[c[rank[s]]] = s
+		}
+
+		classes = 1
+		tmp[sa[0]] = 0
+		for j := int32(1); j < n; j++ {
+			cur, prev := sa[j], sa[j-1]
+			cur2, prev2 := cur+k, prev+k
+			if cur2 >= n {
+				cur2 -= n
+			}
+			if prev2 >= n {
+				prev2 -= n
+			}
+			if rank[cur] != rank[prev] || rank[cur2] != rank[prev2] {
+				classes++
+			}
+			tmp[cur] = classes - 1
+		}
+		rank, tmp = tmp, rank
+	}
+}
+
+// huffmanLengths sets the code lengths of the symbols, at most maxLen.
+// Unused symbols get codes too, because a bzip2 table covers
+// the whole alphabet.
+func huffmanLengths(lengths []uint8, freq []int32, maxLen int) {
+	n := len(freq)
+	weight := make([]int64, 2*n-1)
+	parent := make([]int32, 2*n-1)
+	depth := make([]uint8, 2*n-1)
+	order := make([]int32, n)
+	for i, f := range freq {
+		if f == 0 {
+			f = 1
+		}
+		weight[i] = int64(f)
+	}
+
+	for {
+		// insertion sort is enough for the small alphabet
+		for i := range order {
+			j := i
+			for ; j > 0 && weight[order[j-1]] > weight[i]; j-- {
+				order[j] = order[j-1]
+			}
+			order[j] = int32(i)
+		}
+
+		// two queues: the sorted leaves and the internal nodes
+		leaf, node, next := 0, n, n
+		pop := func() int32 {
+			if leaf < n && (node == next || weight[order[leaf]] <= weight[node]) {
+				leaf++
+				return order[leaf-1]
+			}
+			node++
+			return int32(node - 1)
+		}
+		for ; next < 2*n-1; next++ {
+			a, b := pop(), pop()
+			weight[next] = weight[a] + weight[b]
+			parent[a], parent[b] = int32(next), int32(next)
+		}
+
+		depth[2*n-2] = 0
+		for i := 2*n - 3; i >= 0; i-- {
+			depth[i] = depth[parent[i]] + 1
+		}
+		tooLong := false
+		for i := 0; i < n; i++ {
+			lengths[i] = depth[i]
+			if int(depth[i]) > maxLen {
+				tooLong = true
+			}
+		}
+		if !tooLong {
+			return
+		}
+
+		// flatten the distribution and try again
+		for i := 0; i < n; i++ {
+			weight[i] = 1 + weight[i]/2
+		}
+	}
+}
+
+// huffmanCodes assigns the canonical codes of the lengths.
+func huffmanCodes(codes []uint32, lengths []uint8) {
+	code := uint32(0)
+	for l := uint8(1); l <= maxCodeLen; l++ {
+		for i, n := range lengths {
+			if n == l {
+				codes[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+}
@@ -0,0 +1,70 @@
+// Package bzip2 implements writing of the bzip2 compressed data format.
+// The standard compress/bzip2 package reads the data.
+package bzip2
+
+const (
+	BestSpeed          = 1 // 100k blocks
+	BestCompression    = 9 // 900k blocks
+	DefaultCompression = BestCompression
+)
+
+const (
+	blockMagic1 = 0x314159 // pi
+	blockMagic2 = 0x265359
+	endMagic1   = 0x177245 // sqrt(pi)
+	endMagic2   = 0x385090
+
+	runA = 0
+	runB = 1
+
+	maxAlphaSize = 258
+	maxCodeLen   = 17
+	minGroups    = 2
+	maxGroups    = 6
+	groupSize    = 50
+	maxRunLen    = 255
+)
+
+// crcTable is the table of the big-endian CRC-32 used by bzip2.
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return
+}()
+
+func updateCRC(crc uint32, b byte) uint32 {
+	return crc<<8 ^ crcTable[byte(crc>>24)^b]
+}
+
+// bitWriter writes bits most significant first.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+// write writes the low n bits of v, n <= 32.
+func (b *bitWriter) write(n uint, v uint32) {
+	b.acc = b.acc<<n | uint64(v)&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.out = append(b.out, byte(b.acc>>b.nbits))
+	}
+}
+
+// pad writes zero bits up to the byte boundary.
+func (b *bitWriter) pad() {
+	if b.nbits > 0 {
+		b.write(8-b.nbits, 0)
+	}
+}
@@ -0,0 +1,91 @@
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 2000)
+	random := make([]byte, 150000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// runs of all lengths, and periodic data
+	var runs []byte
+	for n := 1; n < 600; n++ {
+		runs = append(runs, bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+	periodic := bytes.Repeat([]byte("ab"), 150000)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("hello")},
+		{"zeros", make([]byte, 300000)},
+		{"text", text},
+		{"random", random},
+		{"runs", runs},
+		{"periodic", periodic},
+	}
+	for _, test := range tests {
+		for _, level := range []int{BestSpeed, BestCompression} {
+			buf := new(bytes.Buffer)
+			w, err := NewWriterLevel(buf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p := test.data; len(p) > 0; {
+				n := min(len(p), 10000)
+				if _, err := w.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				p = p[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(test.data) > 1000 && test.name != "random" && buf.Len() >= len(test.data)/2 {
+				t.Errorf("%s, level %d: compressed size %d of %d", test.name, level, buf.Len(), len(test.data))
+			}
+
+			got, err := ioutil.ReadAll(bzip2.NewReader(buf))
+			if err != nil {
+				t.Fatalf("%s, level %d: %v", test.name, level, err)
+			}
+			if !bytes.Equal(got, test.data) {
+				t.Fatalf("%s, level %d: decoded data is different", test.name, level)
+			}
+		}
+	}
+
+	if _, err := NewWriterLevel(ioutil.Discard, BestCompression+1); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w := NewWriter(nil)
+	for _, s := range []string{"first data, first data", "second data, second data"} {
+		buf := new(bytes.Buffer)
+		w.Reset(buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(bzip2.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package bzip2
+
+import (
+	"errors"
+	"io"
+)
+
+// A Writer compresses the written data into a bzip2 stream.
+type Writer struct {
+	w        io.Writer
+	level    int
+	bw       bitWriter
+	err      error
+	header   bool   // the stream header is written
+	block    []byte // run-length encoded data of the current block
+	crc      uint32 // crc of the current block
+	combined uint32 // crc of the stream
+	run      byte
+	runLen   int
+
+	// buffers for the block sorting and the entropy coding
+	sa, rank, tmp, cnt []int32
+	syms               []uint16
+	selectors          []uint8
+}
+
+// NewWriter returns a new Writer compressing with the default block size.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a new Writer compressing with blocks of level*100k bytes.
+// The level is from BestSpeed to BestCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, errors.New("bzip2: invalid compression level")
+	}
+	z := &Writer{level: level}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of the Writer and makes it write to w.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.bw = bitWriter{out: z.bw.out[:0]}
+	z.err = nil
+	z.header = false
+	z.block = z.block[:0]
+	z.crc = 0xffffffff
+	z.combined = 0
+	z.runLen = 0
+}
+
+// maxBlock is the limit of the encoded block size, as set by the bzip2 program.
+func (z *Writer) maxBlock() int {
+	return z.level*100000 - 19
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for _, b := range p {
+		if z.runLen > 0 && b == z.run && z.runLen < maxRunLen {
+			z.runLen++
+		} else {
+			z.flushRun()
+			// a run takes 5 bytes at most
+			if len(z.block)+5 > z.maxBlock() {
+				if err := z.writeBlock(); err != nil {
+					return 0, err
+				}
+			}
+			z.run, z.runLen = b, 1
+		}
+		z.crc = updateCRC(z.crc, b)
+	}
+	return len(p), nil
+}
+
+// Close writes the rest of the data and the end of the stream.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	z.flushRun()
+	if len(z.block) > 0 {
+		if err := z.writeBlock(); err != nil {
+			return err
+		}
+	}
+	z.writeHeader()
+	z.bw.write(24, endMagic1)
+	z.bw.write(24, endMagic2)
+	z.bw.write(32, z.combined)
+	z.bw.pad()
+	if err := z.flush(); err != nil {
+		return err
+	}
+	z.err = errors.New("bzip2: write after close")
+	return nil
+}
+
+func (z *Writer) writeHeader() {
+	if !z.header {
+		z.bw.out = append(z.bw.out, 'B', 'Z', 'h', byte('0'+z.level))
+		z.header = true
+	}
+}
+
+func (z *Writer) flush() error {
+	if _, err := z.w.Write(z.bw.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.bw.out = z.bw.out[:0]
+	return nil
+}
+
+// flushRun appends the pending run to the block.
+// The runs of 4 or more bytes are written as 4 bytes and the rest count.
+func (z *Writer) flushRun() {
+	switch n := z.runLen; {
+	case n == 0:
+	case n < 4:
+		for i := 0; i < n; i++ {
+			z.block = append(z.block, z.run)
+		}
+	default:
+		z.block = append(z.block, z.run, z.run, z.run, z.run, byte(n-4))
+	}
+	z.runLen = 0
+}
+
+func (z *Writer) writeBlock() error {
+	z.writeHeader()
+	data := z.block
+	n := len(data)
+	crc := ^z.crc
+	z.combined = (z.combined<<1 | z.combined>>31) ^ crc
+
+	// block sorting
+	if cap(z.sa) < n {
+		size := z.maxBlock()
+		z.sa = make([]int32, size)
+		z.rank = make([]int32, size)
+		z.tmp = make([]int32, size)
+		z.cnt = make([]int32, size)
+	}
+	sa := z.sa[:n]
+	sortRotations(data, sa, z.rank[:n], z.tmp[:n], z.cnt[:n])
+	origPtr := 0
+
+	// move-to-front and zero run coding of the last column
+	var inUse [256]bool
+	for _, b := range data {
+		inUse[b] = true
+	}
+	var seq [256]byte
+	nInUse := 0
+	for i, used := range inUse {
+		if used {
+			seq[i] = byte(nInUse)
+			nInUse++
+		}
+	}
+	alphaSize := nInUse + 2
+	eob := uint16(nInUse + 1)
+
+	var mtf [256]byte
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+	var freq [maxAlphaSize]int32
+	syms := z.syms[:0]
+	zeros := 0
+	putZeros := func() {
+		for zeros--; ; zeros = (zeros - 2) / 2 {
+			s := uint16(runA)
+			if zeros&1 != 0 {
+				s = runB
+			}
+			syms = append(syms, s)
+			freq[s]++
+			if zeros < 2 {
+				break
+			}
+		}
+		zeros = 0
+	}
+	for j, s := range sa {
+		if s == 0 {
+			origPtr = j
+			s = int32(n)
+		}
+		c := seq[data[s-1]]
+		if mtf[0] == c {
+			zeros++
+			continue
+		}
+		if zeros > 0 {
+			putZeros()
+		}
+		i := 1
+		for mtf[i] != c {
+			i++
+		}
+		copy(mtf[1:i+1], mtf[:i])
+		mtf[0] = c
+		syms = append(syms, uint16(i+1))
+		freq[i+1]++
+	}
+	if zeros > 0 {
+		putZeros()
+	}
+	syms = append(syms, eob)
+	freq[eob]++
+	z.syms = syms
+
+	// the huffman tables
+	nGroups := maxGroups
+	switch nSyms := len(syms); {
+	case nSyms < 200:
+		nGroups = 2
+	case nSyms < 600:
+		nGroups = 3
+	case nSyms < 1200:
+		nGroups = 4
+	case nSyms < 2400:
+		nGroups = 5
+	}
+	var lengths [maxGroups][maxAlphaSize]uint8
+
+	// the initial tables cover the ranges of the symbols of about equal frequency
+	remain := int32(len(syms))
+	gs := 0
+	for part := nGroups; part > 0; part-- {
+		target := remain / int32(part)
+		ge := gs - 1
+		sum := int32(0)
+		for sum < target && ge < alphaSize-1 {
+			ge++
+			sum += freq[ge]
+		}
+		if ge > gs && part != nGroups && part != 1 && (nGroups-part)%2 == 1 {
+			sum -= freq[ge]
+			ge--
+		}
+		for v := 0; v < alphaSize; v++ {
+			if v >= gs && v <= ge {
+				lengths[part-1][v] = 0
+			} else {
+				lengths[part-1][v] = 15
+			}
+		}
+		gs = ge + 1
+		remain -= sum
+	}
+
+	// refine the tables by choosing the best table for every group
+	nSelectors := (len(syms) + groupSize - 1) / groupSize
+	if cap(z.selectors) < nSelectors {
+		z.selectors = make([]uint8, nSelectors)
+	}
+	selectors := z.selectors[:nSelectors]
+	for iter := 0; iter < 4; iter++ {
+		var groupFreq [maxGroups][maxAlphaSize]int32
+		for g := range selectors {
+			group := syms[g*groupSize : min((g+1)*groupSize, len(syms))]
+			best, bestCost := 0, -1
+			for t := 0; t < nGroups; t++ {
+				cost := 0
+				for _, s := range group {
+					cost += int(lengths[t][s])
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = t, cost
+				}
+			}
+			selectors[g] = uint8(best)
+			for _, s := range group {
+				groupFreq[best][s]++
+			}
+		}
+		for t := 0; t < nGroups; t++ {
+			huffmanLengths(lengths[t][:alphaSize], groupFreq[t][:alphaSize], maxCodeLen)
+		}
+	}
+
+	// block header
+	bw := &z.bw
+	bw.write(24, blockMagic1)
+	bw.write(24, blockMagic2)
+	bw.write(32, crc)
+	bw.write(1, 0) // not randomized
+	bw.write(24, uint32(origPtr))
+
+	var used16 uint32
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				used16 |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	bw.write(16, used16)
+	for i := 0; i < 16; i++ {
+		if used16&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var bits uint32
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				bits |= 1 << uint(15-j)
+			}
+		}
+		bw.write(16, bits)
+	}
+
+	// selectors by move-to-front in unary
+	bw.write(3, uint32(nGroups))
+	bw.write(15, uint32(nSelectors))
+	var order [maxGroups]uint8
+	for i := range order {
+		order[i] = uint8(i)
+	}
+	for _, sel := range selectors {
+		i := 0
+		for order[i] != sel {
+			i++
+		}
+		copy(order[1:i+1], order[:i])
+		order[0] = sel
+		bw.write(uint(i+1), 1<<uint(i+1)-2)
+	}
+
+	// code lengths by deltas
+	var codes [maxGroups][maxAlphaSize]uint32
+	for t := 0; t < nGroups; t++ {
+		l := lengths[t][:alphaSize]
+		cur := l[0]
+		bw.write(5, uint32(cur))
+		for _, want := range l {
+			for ; cur < want; cur++ {
+				bw.write(2, 2)
+			}
+			for ; cur > want; cur-- {
+				bw.write(2, 3)
+			}
+			bw.write(1, 0)
+		}
+		huffmanCodes(codes[t][:alphaSize], l)
+	}
+
+	// symbols
+	for g, t := range selectors {
+		group := syms[g*groupSize : min((g+1)*groupSize, len(syms))]
+		for _, s := range group {
+			bw.write(uint(lengths[t][s]), codes[t][s])
+		}
+	}
+
+	z.block = z.block[:0]
+	z.crc = 0xffffffff
+	return z.flush()
+}
@@ -0,0 +1,61 @@
+package deflate64
+
+import "math/bits"
+
+const (
+	maxCodeLen   = 15 // the maximum length of the codes
+	numLitCodes  = 288
+	numDistCodes = 32
+	tableBits    = 9 // the codes up to this length are looked up at once
+)
+
+// huffman decodes the canonical Huffman codes of a block.
+type huffman struct {
+	table  [1 << tableBits]uint16 // symbol<<4 | length, by the reversed short codes
+	count  [maxCodeLen + 1]uint16 // the number of the codes by the length
+	symbol [numLitCodes]uint16    // the symbols ordered by the codes
+}
+
+// init builds the codes from the code lengths of the symbols.
+// Incomplete codes are accepted; the unused codes are corrupt data.
+func (h *huffman) init(lengths []uint8) error {
+	h.count = [maxCodeLen + 1]uint16{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	left := 1
+	for l := 1; l <= maxCodeLen; l++ {
+		left = left<<1 - int(h.count[l])
+		if left < 0 {
+			return errCorrupt // over-subscribed
+		}
+	}
+
+	var offs [maxCodeLen + 1]uint16
+	for l := 1; l < maxCodeLen; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for s, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = uint16(s)
+			offs[l]++
+		}
+	}
+
+	h.table = [1 << tableBits]uint16{}
+	code, i := 0, 0
+	for l := 1; l <= tableBits; l++ {
+		for n := int(h.count[l]); n > 0; n-- {
+			e := h.symbol[i]<<4 | uint16(l)
+			for j := int(bits.Reverse16(uint16(code)) >> (16 - l)); j < len(h.table); j += 1 << l {
+				h.table[j] = e
+			}
+			code++
+			i++
+		}
+		code <<= 1
+	}
+	return nil
+}
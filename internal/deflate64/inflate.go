@@ -0,0 +1,426 @@
+// Package deflate64 implements the decompression of Deflate64 data,
+// the enhanced deflate of zip archives (method 9).
+//
+// Deflate64 is deflate with a 64 KiB window. The last length code
+// holds 16 extra bits, and two more distance codes reach the window.
+package deflate64
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+const (
+	windowSize = 1 << 16
+	windowMask = windowSize - 1
+)
+
+var errCorrupt = errors.New("deflate64: corrupt input")
+
+// the bases and the numbers of extra bits of the length codes 257..285
+var (
+	lengthBase = [...]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3,
+	}
+	lengthExtra = [...]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16,
+	}
+)
+
+// the bases and the numbers of extra bits of the distance codes
+var (
+	distBase = [numDistCodes]uint32{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145,
+		8193, 12289, 16385, 24577, 32769, 49153,
+	}
+	distExtra = [numDistCodes]uint8{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14,
+	}
+)
+
+// the order of the code lengths of the code length codes
+var codeOrder = [...]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+var fixedLit, fixedDist huffman
+
+func init() {
+	var lengths [numLitCodes]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	fixedLit.init(lengths[:])
+	for i := range lengths[:numDistCodes] {
+		lengths[i] = 5
+	}
+	fixedDist.init(lengths[:numDistCodes])
+}
+
+const (
+	blockNone    = iota // the next block header is read
+	blockStored         // the bytes of a stored block are read
+	blockHuffman        // the symbols of a compressed block are read
+)
+
+// A Reader decompresses Deflate64 data.
+type Reader struct {
+	r     *bufio.Reader
+	bits  uint64 // the bits read, not used yet
+	nbits uint
+
+	hist   []byte // the window
+	wpos   int    // the next position to write in hist
+	nout   int    // the number of the decoded bytes not returned yet
+	filled int    // the number of the bytes in hist
+
+	block     int
+	final     bool // the current block is the last block
+	stored    int  // the rest of the stored block
+	copyLen   int  // the rest of the current match
+	copyDist  int
+	lit, dist *huffman
+	dyn       [2]huffman
+	clen      huffman
+
+	err error
+}
+
+// NewReader returns a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	z := new(Reader)
+	z.Reset(r)
+	return z
+}
+
+// Reset discards the state of the Reader and makes it read from r.
+func (z *Reader) Reset(r io.Reader) {
+	if z.r == nil {
+		z.r = bufio.NewReader(r)
+	} else {
+		z.r.Reset(r)
+	}
+	if z.hist == nil {
+		z.hist = make([]byte, windowSize)
+	}
+	z.bits, z.nbits = 0, 0
+	z.wpos, z.nout, z.filled = 0, 0, 0
+	z.block = blockNone
+	z.final = false
+	z.stored, z.copyLen = 0, 0
+	z.err = nil
+	if r == nil {
+		z.err = io.ErrClosedPipe
+	}
+}
+
+// Read reads the decompressed data.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.nout == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.decode()
+	}
+	n := 0
+	for n < len(p) && z.nout > 0 {
+		start := (z.wpos - z.nout) & windowMask
+		end := min(start+z.nout, windowSize)
+		m := copy(p[n:], z.hist[start:end])
+		n += m
+		z.nout -= m
+	}
+	return n, nil
+}
+
+// Close closes the Reader. It does not close the underlying reader.
+func (z *Reader) Close() error {
+	z.err = io.ErrClosedPipe
+	return nil
+}
+
+// decode decodes the data until the window is full of the bytes
+// not returned yet, or until the end of the data or an error.
+func (z *Reader) decode() {
+	for z.nout < windowSize && z.err == nil {
+		switch {
+		case z.copyLen > 0:
+			z.copyMatch()
+		case z.block == blockStored:
+			z.readStored()
+		case z.block == blockHuffman:
+			z.decodeSymbol()
+		case z.final:
+			z.err = io.EOF
+		default:
+			z.readHeader()
+		}
+	}
+}
+
+func (z *Reader) advance(n int) {
+	z.wpos = (z.wpos + n) & windowMask
+	z.nout += n
+	z.filled = min(z.filled+n, windowSize)
+}
+
+func (z *Reader) put(b byte) {
+	z.hist[z.wpos] = b
+	z.advance(1)
+}
+
+func (z *Reader) copyMatch() {
+	for z.copyLen > 0 && z.nout < windowSize {
+		src := (z.wpos - z.copyDist) & windowMask
+		n := min(z.copyLen, windowSize-z.nout, windowSize-z.wpos, windowSize-src)
+		if z.copyDist < n {
+			// the match overlaps itself
+			n = z.copyDist
+		}
+		copy(z.hist[z.wpos:z.wpos+n], z.hist[src:src+n])
+		z.advance(n)
+		z.copyLen -= n
+	}
+}
+
+// more reads a byte into the bits.
+func (z *Reader) more() bool {
+	b, err := z.r.ReadByte()
+	if err != nil {
+		z.err = noEOF(err)
+		return false
+	}
+	z.bits |= uint64(b) << z.nbits
+	z.nbits += 8
+	return true
+}
+
+// getBits reads n bits, n <= 16.
+func (z *Reader) getBits(n uint) int {
+	for z.nbits < n {
+		if !z.more() {
+			return 0
+		}
+	}
+	v := int(z.bits & (1<<n - 1))
+	z.bits >>= n
+	z.nbits -= n
+	return v
+}
+
+// sym reads a symbol of the codes h.
+func (z *Reader) sym(h *huffman) int {
+	for z.nbits < maxCodeLen && z.r.Buffered() > 0 {
+		z.more()
+	}
+	for {
+		if e := h.table[z.bits&(1<<tableBits-1)]; e != 0 && uint(e&15) <= z.nbits {
+			z.bits >>= e & 15
+			z.nbits -= uint(e & 15)
+			return int(e >> 4)
+		}
+
+		// the long codes, bit by bit
+		code, first, index := 0, 0, 0
+		for l := uint(1); l <= maxCodeLen && l <= z.nbits; l++ {
+			code |= int(z.bits>>(l-1)) & 1
+			count := int(h.count[l])
+			if code-first < count {
+				z.bits >>= l
+				z.nbits -= l
+				return int(h.symbol[index+code-first])
+			}
+			index += count
+			first = (first + count) << 1
+			code <<= 1
+		}
+		if z.nbits >= maxCodeLen {
+			z.err = errCorrupt
+			return 0
+		}
+		if !z.more() {
+			return 0
+		}
+	}
+}
+
+func (z *Reader) readHeader() {
+	v := z.getBits(3)
+	if z.err != nil {
+		return
+	}
+	z.final = v&1 != 0
+	switch v >> 1 {
+	case 0:
+		// stored block, from the next byte
+		z.bits >>= z.nbits % 8
+		z.nbits -= z.nbits % 8
+		n := z.getBits(16)
+		nn := z.getBits(16)
+		if z.err != nil {
+			return
+		}
+		if n != ^nn&0xffff {
+			z.err = errCorrupt
+			return
+		}
+		z.stored = n
+		z.block = blockStored
+	case 1:
+		z.lit, z.dist = &fixedLit, &fixedDist
+		z.block = blockHuffman
+	case 2:
+		z.readDynamic()
+		z.lit, z.dist = &z.dyn[0], &z.dyn[1]
+		z.block = blockHuffman
+	default:
+		z.err = errCorrupt
+	}
+}
+
+// readDynamic reads the codes of a dynamic Huffman block.
+func (z *Reader) readDynamic() {
+	nlit := z.getBits(5) + 257
+	ndist := z.getBits(5) + 1
+	nclen := z.getBits(4) + 4
+	if z.err != nil {
+		return
+	}
+	if nlit > 286 {
+		z.err = errCorrupt
+		return
+	}
+
+	var lengths [numLitCodes + numDistCodes]uint8
+	for _, i := range codeOrder[:nclen] {
+		lengths[i] = uint8(z.getBits(3))
+	}
+	if z.err != nil {
+		return
+	}
+	if z.err = z.clen.init(lengths[:len(codeOrder)]); z.err != nil {
+		return
+	}
+
+	lengths = [numLitCodes + numDistCodes]uint8{}
+	for i := 0; i < nlit+ndist; {
+		sym := z.sym(&z.clen)
+		if z.err != nil {
+			return
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var n int
+		var l uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				z.err = errCorrupt
+				return
+			}
+			l = lengths[i-1]
+			n = 3 + z.getBits(2)
+		case 17:
+			n = 3 + z.getBits(3)
+		default:
+			n = 11 + z.getBits(7)
+		}
+		if z.err != nil {
+			return
+		}
+		if i+n > nlit+ndist {
+			z.err = errCorrupt
+			return
+		}
+		for ; n > 0; n-- {
+			lengths[i] = l
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		// no end of block
+		z.err = errCorrupt
+		return
+	}
+	if z.err = z.dyn[0].init(lengths[:nlit]); z.err != nil {
+		return
+	}
+	z.err = z.dyn[1].init(lengths[nlit : nlit+ndist])
+}
+
+func (z *Reader) readStored() {
+	for z.stored > 0 && z.nout < windowSize {
+		if z.nbits > 0 {
+			b := byte(z.getBits(8))
+			z.put(b)
+			z.stored--
+			continue
+		}
+		n := min(z.stored, windowSize-z.nout, windowSize-z.wpos)
+		m, err := io.ReadFull(z.r, z.hist[z.wpos:z.wpos+n])
+		z.advance(m)
+		z.stored -= m
+		if err != nil {
+			z.err = noEOF(err)
+			return
+		}
+	}
+	if z.stored == 0 {
+		z.block = blockNone
+	}
+}
+
+func (z *Reader) decodeSymbol() {
+	sym := z.sym(z.lit)
+	if z.err != nil {
+		return
+	}
+	switch {
+	case sym < 256:
+		z.put(byte(sym))
+		return
+	case sym == 256:
+		z.block = blockNone
+		return
+	case sym-257 >= len(lengthBase):
+		z.err = errCorrupt
+		return
+	}
+	sym -= 257
+	length := int(lengthBase[sym]) + z.getBits(uint(lengthExtra[sym]))
+	d := z.sym(z.dist)
+	if z.err != nil {
+		return
+	}
+	dist := int(distBase[d]) + z.getBits(uint(distExtra[d]))
+	if z.err != nil {
+		return
+	}
+	if dist > z.filled {
+		z.err = errCorrupt
+		return
+	}
+	z.copyLen, z.copyDist = length, dist
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package deflate64
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"math/bits"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// bitWriter writes the bits of deflate data.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v int, n uint) {
+	w.bits |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// writeCode writes the Huffman code, from the most significant bit.
+func (w *bitWriter) writeCode(code int, n uint) {
+	w.write(int(bits.Reverse16(uint16(code))>>(16-n)), n)
+}
+
+// writeFixed writes the symbol with the fixed literal/length codes.
+func (w *bitWriter) writeFixed(sym int) {
+	switch {
+	case sym < 144:
+		w.writeCode(0x30+sym, 8)
+	case sym < 256:
+		w.writeCode(0x190+sym-144, 9)
+	case sym < 280:
+		w.writeCode(sym-256, 7)
+	default:
+		w.writeCode(0xc0+sym-280, 8)
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.write(0, 8-w.nbits)
+	}
+	return w.out
+}
+
+func TestReaderFlate(t *testing.T) {
+	// deflate data without matches of 258 bytes is Deflate64 data
+	data, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for level := flate.HuffmanOnly; level <= flate.BestCompression; level++ {
+		buf := new(bytes.Buffer)
+		w, _ := flate.NewWriter(buf, level)
+		w.Write(data)
+		w.Close()
+
+		got, err := ioutil.ReadAll(NewReader(buf))
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("level %d: decoded data is different", level)
+		}
+	}
+}
+
+func TestReaderLongMatch(t *testing.T) {
+	random := make([]byte, 40000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	w := new(bitWriter)
+	w.write(0, 3) // stored block
+	w.bytes()
+	w.write(len(random), 16)
+	w.write(^len(random)&0xffff, 16)
+	w.out = append(w.out, random...)
+
+	want := append([]byte(nil), random...)
+	w.write(1|1<<1, 3) // final fixed block
+	for _, m := range []struct {
+		length, dist int
+	}{
+		{1000, 40000},  // distance code 30
+		{65538, 1},     // the longest
+		{100, 65536},   // distance code 31, the farthest
+		{258, 3},       // length code 284
+		{65536, 65536}, // from the window only
+	} {
+		w.writeFixed(285)
+		w.write(m.length-3, 16)
+		d := 0
+		for d+1 < len(distBase) && int(distBase[d+1]) <= m.dist {
+			d++
+		}
+		w.writeCode(d, 5)
+		w.write(m.dist-int(distBase[d]), uint(distExtra[d]))
+		for i := 0; i < m.length; i++ {
+			want = append(want, want[len(want)-m.dist])
+		}
+	}
+	w.writeFixed('!')
+	w.writeFixed(256)
+	want = append(want, '!')
+	data := w.bytes()
+
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoded data is different")
+	}
+
+	// read byte by byte
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+	got, err = ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoded data is different")
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	fixed := func(f func(w *bitWriter)) []byte {
+		w := new(bitWriter)
+		w.write(1|1<<1, 3)
+		f(w)
+		w.writeFixed(256)
+		return w.bytes()
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"block type", []byte{1 | 3<<1}, errCorrupt},
+		{"stored length", []byte{1, 5, 0, 5, 0}, errCorrupt},
+		{"stored data", []byte{1, 5, 0, 0xfa, 0xff, 'a'}, io.ErrUnexpectedEOF},
+		{"no final block", []byte{0, 0, 0, 0xff, 0xff}, io.ErrUnexpectedEOF},
+		{"length code", fixed(func(w *bitWriter) {
+			w.writeFixed('a')
+			w.writeFixed(286)
+		}), errCorrupt},
+		{"distance", fixed(func(w *bitWriter) {
+			w.writeFixed('a')
+			w.writeFixed(257)
+			w.writeCode(1, 5)
+		}), errCorrupt},
+		{"no end of block", func() []byte {
+			w := new(bitWriter)
+			w.write(1|2<<1, 3)
+			w.write(0, 5)  // 257 codes
+			w.write(0, 5)  // 1 distance code
+			w.write(15, 4) // 19 code length codes
+			for range codeOrder {
+				w.write(5, 3)
+			}
+			for i := 0; i < 256; i++ {
+				w.writeCode(8, 5) // the literals of the length 8
+			}
+			w.writeCode(0, 5) // no end of block
+			w.writeCode(1, 5)
+			return w.bytes()
+		}(), errCorrupt},
+	}
+	for _, test := range tests {
+		_, err := ioutil.ReadAll(NewReader(bytes.NewReader(test.data)))
+		if err != test.err {
+			t.Errorf("%s: err=%v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// no panic on random data
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, rng.Intn(1000))
+		rng.Read(b)
+		ioutil.ReadAll(NewReader(bytes.NewReader(b)))
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	r := NewReader(nil)
+	if _, err := r.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Fatalf("err=%v, want %v", err, io.ErrClosedPipe)
+	}
+	for _, s := range []string{"first data, first data", "second data, second data"} {
+		buf := new(bytes.Buffer)
+		w, _ := flate.NewWriter(buf, flate.BestCompression)
+		io.WriteString(w, s)
+		w.Close()
+
+		r.Reset(buf)
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package legacy
+
+import "io"
+
+const (
+	sfMaxLen          = 16 // the maximum length of the Shannon-Fano codes
+	implodeWindowSize = 1 << 13
+)
+
+// sfTree decodes the Shannon-Fano codes of imploded data.
+type sfTree struct {
+	count  [sfMaxLen + 1]int // the number of the codes by the length
+	start  [sfMaxLen + 1]int // the smallest code of the length
+	index  [sfMaxLen + 1]int // the index of the first symbol of the length
+	symbol [256]uint8        // the symbols ordered by the length
+}
+
+// init builds the codes from the code lengths. The codes are assigned
+// from the longest codes, the last symbol first.
+func (t *sfTree) init(lengths []uint8) error {
+	t.count = [sfMaxLen + 1]int{}
+	for _, l := range lengths {
+		t.count[l]++
+	}
+	i := 0
+	for l := 1; l <= sfMaxLen; l++ {
+		t.index[l] = i
+		for s, sl := range lengths {
+			if int(sl) == l {
+				t.symbol[i] = uint8(s)
+				i++
+			}
+		}
+	}
+
+	code := 0 // as 16-bit value
+	for l := sfMaxLen; l >= 1; l-- {
+		if t.count[l] == 0 {
+			continue
+		}
+		if code&(1<<(sfMaxLen-l)-1) != 0 {
+			return errCorrupt
+		}
+		t.start[l] = code >> (sfMaxLen - l)
+		code += t.count[l] << (sfMaxLen - l)
+	}
+	if code > 1<<sfMaxLen {
+		return errCorrupt
+	}
+	return nil
+}
+
+func (t *sfTree) decode(br *bitReader) (int, bool) {
+	code := 0
+	for l := 1; l <= sfMaxLen; l++ {
+		b, ok := br.read(1)
+		if !ok {
+			return 0, false
+		}
+		code = code<<1 | b
+		if i := code - t.start[l]; i >= 0 && i < t.count[l] {
+			return int(t.symbol[t.index[l]+t.count[l]-1-i]), true
+		}
+	}
+	br.err = errCorrupt
+	return 0, false
+}
+
+// An ImplodeReader decompresses imploded data: the matches of a sliding
+// dictionary of 4 KiB or 8 KiB, coded by Shannon-Fano trees of the
+// lengths and the distances, and optionally of the literals.
+type ImplodeReader struct {
+	br          bitReader
+	largeDict   bool // the dictionary is 8 KiB
+	literalTree bool // the literals are coded by a tree
+	started     bool // the trees are read
+	lit         sfTree
+	length      sfTree
+	dist        sfTree
+
+	hist     [implodeWindowSize]byte
+	pos      int
+	copyLen  int
+	copyDist int
+	err      error
+}
+
+// NewImplodeReader returns a new ImplodeReader reading from r.
+// largeDict and literalTree are the variant of the data, given by
+// the flags of the file.
+func NewImplodeReader(r io.Reader, largeDict, literalTree bool) *ImplodeReader {
+	z := &ImplodeReader{largeDict: largeDict, literalTree: literalTree}
+	z.br.reset(r)
+	return z
+}
+
+// Read reads the decompressed data.
+func (z *ImplodeReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if !z.started {
+		if z.err = z.readTrees(); z.err != nil {
+			return 0, z.err
+		}
+		z.started = true
+	}
+
+	n := 0
+	for n < len(p) {
+		if z.copyLen > 0 {
+			b := z.hist[(z.pos-z.copyDist)&(implodeWindowSize-1)]
+			z.put(b)
+			p[n] = b
+			n++
+			z.copyLen--
+			continue
+		}
+		if err := z.decode(p, &n); err != nil {
+			z.err = err
+			break
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+func (z *ImplodeReader) put(b byte) {
+	z.hist[z.pos] = b
+	z.pos = (z.pos + 1) & (implodeWindowSize - 1)
+}
+
+// decode reads a literal to p, or a match.
+func (z *ImplodeReader) decode(p []byte, n *int) error {
+	br := &z.br
+	flag, ok := br.read(1)
+	if !ok {
+		return br.err
+	}
+	if flag == 1 {
+		var c int
+		if z.literalTree {
+			c, ok = z.lit.decode(br)
+		} else {
+			c, ok = br.read(8)
+		}
+		if !ok {
+			return br.err
+		}
+		z.put(byte(c))
+		p[*n] = byte(c)
+		*n++
+		return nil
+	}
+
+	lowBits := uint(6)
+	if z.largeDict {
+		lowBits = 7
+	}
+	low, ok := br.read(lowBits)
+	if !ok {
+		return br.err
+	}
+	high, ok := z.dist.decode(br)
+	if !ok {
+		return br.err
+	}
+	length, ok := z.length.decode(br)
+	if !ok {
+		return br.err
+	}
+	if length == 63 {
+		extra, ok := br.read(8)
+		if !ok {
+			return br.err
+		}
+		length += extra
+	}
+	// the minimum length of the matches
+	if z.literalTree {
+		length += 3
+	} else {
+		length += 2
+	}
+	z.copyDist = high<<lowBits | low + 1
+	z.copyLen = length
+	return nil
+}
+
+// readTrees reads the literal tree, if any, the length tree and the
+// distance tree.
+func (z *ImplodeReader) readTrees() error {
+	if z.literalTree {
+		if err := z.readTree(&z.lit, 256); err != nil {
+			return err
+		}
+	}
+	if err := z.readTree(&z.length, 64); err != nil {
+		return err
+	}
+	return z.readTree(&z.dist, 64)
+}
+
+// readTree reads the code lengths of n symbols: the number of bytes,
+// and the bytes of the numbers of the symbols and their lengths.
+func (z *ImplodeReader) readTree(t *sfTree, n int) error {
+	br := &z.br
+	nb, ok := br.read(8)
+	if !ok {
+		return br.err
+	}
+	var lengths [256]uint8
+	k := 0
+	for i := 0; i <= nb; i++ {
+		b, ok := br.read(8)
+		if !ok {
+			return br.err
+		}
+		count, l := b>>4+1, b&0xf+1
+		if k+count > n {
+			return errCorrupt
+		}
+		for ; count > 0; count-- {
+			lengths[k] = uint8(l)
+			k++
+		}
+	}
+	if k != n {
+		return errCorrupt
+	}
+	return t.init(lengths[:n])
+}
@@ -0,0 +1,54 @@
+// Package legacy implements the decompression of the legacy methods of
+// zip archives: Shrink (method 1), Reduce (methods 2 to 5) and
+// Implode (method 6).
+//
+// Reduced and imploded data has no end marker; the readers should be
+// limited to the uncompressed size.
+package legacy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var errCorrupt = errors.New("legacy: corrupt input")
+
+// bitReader reads the bits of the data, from the least significant bit.
+type bitReader struct {
+	r     io.ByteReader
+	bits  uint32
+	nbits uint
+	err   error
+}
+
+func (b *bitReader) reset(r io.Reader) {
+	if br, ok := r.(io.ByteReader); ok {
+		b.r = br
+	} else {
+		b.r = bufio.NewReader(r)
+	}
+	b.bits, b.nbits = 0, 0
+	b.err = nil
+}
+
+// read reads n bits, n <= 16. At the end of the data, it returns false
+// and b.err is io.ErrUnexpectedEOF.
+func (b *bitReader) read(n uint) (int, bool) {
+	for b.nbits < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.err = err
+			return 0, false
+		}
+		b.bits |= uint32(c) << b.nbits
+		b.nbits += 8
+	}
+	v := int(b.bits & (1<<n - 1))
+	b.bits >>= n
+	b.nbits -= n
+	return v, true
+}
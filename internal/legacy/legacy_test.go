@@ -0,0 +1,118 @@
+package legacy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// random letters, to free the codes by partial clearing
+	letters := append([]byte(nil), text...)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 40000; i++ {
+		letters = append(letters, byte('a'+rng.Intn(16)))
+	}
+
+	tests := []struct {
+		name string
+		want []byte
+		r    func(r io.Reader) io.Reader
+	}{
+		{"testdata/e-letters.shrink", letters, func(r io.Reader) io.Reader {
+			return NewShrinkReader(r)
+		}},
+		{"testdata/e.reduce1", text, func(r io.Reader) io.Reader {
+			z, _ := NewReduceReader(r, 1)
+			return z
+		}},
+		{"testdata/e.reduce4", text, func(r io.Reader) io.Reader {
+			z, _ := NewReduceReader(r, 4)
+			return z
+		}},
+		{"testdata/e.implode-4k", text, func(r io.Reader) io.Reader {
+			return NewImplodeReader(r, false, false)
+		}},
+		{"testdata/e.implode-8k-literals", text, func(r io.Reader) io.Reader {
+			return NewImplodeReader(r, true, true)
+		}},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = bytes.NewReader(data)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			r = io.LimitReader(test.r(r), int64(len(test.want)))
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Fatalf("%s: decoded data is different", test.name)
+			}
+		}
+	}
+
+	if _, err := NewReduceReader(nil, 5); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	tests := []struct {
+		name string
+		r    io.Reader
+		err  error
+	}{
+		{"shrink: first code", NewShrinkReader(bytes.NewReader([]byte{0x01, 0x03})), errCorrupt},
+		{"shrink: control code", NewShrinkReader(bytes.NewReader([]byte{0x00, 0x07, 0x00})), errCorrupt},
+		{"reduce: follower set", func() io.Reader {
+			z, _ := NewReduceReader(bytes.NewReader([]byte{63}), 1)
+			return z
+		}(), errCorrupt},
+		{"reduce: truncated", func() io.Reader {
+			z, _ := NewReduceReader(bytes.NewReader(make([]byte, 100)), 1)
+			return z
+		}(), io.ErrUnexpectedEOF},
+		{"implode: tree size", NewImplodeReader(bytes.NewReader([]byte{0, 0xff, 0, 0}), false, false), errCorrupt},
+		{"implode: tree lengths", NewImplodeReader(bytes.NewReader([]byte{3, 0xf0, 0xf0, 0xf0, 0xf0}), false, false), errCorrupt},
+	}
+	for _, test := range tests {
+		_, err := ioutil.ReadAll(test.r)
+		if err != test.err {
+			t.Errorf("%s: err=%v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// no panic on random data
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		b := make([]byte, rng.Intn(1000))
+		rng.Read(b)
+		for _, r := range []io.Reader{
+			NewShrinkReader(bytes.NewReader(b)),
+			func() io.Reader {
+				z, _ := NewReduceReader(bytes.NewReader(b), 1+i%4)
+				return z
+			}(),
+			NewImplodeReader(bytes.NewReader(b), i%2 == 0, i%3 == 0),
+		} {
+			io.Copy(ioutil.Discard, io.LimitReader(r, 1<<20))
+		}
+	}
+}
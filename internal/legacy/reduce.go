@@ -0,0 +1,169 @@
+package legacy
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+const (
+	reduceDLE        = 144 // the byte starting the matches
+	reduceMaxSet     = 32  // the maximum size of the follower sets
+	reduceWindowSize = 1 << 12
+)
+
+// A ReduceReader decompresses reduced data: the bytes are coded by
+// follower sets of the previous byte, and expanded with the matches
+// of the compression factor 1 to 4 (methods 2 to 5).
+type ReduceReader struct {
+	br        bitReader
+	factor    uint
+	started   bool // the follower sets are read
+	followers [256][]byte
+	sets      [256 * reduceMaxSet]byte
+	last      byte // the previous byte of the follower sets
+
+	state    int // the state of the expansion
+	v        int // the byte following DLE
+	length   int
+	hist     [reduceWindowSize]byte
+	pos      int
+	copyLen  int
+	copyDist int
+	err      error
+}
+
+// NewReduceReader returns a new ReduceReader reading from r,
+// decompressing the data of the compression factor from 1 to 4.
+func NewReduceReader(r io.Reader, factor int) (*ReduceReader, error) {
+	if factor < 1 || factor > 4 {
+		return nil, errors.New("legacy: invalid compression factor")
+	}
+	z := &ReduceReader{factor: uint(factor)}
+	z.br.reset(r)
+	return z, nil
+}
+
+// Read reads the decompressed data.
+func (z *ReduceReader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if !z.started {
+		if z.err = z.readFollowers(); z.err != nil {
+			return 0, z.err
+		}
+		z.started = true
+	}
+
+	mask := 1<<(8-z.factor) - 1
+	n := 0
+	for n < len(p) {
+		if z.copyLen > 0 {
+			b := z.hist[(z.pos-z.copyDist)&(reduceWindowSize-1)]
+			z.put(b)
+			p[n] = b
+			n++
+			z.copyLen--
+			continue
+		}
+
+		c, ok := z.next()
+		if !ok {
+			break
+		}
+		switch z.state {
+		case 0:
+			if c == reduceDLE {
+				z.state = 1
+				continue
+			}
+		case 1:
+			if c != 0 {
+				z.v = int(c)
+				z.length = z.v & mask
+				z.state = 3
+				if z.length == mask {
+					z.state = 2
+				}
+				continue
+			}
+			c = reduceDLE
+		case 2:
+			z.length += int(c)
+			z.state = 3
+			continue
+		case 3:
+			z.copyDist = z.v>>(8-z.factor)<<8 + int(c) + 1
+			z.copyLen = z.length + 3
+			z.state = 0
+			continue
+		}
+		z.state = 0
+		z.put(c)
+		p[n] = c
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+func (z *ReduceReader) put(b byte) {
+	z.hist[z.pos] = b
+	z.pos = (z.pos + 1) & (reduceWindowSize - 1)
+}
+
+// readFollowers reads the follower sets, from the last byte.
+func (z *ReduceReader) readFollowers() error {
+	for i := 255; i >= 0; i-- {
+		n, ok := z.br.read(6)
+		if !ok {
+			return z.br.err
+		}
+		if n > reduceMaxSet {
+			return errCorrupt
+		}
+		set := z.sets[i*reduceMaxSet : i*reduceMaxSet+n]
+		for j := range set {
+			b, ok := z.br.read(8)
+			if !ok {
+				return z.br.err
+			}
+			set[j] = byte(b)
+		}
+		z.followers[i] = set
+	}
+	return nil
+}
+
+// next reads the next byte of the follower sets.
+func (z *ReduceReader) next() (byte, bool) {
+	set := z.followers[z.last]
+	var c int
+	ok := true
+	if len(set) > 0 {
+		c, ok = z.br.read(1)
+	}
+	if !ok {
+		z.err = z.br.err
+		return 0, false
+	}
+	if len(set) == 0 || c == 1 {
+		c, ok = z.br.read(8)
+	} else {
+		c, ok = z.br.read(uint(max(bits.Len(uint(len(set)-1)), 1)))
+		if ok && c >= len(set) {
+			z.err = errCorrupt
+			return 0, false
+		}
+		c = int(set[c])
+	}
+	if !ok {
+		z.err = z.br.err
+		return 0, false
+	}
+	z.last = byte(c)
+	return z.last, true
+}
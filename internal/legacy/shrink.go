@@ -0,0 +1,172 @@
+package legacy
+
+import "io"
+
+const (
+	shrinkMinCodeSize = 9
+	shrinkMaxCodeSize = 13
+	shrinkMaxCode     = 1<<shrinkMaxCodeSize - 1
+	shrinkControl     = 256 // followed by a control code
+	shrinkIncCodeSize = 1   // the control code increasing the code size
+	shrinkClear       = 2   // the control code freeing the leaf codes
+	shrinkUnused      = -1  // the prefix of the unused codes
+)
+
+// A ShrinkReader decompresses shrunk data: LZW with codes of 9 to 13
+// bits, whose unused codes are freed by partial clearing.
+type ShrinkReader struct {
+	br       bitReader
+	codeSize uint
+	prefix   [shrinkMaxCode + 1]int16 // the prefix code of the codes above 256
+	suffix   [shrinkMaxCode + 1]byte  // the last byte of the codes above 256
+	free     []int16                  // the unused codes, in ascending order
+	prev     int                      // the previous code, or -1 at the start
+	str      []byte                   // the string of the last code
+	out      []byte                   // the rest of str to be read
+	err      error
+}
+
+// NewShrinkReader returns a new ShrinkReader reading from r.
+func NewShrinkReader(r io.Reader) *ShrinkReader {
+	z := new(ShrinkReader)
+	z.br.reset(r)
+	z.codeSize = shrinkMinCodeSize
+	for c := shrinkControl + 1; c <= shrinkMaxCode; c++ {
+		z.prefix[c] = shrinkUnused
+		z.free = append(z.free, int16(c))
+	}
+	z.prev = -1
+	return z
+}
+
+// Read reads the decompressed data.
+func (z *ShrinkReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(z.out) == 0 {
+			if z.err != nil {
+				break
+			}
+			if z.err = z.next(); z.err != nil {
+				continue
+			}
+			z.out = z.str
+		}
+		m := copy(p[n:], z.out)
+		z.out = z.out[m:]
+		n += m
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+// next reads the next code, and sets its string to z.str.
+func (z *ShrinkReader) next() error {
+	for {
+		code, ok := z.br.read(z.codeSize)
+		if !ok {
+			if z.br.err == io.ErrUnexpectedEOF {
+				// the rest bits are the padding
+				return io.EOF
+			}
+			return z.br.err
+		}
+		if code == shrinkControl {
+			if err := z.control(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if z.prev < 0 {
+			if code > 0xff {
+				return errCorrupt
+			}
+			z.str = append(z.str[:0], byte(code))
+			z.prev = code
+			return nil
+		}
+
+		next := -1
+		if len(z.free) > 0 {
+			next = int(z.free[0])
+		}
+		if code > shrinkControl && z.prefix[code] == shrinkUnused {
+			// The code is the next code, which is not added yet:
+			// the previous string and its first byte.
+			if code != next || !z.expand(z.prev) {
+				return errCorrupt
+			}
+			z.str = append(z.str, z.str[0])
+		} else if !z.expand(code) {
+			return errCorrupt
+		}
+
+		// The new code is the previous code and the first byte of the
+		// current string. The previous code may have been freed; the
+		// string of the new code is taken when the code is used.
+		if next >= 0 {
+			z.free = z.free[1:]
+			z.prefix[next] = int16(z.prev)
+			z.suffix[next] = z.str[0]
+		}
+		z.prev = code
+		return nil
+	}
+}
+
+func (z *ShrinkReader) control() error {
+	c, ok := z.br.read(z.codeSize)
+	if !ok {
+		return z.br.err
+	}
+	switch c {
+	case shrinkIncCodeSize:
+		if z.codeSize == shrinkMaxCodeSize {
+			return errCorrupt
+		}
+		z.codeSize++
+	case shrinkClear:
+		z.partialClear()
+	default:
+		return errCorrupt
+	}
+	return nil
+}
+
+// partialClear frees the codes which are not the prefix of any code.
+func (z *ShrinkReader) partialClear() {
+	var isPrefix [shrinkMaxCode + 1]bool
+	for c := shrinkControl + 1; c <= shrinkMaxCode; c++ {
+		if p := z.prefix[c]; p != shrinkUnused {
+			isPrefix[p] = true
+		}
+	}
+	z.free = z.free[:0]
+	for c := shrinkControl + 1; c <= shrinkMaxCode; c++ {
+		if !isPrefix[c] {
+			z.prefix[c] = shrinkUnused
+			z.free = append(z.free, int16(c))
+		}
+	}
+}
+
+// expand sets the string of the code to z.str. It reports false if the
+// string is not defined.
+func (z *ShrinkReader) expand(code int) bool {
+	z.str = z.str[:0]
+	for code > 0xff {
+		if len(z.str) > shrinkMaxCode || code == shrinkControl || z.prefix[code] == shrinkUnused {
+			return false
+		}
+		z.str = append(z.str, z.suffix[code])
+		code = int(z.prefix[code])
+	}
+	z.str = append(z.str, byte(code))
+	for i, j := 0, len(z.str)-1; i < j; i, j = i+1, j-1 {
+		z.str[i], z.str[j] = z.str[j], z.str[i]
+	}
+	return true
+}
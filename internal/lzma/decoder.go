@@ -0,0 +1,303 @@
+package lzma
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// window is the dictionary of the decoder.
+// It grows up to the dictionary size, and then wraps around.
+type window struct {
+	buf   []byte
+	pos   int // the next position to write in buf
+	size  int // the dictionary size
+	total int64
+}
+
+func (w *window) reset(size uint32) {
+	w.size = max(int(size), minDictSize)
+	w.buf = w.buf[:0]
+	w.pos = 0
+	w.total = 0
+}
+
+func (w *window) put(b byte) {
+	if w.pos == len(w.buf) {
+		if len(w.buf) < w.size {
+			w.buf = append(w.buf, b)
+			w.pos++
+			w.total++
+			return
+		}
+		w.pos = 0
+	}
+	w.buf[w.pos] = b
+	w.pos++
+	w.total++
+}
+
+// get returns the byte dist bytes back, dist >= 1.
+func (w *window) get(dist int) byte {
+	i := w.pos - dist
+	if i < 0 {
+		i += len(w.buf)
+	}
+	return w.buf[i]
+}
+
+// valid reports whether the dictionary holds dist bytes back.
+func (w *window) valid(dist uint32) bool {
+	return int64(dist) <= w.total && int(dist) <= len(w.buf)
+}
+
+// decoder decodes the symbols of LZMA data.
+type decoder struct {
+	model
+	rd  rangeDecoder
+	win window
+
+	pending int  // the rest of the length of the current match
+	eos     bool // the end marker is read
+}
+
+// decode decodes the data to p, and returns the number of bytes.
+// The decoding stops at the end marker.
+func (d *decoder) decode(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if d.pending > 0 {
+			dist := int(d.reps[0]) + 1
+			for ; d.pending > 0 && n < len(p); d.pending-- {
+				b := d.win.get(dist)
+				d.win.put(b)
+				p[n] = b
+				n++
+			}
+			continue
+		}
+		if d.eos {
+			break
+		}
+		start := n
+		if err := d.decodeSymbol(p, &n); err != nil {
+			return start, err
+		}
+		if d.rd.err != nil {
+			return start, d.rd.err
+		}
+	}
+	return n, nil
+}
+
+func (d *decoder) decodeSymbol(p []byte, n *int) error {
+	rd := &d.rd
+	pos := d.win.total
+	posState := d.posState(pos)
+	state := d.state
+
+	if rd.bit(&d.isMatch[state<<maxPosBits+posState]) == 0 {
+		var prev byte
+		if pos > 0 {
+			prev = d.win.get(1)
+		}
+		probs := d.literalProbs(pos, prev)
+		sym := uint32(1)
+		if state < numLitStates {
+			for sym < 0x100 {
+				sym = sym<<1 | rd.bit(&probs[sym])
+			}
+		} else {
+			if !d.win.valid(d.reps[0] + 1) {
+				return errCorrupt
+			}
+			match := uint32(d.win.get(int(d.reps[0]) + 1))
+			offs := uint32(0x100)
+			for sym < 0x100 {
+				match <<= 1
+				mbit := match & offs
+				b := rd.bit(&probs[offs+mbit+sym])
+				sym = sym<<1 | b
+				if b == 0 {
+					offs &^= mbit
+				} else {
+					offs &= mbit
+				}
+			}
+		}
+		b := byte(sym)
+		d.win.put(b)
+		p[*n] = b
+		*n++
+		d.updateLiteral()
+		return nil
+	}
+
+	var length int
+	if rd.bit(&d.isRep[state]) == 0 {
+		length = d.decodeLen(&d.mainLen, posState)
+		dist := d.decodeDist(length)
+		if dist == eosDist {
+			d.eos = true
+			return nil
+		}
+		d.reps[3], d.reps[2], d.reps[1], d.reps[0] = d.reps[2], d.reps[1], d.reps[0], dist
+		d.updateMatch()
+	} else {
+		if rd.bit(&d.isRepG0[state]) == 0 {
+			if rd.bit(&d.isRep0Long[state<<maxPosBits+posState]) == 0 {
+				// short rep: one byte of rep0
+				if !d.win.valid(d.reps[0] + 1) {
+					return errCorrupt
+				}
+				d.updateShortRep()
+				d.pending = 1
+				return nil
+			}
+		} else {
+			var dist uint32
+			if rd.bit(&d.isRepG1[state]) == 0 {
+				dist = d.reps[1]
+			} else {
+				if rd.bit(&d.isRepG2[state]) == 0 {
+					dist = d.reps[2]
+				} else {
+					dist = d.reps[3]
+					d.reps[3] = d.reps[2]
+				}
+				d.reps[2] = d.reps[1]
+			}
+			d.reps[1] = d.reps[0]
+			d.reps[0] = dist
+		}
+		length = d.decodeLen(&d.repLen, posState)
+		d.updateRep()
+	}
+	if !d.win.valid(d.reps[0] + 1) {
+		return errCorrupt
+	}
+	d.pending = length
+	return nil
+}
+
+func (d *decoder) decodeLen(c *lenCoder, posState int) int {
+	rd := &d.rd
+	if rd.bit(&c.choice) == 0 {
+		return minMatchLen + int(rd.tree(c.low[posState][:], 3))
+	}
+	if rd.bit(&c.choice2) == 0 {
+		return minMatchLen + 8 + int(rd.tree(c.mid[posState][:], 3))
+	}
+	return minMatchLen + 16 + int(rd.tree(c.high[:], 8))
+}
+
+func (d *decoder) decodeDist(length int) uint32 {
+	rd := &d.rd
+	slot := rd.tree(d.posSlot[lenState(length)][:], 6)
+	if slot < 4 {
+		return slot
+	}
+	footer := uint(slot>>1 - 1)
+	dist := (2 | slot&1) << footer
+	if slot < endPosModel {
+		return dist + rd.reverseTree(d.posSpecial[:], int(dist-slot)-1, footer)
+	}
+	dist += rd.direct(footer-numAlignBits) << numAlignBits
+	return dist + rd.reverseTree(d.align[:], -1, numAlignBits)
+}
+
+// headerLen is the length of the LZMA header of zip entries:
+// the version of the LZMA SDK, the size of the properties and the properties.
+const headerLen = 4 + 5
+
+// A Reader decompresses the LZMA data of a zip entry.
+// The data ends with the end marker or at the end of the input;
+// the reader of data without the end marker should be limited
+// to the uncompressed size.
+type Reader struct {
+	d   decoder
+	r   *bufio.Reader
+	err error
+}
+
+// NewReader returns a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	z := new(Reader)
+	z.Reset(r)
+	return z
+}
+
+// Reset discards the state of the Reader and makes it read from r.
+func (z *Reader) Reset(r io.Reader) {
+	if z.r == nil {
+		z.r = bufio.NewReader(r)
+	} else {
+		z.r.Reset(r)
+	}
+	z.err = nil
+	z.d.eos = false
+	z.d.pending = 0
+	z.d.win.reset(0)
+	if r == nil {
+		z.err = io.ErrClosedPipe
+		return
+	}
+
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(z.r, hdr[:]); err != nil {
+		z.err = noEOF(err)
+		return
+	}
+	if binary.LittleEndian.Uint16(hdr[2:]) != 5 {
+		z.err = errFormat
+		return
+	}
+	var props Props
+	if err := props.decodeProps(hdr[4]); err != nil {
+		z.err = err
+		return
+	}
+	props.DictSize = binary.LittleEndian.Uint32(hdr[5:])
+	z.d.reset(props)
+	z.d.win.reset(props.DictSize)
+	z.err = z.d.rd.init(z.r)
+}
+
+// Read reads the decompressed data.
+func (z *Reader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n, err := z.d.decode(p)
+	if err != nil {
+		z.err = err
+		if n > 0 {
+			// the error is returned by the next call
+			return n, nil
+		}
+		return 0, err
+	}
+	if n < len(p) && z.d.eos && z.d.pending == 0 {
+		z.err = io.EOF
+		if !z.d.rd.finished() {
+			z.err = errCorrupt
+		}
+		if n == 0 {
+			return 0, z.err
+		}
+	}
+	return n, nil
+}
+
+// Close closes the Reader. It does not close the underlying reader.
+func (z *Reader) Close() error {
+	z.err = io.ErrClosedPipe
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,467 @@
+package lzma
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// params are the parameters of a compression level.
+type params struct {
+	dictLog uint // log2 of the dictionary size
+	hashLog uint // log2 of the hash table size
+	depth   int  // the number of the chain entries to search
+	nice    int  // a match of this length stops the search
+	lazy    bool // look for a longer match at the next position
+}
+
+var levels = [...]params{
+	1: {dictLog: 20, hashLog: 16, depth: 4, nice: 32},
+	2: {dictLog: 21, hashLog: 17, depth: 8, nice: 48},
+	3: {dictLog: 22, hashLog: 18, depth: 16, nice: 64, lazy: true},
+	4: {dictLog: 22, hashLog: 18, depth: 24, nice: 64, lazy: true},
+	5: {dictLog: 23, hashLog: 19, depth: 32, nice: 96, lazy: true},
+	6: {dictLog: 23, hashLog: 20, depth: 48, nice: 128, lazy: true},
+	7: {dictLog: 24, hashLog: 20, depth: 96, nice: 160, lazy: true},
+	8: {dictLog: 25, hashLog: 20, depth: 192, nice: 192, lazy: true},
+	9: {dictLog: 26, hashLog: 20, depth: 512, nice: maxMatchLen, lazy: true},
+}
+
+const (
+	hashLen      = 4 // the length of the hashed bytes, and the minimum match
+	hashMultiply = 0x9e3779b1
+)
+
+// encoder encodes data to the symbols of LZMA data.
+type encoder struct {
+	model
+	rc  rangeEncoder
+	p   params
+	pos int64 // the position of hist[start] in the data
+
+	hist   []byte  // the dictionary and the data to encode
+	start  int     // the next position to encode
+	insert int     // the next position to add to the hash chains
+	head   []int32 // the last position+1 of the hashes
+	chain  []int32 // the previous position+1 of the same hash
+}
+
+func (e *encoder) reset(p params) {
+	e.p = p
+	e.model.reset(defaultProps)
+	e.rc.reset()
+	e.pos = 0
+	e.hist = e.hist[:0]
+	e.start = 0
+	e.insert = 0
+	e.chain = e.chain[:0]
+	if len(e.head) != 1<<p.hashLog {
+		e.head = make([]int32, 1<<p.hashLog)
+	} else {
+		for i := range e.head {
+			e.head[i] = 0
+		}
+	}
+}
+
+func (e *encoder) dictSize() int {
+	return 1 << e.p.dictLog
+}
+
+// slide drops the data older than the dictionary.
+func (e *encoder) slide() {
+	dict := e.dictSize()
+	drop := e.start - dict
+	if drop < dict {
+		return
+	}
+	n := copy(e.hist, e.hist[drop:])
+	e.hist = e.hist[:n]
+	e.start -= drop
+	copy(e.chain, e.chain[drop:])
+	e.chain = e.chain[:len(e.chain)-drop]
+	e.insert -= drop
+	d := int32(drop)
+	for i, v := range e.chain {
+		if v > d {
+			e.chain[i] = v - d
+		} else {
+			e.chain[i] = 0
+		}
+	}
+	for i, v := range e.head {
+		if v > d {
+			e.head[i] = v - d
+		} else {
+			e.head[i] = 0
+		}
+	}
+}
+
+func (e *encoder) hashAt(i int) uint32 {
+	return binary.LittleEndian.Uint32(e.hist[i:]) * hashMultiply >> (32 - e.p.hashLog)
+}
+
+// insertTo adds the positions before end to the hash chains.
+func (e *encoder) insertTo(end int) {
+	if limit := len(e.hist) - hashLen + 1; end > limit {
+		end = limit
+	}
+	for len(e.chain) < end {
+		e.chain = append(e.chain, 0)
+	}
+	for i := e.insert; i < end; i++ {
+		h := e.hashAt(i)
+		e.chain[i] = e.head[h]
+		e.head[h] = int32(i + 1)
+	}
+	if end > e.insert {
+		e.insert = end
+	}
+}
+
+// matchLen returns the length of the common prefix of hist[a:] and hist[b:end].
+func (e *encoder) matchLen(a, b, end int) int {
+	n := 0
+	for b+n+8 <= end {
+		x := binary.LittleEndian.Uint64(e.hist[a+n:]) ^ binary.LittleEndian.Uint64(e.hist[b+n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for b+n < end && e.hist[a+n] == e.hist[b+n] {
+		n++
+	}
+	return n
+}
+
+// findRep returns the longest match at i with a recent distance,
+// and the index of the distance.
+func (e *encoder) findRep(i, end int) (int, int) {
+	best, bestIdx := 0, 0
+	if i+minMatchLen > end {
+		return 0, 0
+	}
+	for idx, r := range e.reps {
+		src := i - int(r) - 1
+		if src < 0 || int64(r) >= e.pos || int(r) >= e.dictSize() {
+			continue
+		}
+		if n := e.matchLen(src, i, end); n > best {
+			best, bestIdx = n, idx
+		}
+	}
+	if best < minMatchLen {
+		return 0, 0
+	}
+	return best, bestIdx
+}
+
+// findMatch returns the longest match at i, ending before end,
+// and its distance.
+func (e *encoder) findMatch(i, end int) (int, int) {
+	e.insertTo(i)
+	best, bestDist := 0, 0
+	if i+hashLen > end {
+		return 0, 0
+	}
+	limit := i - e.dictSize()
+	cand := int(e.head[e.hashAt(i)]) - 1
+	for cand >= i {
+		// added by the lazy search
+		cand = int(e.chain[cand]) - 1
+	}
+	for tries := e.p.depth; cand > limit && cand >= 0 && tries > 0; tries-- {
+		if best == 0 || i+best < end && e.hist[cand+best] == e.hist[i+best] {
+			if n := e.matchLen(cand, i, end); n > best {
+				best, bestDist = n, i-cand
+				if n >= e.p.nice {
+					break
+				}
+			}
+		}
+		cand = int(e.chain[cand]) - 1
+	}
+	e.insertTo(i + 1)
+	if best < hashLen {
+		return 0, 0
+	}
+	return best, bestDist
+}
+
+// encode encodes the data from start, until start reaches stop or
+// the encoded data reaches limit bytes. The matches end before end.
+func (e *encoder) encode(stop, end, limit int) {
+	end = min(end, len(e.hist))
+	for e.start < stop && e.rc.pending() < limit {
+		i := e.start
+		matchEnd := min(end, i+maxMatchLen)
+		n, dist := e.findMatch(i, matchEnd)
+		rn, ri := e.findRep(i, matchEnd)
+		if rn >= e.p.nice || rn > 0 && (rn+1 >= n ||
+			rn+2 >= n && dist >= 1<<9 || rn+3 >= n && dist >= 1<<15) {
+			e.encodeRep(ri, rn)
+			e.start += rn
+			continue
+		}
+		if n > 0 && e.p.lazy && n < e.p.nice {
+			n2, dist2 := e.findMatch(i+1, min(end, i+1+maxMatchLen))
+			if n2 > n+1 || n2 == n+1 && dist2>>7 <= dist || n2 >= n && dist2 < dist {
+				n = 0
+			}
+		}
+		if n > 0 {
+			e.encodeMatch(uint32(dist-1), n)
+			e.start += n
+			continue
+		}
+		if r := int(e.reps[0]); i > r && int64(r) < e.pos && e.hist[i-r-1] == e.hist[i] {
+			e.encodeRep(0, 1)
+		} else {
+			e.encodeLiteral(i)
+		}
+		e.start++
+	}
+}
+
+func (e *encoder) encodeLiteral(i int) {
+	rc := &e.rc
+	rc.bit(&e.isMatch[e.state<<maxPosBits+e.posState(e.pos)], 0)
+	var prev byte
+	if e.pos > 0 {
+		prev = e.hist[i-1]
+	}
+	probs := e.literalProbs(e.pos, prev)
+	b := uint32(e.hist[i])
+	if e.state < numLitStates {
+		rc.tree(probs, 8, b)
+	} else {
+		match := uint32(e.hist[i-int(e.reps[0])-1])
+		sym, offs := uint32(1), uint32(0x100)
+		for k := 7; k >= 0; k-- {
+			match <<= 1
+			mbit := match & offs
+			bit := b >> uint(k) & 1
+			rc.bit(&probs[offs+mbit+sym], bit)
+			sym = sym<<1 | bit
+			if bit == 0 {
+				offs &^= mbit
+			} else {
+				offs &= mbit
+			}
+		}
+	}
+	e.updateLiteral()
+	e.pos++
+}
+
+// encodeMatch encodes a match of the distance dist+1.
+func (e *encoder) encodeMatch(dist uint32, n int) {
+	rc := &e.rc
+	posState := e.posState(e.pos)
+	rc.bit(&e.isMatch[e.state<<maxPosBits+posState], 1)
+	rc.bit(&e.isRep[e.state], 0)
+	e.encodeLen(&e.mainLen, n, posState)
+	e.encodeDist(dist, n)
+	e.reps[3], e.reps[2], e.reps[1], e.reps[0] = e.reps[2], e.reps[1], e.reps[0], dist
+	e.updateMatch()
+	e.pos += int64(n)
+}
+
+// encodeRep encodes a match of the recent distance reps[idx].
+// The length 1 is a short rep of reps[0].
+func (e *encoder) encodeRep(idx, n int) {
+	rc := &e.rc
+	state := e.state
+	posState := e.posState(e.pos)
+	rc.bit(&e.isMatch[state<<maxPosBits+posState], 1)
+	rc.bit(&e.isRep[state], 1)
+	if idx == 0 {
+		rc.bit(&e.isRepG0[state], 0)
+		if n == 1 {
+			rc.bit(&e.isRep0Long[state<<maxPosBits+posState], 0)
+			e.updateShortRep()
+			e.pos++
+			return
+		}
+		rc.bit(&e.isRep0Long[state<<maxPosBits+posState], 1)
+	} else {
+		rc.bit(&e.isRepG0[state], 1)
+		dist := e.reps[idx]
+		if idx == 1 {
+			rc.bit(&e.isRepG1[state], 0)
+		} else {
+			rc.bit(&e.isRepG1[state], 1)
+			rc.bit(&e.isRepG2[state], uint32(idx-2))
+			if idx == 3 {
+				e.reps[3] = e.reps[2]
+			}
+			e.reps[2] = e.reps[1]
+		}
+		e.reps[1] = e.reps[0]
+		e.reps[0] = dist
+	}
+	e.encodeLen(&e.repLen, n, posState)
+	e.updateRep()
+	e.pos += int64(n)
+}
+
+// encodeEnd encodes the end marker.
+func (e *encoder) encodeEnd() {
+	rc := &e.rc
+	posState := e.posState(e.pos)
+	rc.bit(&e.isMatch[e.state<<maxPosBits+posState], 1)
+	rc.bit(&e.isRep[e.state], 0)
+	e.encodeLen(&e.mainLen, minMatchLen, posState)
+	e.encodeDist(eosDist, minMatchLen)
+}
+
+func (e *encoder) encodeLen(c *lenCoder, n, posState int) {
+	rc := &e.rc
+	n -= minMatchLen
+	switch {
+	case n < 8:
+		rc.bit(&c.choice, 0)
+		rc.tree(c.low[posState][:], 3, uint32(n))
+	case n < 16:
+		rc.bit(&c.choice, 1)
+		rc.bit(&c.choice2, 0)
+		rc.tree(c.mid[posState][:], 3, uint32(n-8))
+	default:
+		rc.bit(&c.choice, 1)
+		rc.bit(&c.choice2, 1)
+		rc.tree(c.high[:], 8, uint32(n-16))
+	}
+}
+
+func (e *encoder) encodeDist(dist uint32, n int) {
+	rc := &e.rc
+	slot := dist
+	if dist >= 4 {
+		k := uint32(bits.Len32(dist) - 1)
+		slot = k<<1 | dist>>(k-1)&1
+	}
+	rc.tree(e.posSlot[lenState(n)][:], 6, slot)
+	if slot < 4 {
+		return
+	}
+	footer := uint(slot>>1 - 1)
+	base := (2 | slot&1) << footer
+	reduced := dist - base
+	if slot < endPosModel {
+		rc.reverseTree(e.posSpecial[:], int(base-slot)-1, footer, reduced)
+		return
+	}
+	rc.direct(reduced>>numAlignBits, footer-numAlignBits)
+	rc.reverseTree(e.align[:], -1, numAlignBits, reduced)
+}
+
+// dictSizeFor returns the dictionary size written in the properties
+// for the data of n bytes: the dictionary need not be larger than the data.
+func (e *encoder) dictSizeFor(n int64, final bool) uint32 {
+	size := uint32(e.dictSize())
+	if final {
+		for size > minDictSize && int64(size/2) >= n {
+			size /= 2
+		}
+	}
+	return size
+}
+
+// A Writer compresses the written data into LZMA data of a zip entry,
+// which ends with the end marker.
+type Writer struct {
+	e      encoder
+	w      io.Writer
+	level  int
+	total  int64 // the size of the written data
+	header bool  // the header is written
+	err    error
+}
+
+// NewWriter returns a new Writer compressing with the default level.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a new Writer compressing with the level
+// from BestSpeed to BestCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, errors.New("lzma: invalid compression level")
+	}
+	z := &Writer{level: level}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of the Writer and makes it write to w.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.e.reset(levels[z.level])
+	z.total = 0
+	z.header = false
+	z.err = nil
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	e := &z.e
+	e.slide()
+	e.hist = append(e.hist, p...)
+	z.total += int64(len(p))
+	if len(e.hist)-e.start >= 1<<16 {
+		e.encode(len(e.hist)-maxMatchLen, len(e.hist), int(^uint(0)>>1))
+		if len(e.rc.out) >= 1<<16 {
+			if err := z.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes the rest of the data and the end marker.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	e := &z.e
+	e.encode(len(e.hist), len(e.hist), int(^uint(0)>>1))
+	e.encodeEnd()
+	e.rc.flush()
+	if err := z.flush(true); err != nil {
+		return err
+	}
+	z.err = errors.New("lzma: write after close")
+	return nil
+}
+
+// flush writes the encoded data, following the header.
+func (z *Writer) flush(final bool) error {
+	if !z.header {
+		var hdr [headerLen]byte
+		hdr[0], hdr[1] = 9, 20 // LZMA SDK 9.20, as 7-Zip writes
+		binary.LittleEndian.PutUint16(hdr[2:], 5)
+		hdr[4] = defaultProps.propsByte()
+		binary.LittleEndian.PutUint32(hdr[5:], z.e.dictSizeFor(z.total, final))
+		if _, err := z.w.Write(hdr[:]); err != nil {
+			z.err = err
+			return err
+		}
+		z.header = true
+	}
+	if _, err := z.w.Write(z.e.rc.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.e.rc.out = z.e.rc.out[:0]
+	return nil
+}
@@ -0,0 +1,185 @@
+// Package lzma implements reading and writing of the LZMA compressed data
+// in the framing of zip archives (method 14), and of the XZ format
+// holding LZMA2 data (method 95).
+package lzma
+
+import "errors"
+
+const (
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = 6
+)
+
+var (
+	errCorrupt  = errors.New("lzma: corrupt input")
+	errChecksum = errors.New("lzma: invalid checksum")
+	errFormat   = errors.New("lzma: unsupported format")
+)
+
+const (
+	numStates     = 12
+	numLitStates  = 7 // the states after a literal are below this
+	maxPosBits    = 4
+	numLenStates  = 4
+	numAlignBits  = 4
+	endPosModel   = 14
+	numFullDist   = 1 << (endPosModel >> 1)
+	minMatchLen   = 2
+	maxMatchLen   = minMatchLen + 8 + 8 + 256 - 1
+	probBits      = 11
+	probInit      = 1 << probBits / 2
+	probMoveBits  = 5
+	rangeTopValue = 1 << 24
+	eosDist       = 0xffffffff
+	minDictSize   = 1 << 12
+)
+
+// Props holds the properties of LZMA data.
+type Props struct {
+	LC, LP, PB int    // literal context, literal position and position bits
+	DictSize   uint32 // dictionary size
+}
+
+// defaultProps are the properties used by the writers.
+var defaultProps = Props{LC: 3, LP: 0, PB: 2}
+
+// decodeProps sets the literal and position bits from the properties byte.
+func (p *Props) decodeProps(b byte) error {
+	if b >= 9*5*5 {
+		return errCorrupt
+	}
+	p.LC = int(b % 9)
+	b /= 9
+	p.LP = int(b % 5)
+	p.PB = int(b / 5)
+	return nil
+}
+
+func (p *Props) propsByte() byte {
+	return byte((p.PB*5+p.LP)*9 + p.LC)
+}
+
+type prob uint16
+
+func initProbs(p []prob) {
+	for i := range p {
+		p[i] = probInit
+	}
+}
+
+// lenCoder holds the probabilities of the match lengths.
+type lenCoder struct {
+	choice  prob
+	choice2 prob
+	low     [1 << maxPosBits][1 << 3]prob
+	mid     [1 << maxPosBits][1 << 3]prob
+	high    [1 << 8]prob
+}
+
+func (c *lenCoder) reset() {
+	c.choice = probInit
+	c.choice2 = probInit
+	for i := range c.low {
+		initProbs(c.low[i][:])
+		initProbs(c.mid[i][:])
+	}
+	initProbs(c.high[:])
+}
+
+// model holds the state shared by the decoder and the encoder.
+type model struct {
+	props Props
+	state int
+	reps  [4]uint32 // the recent distances minus one
+
+	lit        []prob
+	isMatch    [numStates << maxPosBits]prob
+	isRep      [numStates]prob
+	isRepG0    [numStates]prob
+	isRepG1    [numStates]prob
+	isRepG2    [numStates]prob
+	isRep0Long [numStates << maxPosBits]prob
+	posSlot    [numLenStates][1 << 6]prob
+	posSpecial [numFullDist - endPosModel]prob
+	align      [1 << numAlignBits]prob
+	mainLen    lenCoder
+	repLen     lenCoder
+}
+
+// reset initializes the state and the probabilities for the properties.
+func (m *model) reset(props Props) {
+	m.props = props
+	m.state = 0
+	m.reps = [4]uint32{}
+	n := 0x300 << uint(props.LC+props.LP)
+	if cap(m.lit) < n {
+		m.lit = make([]prob, n)
+	}
+	m.lit = m.lit[:n]
+	initProbs(m.lit)
+	initProbs(m.isMatch[:])
+	initProbs(m.isRep[:])
+	initProbs(m.isRepG0[:])
+	initProbs(m.isRepG1[:])
+	initProbs(m.isRepG2[:])
+	initProbs(m.isRep0Long[:])
+	for i := range m.posSlot {
+		initProbs(m.posSlot[i][:])
+	}
+	initProbs(m.posSpecial[:])
+	initProbs(m.align[:])
+	m.mainLen.reset()
+	m.repLen.reset()
+}
+
+// literalProbs returns the probabilities of the literal at pos after prev.
+func (m *model) literalProbs(pos int64, prev byte) []prob {
+	lc, lp := uint(m.props.LC), uint(m.props.LP)
+	i := (int(pos)&(1<<lp-1))<<lc + int(prev)>>(8-lc)
+	return m.lit[0x300*i : 0x300*(i+1)]
+}
+
+func (m *model) posState(pos int64) int {
+	return int(pos) & (1<<uint(m.props.PB) - 1)
+}
+
+func (m *model) updateLiteral() {
+	switch {
+	case m.state < 4:
+		m.state = 0
+	case m.state < 10:
+		m.state -= 3
+	default:
+		m.state -= 6
+	}
+}
+
+func (m *model) updateMatch() {
+	if m.state < numLitStates {
+		m.state = 7
+	} else {
+		m.state = 10
+	}
+}
+
+func (m *model) updateRep() {
+	if m.state < numLitStates {
+		m.state = 8
+	} else {
+		m.state = 11
+	}
+}
+
+func (m *model) updateShortRep() {
+	if m.state < numLitStates {
+		m.state = 9
+	} else {
+		m.state = 11
+	}
+}
+
+// lenState returns the state of the distance for the match length.
+func lenState(n int) int {
+	return min(n-minMatchLen, numLenStates-1)
+}
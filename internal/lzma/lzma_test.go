@@ -0,0 +1,209 @@
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestXZReader(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"testdata/e.txt.xz", want},                    // xz -9e --check=sha256
+		{"testdata/e-multi.xz", bytes.Repeat(want, 2)}, // blocks, padding and two streams
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewXZReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Fatalf("%s: decoded data is different", test.name)
+		}
+	}
+}
+
+func TestXZReaderError(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/e.txt.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated
+	b := data[:len(data)/2]
+	if _, err := ioutil.ReadAll(NewXZReader(bytes.NewReader(b))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err=%v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// corrupted
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := append([]byte(nil), data...)
+		b[rng.Intn(len(b))] ^= byte(1 << uint(rng.Intn(8)))
+		if _, err := ioutil.ReadAll(NewXZReader(bytes.NewReader(b))); err == nil {
+			t.Fatalf("need raise error")
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// more than an LZMA2 chunk
+	large := bytes.Repeat(append(text, random[:len(text)/4]...), 80)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("hello")},
+		{"zeros", make([]byte, 300000)},
+		{"text", text},
+		{"random", random},
+		{"large", large},
+	}
+	for _, test := range tests {
+		for _, level := range []int{BestSpeed, DefaultCompression, BestCompression} {
+			if testing.Short() && test.name == "large" && level != BestSpeed {
+				continue
+			}
+			buf := new(bytes.Buffer)
+			w, err := NewWriterLevel(buf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			xbuf := new(bytes.Buffer)
+			xw, err := NewXZWriterLevel(xbuf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p := test.data; len(p) > 0; {
+				n := min(len(p), 10000)
+				if _, err := w.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := xw.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				p = p[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := xw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, r := range []struct {
+				kind string
+				buf  *bytes.Buffer
+				r    func(io.Reader) io.Reader
+			}{
+				{"lzma", buf, func(r io.Reader) io.Reader { return NewReader(r) }},
+				{"xz", xbuf, func(r io.Reader) io.Reader { return NewXZReader(r) }},
+			} {
+				if len(test.data) > 1000 && test.name != "random" && r.buf.Len() >= len(test.data)/2 {
+					t.Errorf("%s %s, level %d: compressed size %d of %d", r.kind, test.name, level, r.buf.Len(), len(test.data))
+				}
+				got, err := ioutil.ReadAll(r.r(r.buf))
+				if err != nil {
+					t.Fatalf("%s %s, level %d: %v", r.kind, test.name, level, err)
+				}
+				if !bytes.Equal(got, test.data) {
+					t.Fatalf("%s %s, level %d: decoded data is different", r.kind, test.name, level)
+				}
+			}
+		}
+	}
+
+	if _, err := NewWriterLevel(ioutil.Discard, BestCompression+1); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if _, err := NewXZWriterLevel(ioutil.Discard, 0); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestReaderNoEndMarker(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	e := &w.e
+	e.encode(len(e.hist), len(e.hist), len(data))
+	e.rc.flush()
+	if err := w.flush(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data ends at the size
+	r := io.LimitReader(NewReader(buf), int64(len(data)))
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("decoded data is different")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w := NewWriter(nil)
+	xw := NewXZWriter(nil)
+	for _, s := range []string{"first data, first data", "second data, second data"} {
+		buf := new(bytes.Buffer)
+		xbuf := new(bytes.Buffer)
+		w.Reset(buf)
+		xw.Reset(xbuf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(xw, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := xw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+		got, err = ioutil.ReadAll(NewXZReader(xbuf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
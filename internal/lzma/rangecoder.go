@@ -0,0 +1,191 @@
+package lzma
+
+import "io"
+
+type rangeDecoder struct {
+	r    io.ByteReader
+	rng  uint32
+	code uint32
+	err  error // the first read error
+}
+
+// init reads the first bytes of the range coded data.
+func (d *rangeDecoder) init(r io.ByteReader) error {
+	d.r = r
+	d.rng = 0xffffffff
+	d.code = 0
+	d.err = nil
+	if d.readByte() != 0 {
+		return errCorrupt
+	}
+	for i := 0; i < 4; i++ {
+		d.code = d.code<<8 | uint32(d.readByte())
+	}
+	return d.err
+}
+
+func (d *rangeDecoder) readByte() byte {
+	b, err := d.r.ReadByte()
+	if err != nil && d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+	return b
+}
+
+// finished reports whether the range coded data ends properly,
+// after the last byte is read.
+func (d *rangeDecoder) finished() bool {
+	return d.code == 0
+}
+
+func (d *rangeDecoder) normalize() {
+	if d.rng < rangeTopValue {
+		d.rng <<= 8
+		d.code = d.code<<8 | uint32(d.readByte())
+	}
+}
+
+func (d *rangeDecoder) bit(p *prob) uint32 {
+	bound := (d.rng >> probBits) * uint32(*p)
+	var b uint32
+	if d.code < bound {
+		d.rng = bound
+		*p += (1<<probBits - *p) >> probMoveBits
+	} else {
+		d.code -= bound
+		d.rng -= bound
+		*p -= *p >> probMoveBits
+		b = 1
+	}
+	d.normalize()
+	return b
+}
+
+func (d *rangeDecoder) direct(n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - d.code>>31 // all ones if code was below rng
+		d.code += d.rng & t
+		v = v<<1 | (t + 1)
+		d.normalize()
+	}
+	return v
+}
+
+// tree decodes n bits from the most significant.
+func (d *rangeDecoder) tree(probs []prob, n uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		m = m<<1 | d.bit(&probs[m])
+	}
+	return m - 1<<n
+}
+
+// reverseTree decodes n bits from the least significant,
+// using the probabilities from probs[offset+1].
+func (d *rangeDecoder) reverseTree(probs []prob, offset int, n uint) uint32 {
+	m := uint32(1)
+	var v uint32
+	for i := uint(0); i < n; i++ {
+		b := d.bit(&probs[offset+int(m)])
+		m = m<<1 | b
+		v |= b << i
+	}
+	return v
+}
+
+type rangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int64
+	out       []byte
+}
+
+func (e *rangeEncoder) reset() {
+	e.low = 0
+	e.rng = 0xffffffff
+	e.cache = 0
+	e.cacheSize = 1
+	e.out = e.out[:0]
+}
+
+// pending returns the number of the bytes written by flush at most.
+func (e *rangeEncoder) pending() int {
+	return len(e.out) + int(e.cacheSize) + 4
+}
+
+func (e *rangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xff000000 || e.low >= 1<<32 {
+		carry := byte(e.low >> 32)
+		b := e.cache
+		for ; e.cacheSize > 0; e.cacheSize-- {
+			e.out = append(e.out, b+carry)
+			b = 0xff
+		}
+		e.cache = byte(e.low >> 24)
+	}
+	e.cacheSize++
+	e.low = uint64(uint32(e.low) << 8)
+}
+
+func (e *rangeEncoder) flush() {
+	for i := 0; i < 5; i++ {
+		e.shiftLow()
+	}
+}
+
+func (e *rangeEncoder) bit(p *prob, b uint32) {
+	bound := (e.rng >> probBits) * uint32(*p)
+	if b == 0 {
+		e.rng = bound
+		*p += (1<<probBits - *p) >> probMoveBits
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+		*p -= *p >> probMoveBits
+	}
+	for e.rng < rangeTopValue {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+}
+
+func (e *rangeEncoder) direct(v uint32, n uint) {
+	for n > 0 {
+		n--
+		e.rng >>= 1
+		if v>>n&1 != 0 {
+			e.low += uint64(e.rng)
+		}
+		for e.rng < rangeTopValue {
+			e.rng <<= 8
+			e.shiftLow()
+		}
+	}
+}
+
+func (e *rangeEncoder) tree(probs []prob, n uint, v uint32) {
+	m := uint32(1)
+	for n > 0 {
+		n--
+		b := v >> n & 1
+		e.bit(&probs[m], b)
+		m = m<<1 | b
+	}
+}
+
+func (e *rangeEncoder) reverseTree(probs []prob, offset int, n uint, v uint32) {
+	m := uint32(1)
+	for ; n > 0; n-- {
+		b := v & 1
+		e.bit(&probs[offset+int(m)], b)
+		m = m<<1 | b
+		v >>= 1
+	}
+}
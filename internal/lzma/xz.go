@@ -0,0 +1,734 @@
+package lzma
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+var (
+	xzHeaderMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0}
+	xzFooterMagic = []byte{'Y', 'Z'}
+)
+
+const (
+	xzHeaderLen = 12
+	xzFooterLen = 12
+	filterLZMA2 = 0x21
+
+	checkNone   = 0x00
+	checkCRC32  = 0x01
+	checkCRC64  = 0x04
+	checkSHA256 = 0x0a
+
+	chunkMaxUncompressed = 1 << 21
+	chunkMaxCompressed   = 1 << 16
+	chunkMaxSymbol       = 64 // the encoded size of a symbol is less than this
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// checkSize returns the size of the check of the type.
+func checkSize(typ byte) int {
+	if typ == 0 {
+		return 0
+	}
+	return 4 << ((typ - 1) / 3)
+}
+
+func newCheck(typ byte) hash.Hash {
+	switch typ {
+	case checkCRC32:
+		return crc32.NewIEEE()
+	case checkCRC64:
+		return crc64.New(crc64Table)
+	case checkSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// checkSum returns the check as it is stored: the CRCs are little-endian.
+func checkSum(h hash.Hash) []byte {
+	switch h := h.(type) {
+	case hash.Hash32:
+		return binary.LittleEndian.AppendUint32(nil, h.Sum32())
+	case hash.Hash64:
+		return binary.LittleEndian.AppendUint64(nil, h.Sum64())
+	}
+	return h.Sum(nil)
+}
+
+// dictSizeProp returns the LZMA2 property of the dictionary size,
+// which is at least size.
+func dictSizeProp(size uint32) byte {
+	for b := byte(0); b < 40; b++ {
+		if dictSizeOf(b) >= size {
+			return b
+		}
+	}
+	return 40
+}
+
+func dictSizeOf(b byte) uint32 {
+	if b >= 40 {
+		return 0xffffffff
+	}
+	return (2 | uint32(b)&1) << (b/2 + 11)
+}
+
+// countReader counts the bytes read.
+type countReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// readFull reads len(p) bytes, and also feeds them to h if h is non-nil.
+func (r *countReader) readFull(p []byte, h hash.Hash) error {
+	if _, err := io.ReadFull(r, p); err != nil {
+		return noEOF(err)
+	}
+	if h != nil {
+		h.Write(p)
+	}
+	return nil
+}
+
+// readUvarint reads a multibyte integer, also feeding h.
+func (r *countReader) readUvarint(h hash.Hash) (uint64, error) {
+	var v uint64
+	for i := uint(0); i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		if h != nil {
+			h.Write([]byte{b})
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, errCorrupt
+			}
+			return v, nil
+		}
+	}
+	return 0, errCorrupt
+}
+
+// skipPadding reads the zero bytes up to the multiple of 4 bytes from
+// the position start.
+func (r *countReader) skipPadding(start int64, h hash.Hash) error {
+	for (r.n-start)%4 != 0 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return noEOF(err)
+		}
+		if b != 0 {
+			return errCorrupt
+		}
+		if h != nil {
+			h.Write([]byte{0})
+		}
+	}
+	return nil
+}
+
+type indexRecord struct {
+	unpadded, uncompressed uint64
+}
+
+// An XZReader decompresses a stream of the XZ format.
+// Only the LZMA2 filter is supported.
+type XZReader struct {
+	r       countReader
+	err     error
+	check   byte
+	records []indexRecord
+
+	// the current block
+	inBlock     bool
+	blockStart  int64 // the input position of the block header
+	headerSize  int64
+	hash        hash.Hash
+	compSize    uint64
+	uncompSize  uint64
+	d           decoder
+	props       Props
+	chunk       []byte
+	chunkReader bytes.Reader
+	chunkLeft   int  // the rest of the uncompressed size of the chunk
+	chunkLZMA   bool // the chunk is LZMA data
+	needDict    bool // the next chunk must reset the dictionary
+	needProps   bool // the next LZMA chunk must set the properties
+}
+
+// NewXZReader returns a new XZReader reading from r.
+func NewXZReader(r io.Reader) *XZReader {
+	z := new(XZReader)
+	z.Reset(r)
+	return z
+}
+
+// Reset discards the state of the XZReader and makes it read from r.
+func (z *XZReader) Reset(r io.Reader) {
+	if z.r.r == nil {
+		z.r.r = bufio.NewReader(r)
+	} else {
+		z.r.r.Reset(r)
+	}
+	z.r.n = 0
+	z.err = nil
+	z.inBlock = false
+	if r == nil {
+		z.err = io.ErrClosedPipe
+		return
+	}
+	z.err = z.readStreamHeader()
+}
+
+func (z *XZReader) readStreamHeader() error {
+	var hdr [xzHeaderLen]byte
+	if err := z.r.readFull(hdr[:], nil); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[:6], xzHeaderMagic) {
+		return errFormat
+	}
+	if crc32.ChecksumIEEE(hdr[6:8]) != binary.LittleEndian.Uint32(hdr[8:]) {
+		return errCorrupt
+	}
+	if hdr[6] != 0 || hdr[7] > 0x0f {
+		return errFormat
+	}
+	z.check = hdr[7]
+	z.records = z.records[:0]
+	return nil
+}
+
+// Read reads the decompressed data.
+func (z *XZReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && z.err == nil {
+		if !z.inBlock {
+			if n > 0 {
+				break
+			}
+			z.err = z.nextBlock()
+			continue
+		}
+		if z.chunkLeft == 0 {
+			z.err = z.nextChunk()
+			continue
+		}
+		m := min(len(p)-n, z.chunkLeft)
+		var err error
+		if z.chunkLZMA {
+			m, err = z.d.decode(p[n : n+m])
+			if err == nil && z.d.eos {
+				err = errCorrupt
+			}
+		} else {
+			err = z.r.readFull(p[n:n+m], nil)
+			for _, b := range p[n : n+m] {
+				z.d.win.put(b)
+			}
+		}
+		z.hash.Write(p[n : n+m])
+		z.uncompSize += uint64(m)
+		z.chunkLeft -= m
+		n += m
+		if err != nil {
+			z.err = err
+			break
+		}
+		if z.chunkLeft == 0 && z.chunkLZMA && (z.chunkReader.Len() != 0 || !z.d.rd.finished()) {
+			z.err = errCorrupt
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+// nextBlock reads the header of the next block, or the index and
+// the footer at the end of the stream.
+func (z *XZReader) nextBlock() error {
+	start := z.r.n
+	b, err := z.r.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+	if b == 0 {
+		if err := z.readIndex(start); err != nil {
+			return err
+		}
+		return z.nextStream()
+	}
+
+	// block header
+	size := (int(b) + 1) * 4
+	hdr := make([]byte, size)
+	hdr[0] = b
+	if err := z.r.readFull(hdr[1:], nil); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(hdr[:size-4]) != binary.LittleEndian.Uint32(hdr[size-4:]) {
+		return errCorrupt
+	}
+	br := bytes.NewReader(hdr[2 : size-4])
+	flags := hdr[1]
+	if flags&0x3c != 0 {
+		return errFormat
+	}
+	if flags&0x03 != 0 {
+		return errFormat // filters other than LZMA2
+	}
+	if flags&0x40 != 0 {
+		if _, err := binary.ReadUvarint(br); err != nil {
+			return errCorrupt
+		}
+	}
+	if flags&0x80 != 0 {
+		if _, err := binary.ReadUvarint(br); err != nil {
+			return errCorrupt
+		}
+	}
+	id, err := binary.ReadUvarint(br)
+	if err != nil {
+		return errCorrupt
+	}
+	if id != filterLZMA2 {
+		return errFormat
+	}
+	if n, err := binary.ReadUvarint(br); err != nil || n != 1 {
+		return errCorrupt
+	}
+	dict, err := br.ReadByte()
+	if err != nil || dict > 40 {
+		return errCorrupt
+	}
+	for br.Len() > 0 {
+		if b, _ := br.ReadByte(); b != 0 {
+			return errCorrupt
+		}
+	}
+
+	z.inBlock = true
+	z.blockStart = start
+	z.headerSize = int64(size)
+	if z.hash = newCheck(z.check); z.hash == nil {
+		z.hash = crc32.NewIEEE() // computed, but not checked
+	}
+	z.uncompSize = 0
+	z.props.DictSize = dictSizeOf(dict)
+	z.needDict = true
+	z.needProps = true
+	z.chunkLeft = 0
+	return nil
+}
+
+// nextChunk reads the header of the next LZMA2 chunk,
+// or the end of the block.
+func (z *XZReader) nextChunk() error {
+	control, err := z.r.ReadByte()
+	if err != nil {
+		return noEOF(err)
+	}
+	switch {
+	case control == 0:
+		return z.endBlock()
+	case control == 1 || control == 2:
+		if control == 1 {
+			z.d.win.reset(z.props.DictSize)
+			z.needDict = false
+			z.needProps = true
+		} else if z.needDict {
+			return errCorrupt
+		}
+		var size [2]byte
+		if err := z.r.readFull(size[:], nil); err != nil {
+			return err
+		}
+		z.chunkLeft = int(binary.BigEndian.Uint16(size[:])) + 1
+		z.chunkLZMA = false
+		return nil
+	case control < 0x80:
+		return errCorrupt
+	}
+
+	var hdr [4]byte
+	if err := z.r.readFull(hdr[:], nil); err != nil {
+		return err
+	}
+	uncompressed := int(control&0x1f)<<16 + int(binary.BigEndian.Uint16(hdr[:])) + 1
+	compressed := int(binary.BigEndian.Uint16(hdr[2:])) + 1
+	reset := control >> 5 & 3
+	if reset == 3 {
+		z.d.win.reset(z.props.DictSize)
+		z.needDict = false
+	} else if z.needDict {
+		return errCorrupt
+	}
+	if reset >= 2 {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return noEOF(err)
+		}
+		if err := z.props.decodeProps(b); err != nil {
+			return err
+		}
+		if z.props.LC+z.props.LP > 4 {
+			return errCorrupt
+		}
+		z.needProps = false
+	} else if z.needProps {
+		return errCorrupt
+	}
+	if reset >= 1 {
+		z.d.reset(z.props)
+	}
+
+	if cap(z.chunk) < compressed {
+		z.chunk = make([]byte, chunkMaxCompressed)
+	}
+	z.chunk = z.chunk[:compressed]
+	if err := z.r.readFull(z.chunk, nil); err != nil {
+		return err
+	}
+	z.chunkReader.Reset(z.chunk)
+	if err := z.d.rd.init(&z.chunkReader); err != nil {
+		return errCorrupt
+	}
+	z.d.pending = 0
+	z.d.eos = false
+	z.chunkLeft = uncompressed
+	z.chunkLZMA = true
+	return nil
+}
+
+// endBlock reads the padding and the check of the block.
+func (z *XZReader) endBlock() error {
+	compressed := z.r.n - z.blockStart - z.headerSize
+	if err := z.r.skipPadding(z.blockStart, nil); err != nil {
+		return err
+	}
+	check := make([]byte, checkSize(z.check))
+	if err := z.r.readFull(check, nil); err != nil {
+		return err
+	}
+	if newCheck(z.check) != nil && !bytes.Equal(check, checkSum(z.hash)) {
+		return errChecksum
+	}
+	z.records = append(z.records, indexRecord{
+		unpadded:     uint64(z.headerSize+compressed) + uint64(len(check)),
+		uncompressed: z.uncompSize,
+	})
+	z.inBlock = false
+	return nil
+}
+
+// readIndex reads the index following the indicator at start,
+// and the stream footer.
+func (z *XZReader) readIndex(start int64) error {
+	h := crc32.NewIEEE()
+	h.Write([]byte{0})
+	count, err := z.r.readUvarint(h)
+	if err != nil {
+		return err
+	}
+	if count != uint64(len(z.records)) {
+		return errCorrupt
+	}
+	for _, rec := range z.records {
+		unpadded, err := z.r.readUvarint(h)
+		if err != nil {
+			return err
+		}
+		uncompressed, err := z.r.readUvarint(h)
+		if err != nil {
+			return err
+		}
+		if unpadded != rec.unpadded || uncompressed != rec.uncompressed {
+			return errCorrupt
+		}
+	}
+	if err := z.r.skipPadding(start, h); err != nil {
+		return err
+	}
+	var crc [4]byte
+	if err := z.r.readFull(crc[:], nil); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != h.Sum32() {
+		return errCorrupt
+	}
+	indexSize := z.r.n - start
+
+	var ftr [xzFooterLen]byte
+	if err := z.r.readFull(ftr[:], nil); err != nil {
+		return err
+	}
+	if !bytes.Equal(ftr[10:], xzFooterMagic) ||
+		crc32.ChecksumIEEE(ftr[4:10]) != binary.LittleEndian.Uint32(ftr[:]) ||
+		int64(binary.LittleEndian.Uint32(ftr[4:])+1)*4 != indexSize ||
+		ftr[8] != 0 || ftr[9] != z.check {
+		return errCorrupt
+	}
+	return nil
+}
+
+// nextStream skips the stream padding, and reads the header of
+// the next stream if any.
+func (z *XZReader) nextStream() error {
+	for {
+		b, err := z.r.r.Peek(4)
+		if err == io.EOF && len(b) == 0 {
+			return io.EOF
+		}
+		if len(b) < 4 {
+			return errCorrupt
+		}
+		if !bytes.Equal(b, []byte{0, 0, 0, 0}) {
+			break
+		}
+		z.r.r.Discard(4)
+	}
+	return z.readStreamHeader()
+}
+
+// Close closes the XZReader. It does not close the underlying reader.
+func (z *XZReader) Close() error {
+	z.err = io.ErrClosedPipe
+	return nil
+}
+
+// An XZWriter compresses the written data into a stream of the XZ format,
+// with a block of LZMA2 data and the CRC64 check.
+type XZWriter struct {
+	e          encoder
+	w          io.Writer
+	level      int
+	out        []byte
+	err        error
+	header     bool  // the headers are written
+	total      int64 // the size of the written data
+	compressed int64 // the size of the LZMA2 data
+	hash       hash.Hash64
+
+	needDict  bool // the next chunk resets the dictionary
+	needProps bool // the next LZMA chunk sets the properties
+	needState bool // the next LZMA chunk resets the state
+}
+
+const xzBlockHeaderLen = 12
+
+// NewXZWriter returns a new XZWriter compressing with the default level.
+func NewXZWriter(w io.Writer) *XZWriter {
+	z, _ := NewXZWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewXZWriterLevel returns a new XZWriter compressing with the level
+// from BestSpeed to BestCompression.
+func NewXZWriterLevel(w io.Writer, level int) (*XZWriter, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, errors.New("lzma: invalid compression level")
+	}
+	z := &XZWriter{level: level, hash: crc64.New(crc64Table)}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of the XZWriter and makes it write to w.
+func (z *XZWriter) Reset(w io.Writer) {
+	z.w = w
+	z.e.reset(levels[z.level])
+	z.out = z.out[:0]
+	z.err = nil
+	z.header = false
+	z.total = 0
+	z.compressed = 0
+	z.hash.Reset()
+	z.needDict = true
+	z.needProps = true
+	z.needState = true
+}
+
+// Write compresses p.
+func (z *XZWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	e := &z.e
+	e.slide()
+	e.hist = append(e.hist, p...)
+	z.total += int64(len(p))
+	z.hash.Write(p)
+	for len(e.hist)-e.start >= chunkMaxUncompressed {
+		z.writeChunk(false)
+		if err := z.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes the rest of the data and the end of the stream.
+// It does not close the underlying writer.
+func (z *XZWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	e := &z.e
+	for e.start < len(e.hist) {
+		z.writeChunk(true)
+	}
+
+	var records []indexRecord
+	if z.header {
+		z.out = append(z.out, 0) // the end of the LZMA2 data
+		z.compressed++
+		for n := xzBlockHeaderLen + z.compressed; n%4 != 0; n++ {
+			z.out = append(z.out, 0)
+		}
+		z.out = binary.LittleEndian.AppendUint64(z.out, z.hash.Sum64())
+		records = append(records, indexRecord{
+			unpadded:     uint64(xzBlockHeaderLen + z.compressed + 8),
+			uncompressed: uint64(z.total),
+		})
+	} else {
+		z.writeStreamHeader()
+	}
+
+	// index
+	start := len(z.out)
+	z.out = append(z.out, 0)
+	z.out = binary.AppendUvarint(z.out, uint64(len(records)))
+	for _, rec := range records {
+		z.out = binary.AppendUvarint(z.out, rec.unpadded)
+		z.out = binary.AppendUvarint(z.out, rec.uncompressed)
+	}
+	for (len(z.out)-start)%4 != 0 {
+		z.out = append(z.out, 0)
+	}
+	z.out = binary.LittleEndian.AppendUint32(z.out, crc32.ChecksumIEEE(z.out[start:]))
+	indexSize := len(z.out) - start
+
+	// footer
+	var ftr [xzFooterLen]byte
+	binary.LittleEndian.PutUint32(ftr[4:], uint32(indexSize/4-1))
+	ftr[9] = checkCRC64
+	binary.LittleEndian.PutUint32(ftr[:], crc32.ChecksumIEEE(ftr[4:10]))
+	copy(ftr[10:], xzFooterMagic)
+	z.out = append(z.out, ftr[:]...)
+
+	if err := z.flush(); err != nil {
+		return err
+	}
+	z.err = errors.New("lzma: write after close")
+	return nil
+}
+
+func (z *XZWriter) writeStreamHeader() {
+	z.out = append(z.out, xzHeaderMagic...)
+	flags := []byte{0, checkCRC64}
+	z.out = append(z.out, flags...)
+	z.out = binary.LittleEndian.AppendUint32(z.out, crc32.ChecksumIEEE(flags))
+}
+
+// writeBlockHeader writes the block header of the LZMA2 filter.
+// The dictionary need not be larger than the data if it is final.
+func (z *XZWriter) writeBlockHeader(final bool) {
+	start := len(z.out)
+	dict := dictSizeProp(z.e.dictSizeFor(z.total, final))
+	z.out = append(z.out, xzBlockHeaderLen/4-1, 0, filterLZMA2, 1, dict, 0, 0, 0)
+	z.out = binary.LittleEndian.AppendUint32(z.out, crc32.ChecksumIEEE(z.out[start:]))
+}
+
+// writeChunk encodes the data as an LZMA2 chunk. The chunk holds
+// the data uncompressed, if it is not compressible.
+func (z *XZWriter) writeChunk(final bool) {
+	if !z.header {
+		z.writeStreamHeader()
+		z.writeBlockHeader(final)
+		z.header = true
+	}
+	e := &z.e
+	start := e.start
+	end := min(len(e.hist), start+chunkMaxUncompressed)
+	if z.needState {
+		e.model.reset(defaultProps)
+	}
+	e.rc.reset()
+	e.encode(end, end, chunkMaxCompressed-chunkMaxSymbol)
+	e.rc.flush()
+	size := e.start - start
+	outStart := len(z.out)
+
+	if len(e.rc.out) >= size {
+		for p := e.hist[start:e.start]; len(p) > 0; {
+			n := min(len(p), 1<<16)
+			control := byte(2)
+			if z.needDict {
+				control = 1
+				z.needDict = false
+				z.needProps = true
+			}
+			z.out = append(z.out, control)
+			z.out = binary.BigEndian.AppendUint16(z.out, uint16(n-1))
+			z.out = append(z.out, p[:n]...)
+			p = p[n:]
+		}
+		z.needState = true
+	} else {
+		control := byte(0x80)
+		switch {
+		case z.needDict:
+			control = 0xe0
+		case z.needProps:
+			control = 0xc0
+		case z.needState:
+			control = 0xa0
+		}
+		z.out = append(z.out, control|byte((size-1)>>16))
+		z.out = binary.BigEndian.AppendUint16(z.out, uint16(size-1))
+		z.out = binary.BigEndian.AppendUint16(z.out, uint16(len(e.rc.out)-1))
+		if control >= 0xc0 {
+			z.out = append(z.out, defaultProps.propsByte())
+		}
+		z.out = append(z.out, e.rc.out...)
+		z.needDict, z.needProps, z.needState = false, false, false
+	}
+	z.compressed += int64(len(z.out) - outStart)
+}
+
+func (z *XZWriter) flush() error {
+	if _, err := z.w.Write(z.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.out = z.out[:0]
+	return nil
+}
@@ -0,0 +1,278 @@
+package ppmd
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	rangeTop = 1 << 24
+	rangeBot = 1 << 15
+)
+
+// rangeDecoder is the range decoder of PPMd variant I, which has no carry.
+type rangeDecoder struct {
+	low  uint32
+	rng  uint32
+	code uint32
+	r    io.ByteReader
+	err  error
+}
+
+func (d *rangeDecoder) init(r io.ByteReader) error {
+	d.r = r
+	d.err = nil
+	d.low = 0
+	d.rng = 0xffffffff
+	d.code = 0
+	for i := 0; i < 4; i++ {
+		d.code = d.code<<8 | d.readByte()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.code == 0xffffffff {
+		return errCorrupt
+	}
+	return nil
+}
+
+func (d *rangeDecoder) readByte() uint32 {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		if d.err == nil {
+			d.err = noEOF(err)
+		}
+		return 0
+	}
+	return uint32(b)
+}
+
+// threshold returns the count of the next symbol in total.
+func (d *rangeDecoder) threshold(total uint32) uint32 {
+	if d.rng < total {
+		// not reached by valid data
+		return total
+	}
+	d.rng /= total
+	return d.code / d.rng
+}
+
+func (d *rangeDecoder) decode(start, size uint32) {
+	start *= d.rng
+	d.low += start
+	d.code -= start
+	d.rng *= size
+	d.normalize()
+}
+
+func (d *rangeDecoder) normalize() {
+	for {
+		if d.low^(d.low+d.rng) >= rangeTop {
+			if d.rng >= rangeBot {
+				return
+			}
+			d.rng = -d.low & (rangeBot - 1)
+		}
+		d.code = d.code<<8 | d.readByte()
+		d.rng <<= 8
+		d.low <<= 8
+	}
+}
+
+// decodeSymbol decodes the next symbol. It returns -1 at the end marker,
+// and -2 if the data is corrupt.
+func (m *model) decodeSymbol(rd *rangeDecoder) int {
+	masked := &m.masked
+	mc := m.minContext
+	if ns := m.numStats(mc); ns != 0 {
+		s := m.stats(mc)
+		count := rd.threshold(m.summFreq(mc))
+		hiCnt := m.freq(s)
+		if count < hiCnt {
+			rd.decode(0, hiCnt)
+			m.foundState = s
+			sym := m.symbol(s)
+			m.update1First()
+			return int(sym)
+		}
+		m.prevSuccess = 0
+		for i := ns; i > 0; i-- {
+			s += stateSize
+			if hiCnt += m.freq(s); hiCnt > count {
+				rd.decode(hiCnt-m.freq(s), m.freq(s))
+				m.foundState = s
+				sym := m.symbol(s)
+				m.update1()
+				return int(sym)
+			}
+		}
+		if count >= m.summFreq(mc) {
+			return -2
+		}
+		rd.decode(hiCnt, m.summFreq(mc)-hiCnt)
+		*masked = [256]bool{}
+		for i, s := uint32(0), m.stats(mc); i <= ns; i, s = i+1, s+stateSize {
+			masked[m.symbol(s)] = true
+		}
+	} else {
+		prob := m.binProb()
+		rd.rng >>= 14
+		if rd.code/rd.rng < uint32(*prob) {
+			rd.decode(0, uint32(*prob))
+			*prob = updateProb0(*prob)
+			m.foundState = oneState(mc)
+			sym := m.symbol(m.foundState)
+			m.updateBin()
+			return int(sym)
+		}
+		rd.decode(uint32(*prob), binScale-uint32(*prob))
+		*prob = updateProb1(*prob)
+		m.initEsc = uint32(expEscape[*prob>>10])
+		*masked = [256]bool{}
+		masked[m.symbol(oneState(mc))] = true
+		m.prevSuccess = 0
+	}
+
+	ps := &m.ps
+	for {
+		numMasked := m.numStats(m.minContext)
+		for {
+			m.orderFall++
+			if m.suffix(m.minContext) == 0 {
+				return -1
+			}
+			m.minContext = m.suffix(m.minContext)
+			if m.numStats(m.minContext) != numMasked {
+				break
+			}
+		}
+		mc := m.minContext
+
+		var hiCnt uint32
+		n := 0
+		num := int(m.numStats(mc) - numMasked)
+		for s := m.stats(mc); n != num; s += stateSize {
+			if !masked[m.symbol(s)] {
+				hiCnt += m.freq(s)
+				ps[n] = s
+				n++
+			}
+		}
+
+		see, escFreq := m.makeEscFreq(numMasked)
+		freqSum := escFreq + hiCnt
+		count := rd.threshold(freqSum)
+		if count < hiCnt {
+			k := 0
+			hiCnt = m.freq(ps[0])
+			for hiCnt <= count {
+				k++
+				hiCnt += m.freq(ps[k])
+			}
+			s := ps[k]
+			rd.decode(hiCnt-m.freq(s), m.freq(s))
+			see.update()
+			m.foundState = s
+			sym := m.symbol(s)
+			m.update2()
+			return int(sym)
+		}
+		if count >= freqSum {
+			return -2
+		}
+		rd.decode(hiCnt, freqSum-hiCnt)
+		see.summ += uint16(freqSum)
+		for _, s := range ps[:n] {
+			masked[m.symbol(s)] = true
+		}
+	}
+}
+
+// A Reader decompresses the PPMd data of a zip entry.
+// The data ends with the end marker, or at the end of the input
+// if the writer omits the marker.
+type Reader struct {
+	m     model
+	rd    rangeDecoder
+	r     *bufio.Reader
+	props Props
+	err   error
+}
+
+// NewReader returns a new Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	z := new(Reader)
+	z.Reset(r)
+	return z
+}
+
+// Reset discards the state of the Reader and makes it read from r.
+// The memory of the model is kept for the data of the same memory size.
+func (z *Reader) Reset(r io.Reader) {
+	if z.r == nil {
+		z.r = bufio.NewReader(r)
+	} else {
+		z.r.Reset(r)
+	}
+	z.err = nil
+	if r == nil {
+		z.err = io.ErrClosedPipe
+		return
+	}
+
+	var hdr [2]byte
+	if _, err := io.ReadFull(z.r, hdr[:]); err != nil {
+		z.err = noEOF(err)
+		return
+	}
+	if err := z.props.decodeHeader(hdr); err != nil {
+		z.err = err
+		return
+	}
+	if z.err = z.rd.init(z.r); z.err != nil {
+		return
+	}
+	z.m.init(uint32(z.props.MemSize)<<20, z.props.Order, z.props.Restore)
+}
+
+// Read reads the decompressed data.
+func (z *Reader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := 0
+	for n < len(p) {
+		sym := z.m.decodeSymbol(&z.rd)
+		if z.rd.err != nil {
+			z.err = z.rd.err
+			break
+		}
+		if sym < 0 {
+			z.err = io.EOF
+			if sym == -2 || z.rd.code != 0 {
+				z.err = errCorrupt
+			}
+			break
+		}
+		p[n] = byte(sym)
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, z.err
+}
+
+// Close closes the Reader. It does not close the underlying reader.
+func (z *Reader) Close() error {
+	z.err = io.ErrClosedPipe
+	return nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
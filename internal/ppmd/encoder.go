@@ -0,0 +1,244 @@
+package ppmd
+
+import (
+	"errors"
+	"io"
+)
+
+// rangeEncoder is the range encoder of PPMd variant I.
+type rangeEncoder struct {
+	low uint32
+	rng uint32
+	out []byte
+}
+
+func (e *rangeEncoder) init() {
+	e.low = 0
+	e.rng = 0xffffffff
+	e.out = e.out[:0]
+}
+
+func (e *rangeEncoder) encode(start, size, total uint32) {
+	e.rng /= total
+	e.low += start * e.rng
+	e.rng *= size
+	e.normalize()
+}
+
+func (e *rangeEncoder) encodeBit0(size0 uint32) {
+	e.rng >>= 14
+	e.rng *= size0
+	e.normalize()
+}
+
+func (e *rangeEncoder) encodeBit1(size0 uint32) {
+	e.rng >>= 14
+	e.low += size0 * e.rng
+	e.rng *= binScale - size0
+	e.normalize()
+}
+
+func (e *rangeEncoder) normalize() {
+	for {
+		if e.low^(e.low+e.rng) >= rangeTop {
+			if e.rng >= rangeBot {
+				return
+			}
+			e.rng = -e.low & (rangeBot - 1)
+		}
+		e.out = append(e.out, byte(e.low>>24))
+		e.rng <<= 8
+		e.low <<= 8
+	}
+}
+
+func (e *rangeEncoder) flush() {
+	for i := 0; i < 4; i++ {
+		e.out = append(e.out, byte(e.low>>24))
+		e.low <<= 8
+	}
+}
+
+// encodeSymbol encodes the symbol, or the end marker if sym is -1.
+func (m *model) encodeSymbol(re *rangeEncoder, sym int) {
+	masked := &m.masked
+	mc := m.minContext
+	if ns := m.numStats(mc); ns != 0 {
+		s := m.stats(mc)
+		if int(m.symbol(s)) == sym {
+			re.encode(0, m.freq(s), m.summFreq(mc))
+			m.foundState = s
+			m.update1First()
+			return
+		}
+		m.prevSuccess = 0
+		sum := m.freq(s)
+		for i := ns; i > 0; i-- {
+			s += stateSize
+			if int(m.symbol(s)) == sym {
+				re.encode(sum, m.freq(s), m.summFreq(mc))
+				m.foundState = s
+				m.update1()
+				return
+			}
+			sum += m.freq(s)
+		}
+		*masked = [256]bool{}
+		for i, s := uint32(0), m.stats(mc); i <= ns; i, s = i+1, s+stateSize {
+			masked[m.symbol(s)] = true
+		}
+		re.encode(sum, m.summFreq(mc)-sum, m.summFreq(mc))
+	} else {
+		prob := m.binProb()
+		s := oneState(mc)
+		if int(m.symbol(s)) == sym {
+			re.encodeBit0(uint32(*prob))
+			*prob = updateProb0(*prob)
+			m.foundState = s
+			m.updateBin()
+			return
+		}
+		re.encodeBit1(uint32(*prob))
+		*prob = updateProb1(*prob)
+		m.initEsc = uint32(expEscape[*prob>>10])
+		*masked = [256]bool{}
+		masked[m.symbol(s)] = true
+		m.prevSuccess = 0
+	}
+
+	for {
+		numMasked := m.numStats(m.minContext)
+		for {
+			m.orderFall++
+			if m.suffix(m.minContext) == 0 {
+				return // the end marker
+			}
+			m.minContext = m.suffix(m.minContext)
+			if m.numStats(m.minContext) != numMasked {
+				break
+			}
+		}
+		mc := m.minContext
+
+		see, escFreq := m.makeEscFreq(numMasked)
+		var sum uint32
+		s := m.stats(mc)
+		for i := m.numStats(mc) + 1; i > 0; i, s = i-1, s+stateSize {
+			cur := m.symbol(s)
+			if int(cur) == sym {
+				low, s1 := sum, s
+				for ; i > 0; i, s = i-1, s+stateSize {
+					if !masked[m.symbol(s)] {
+						sum += m.freq(s)
+					}
+				}
+				re.encode(low, m.freq(s1), sum+escFreq)
+				see.update()
+				m.foundState = s1
+				m.update2()
+				return
+			}
+			if !masked[cur] {
+				sum += m.freq(s)
+				masked[cur] = true
+			}
+		}
+		re.encode(sum, escFreq, sum+escFreq)
+		see.summ += uint16(sum + escFreq)
+	}
+}
+
+// A Writer compresses the written data into PPMd data of a zip entry.
+// As 7-Zip does, it ends the data with the end marker.
+type Writer struct {
+	m      model
+	re     rangeEncoder
+	w      io.Writer
+	props  Props
+	header bool // the header is written
+	err    error
+}
+
+// NewWriter returns a new Writer compressing with the default level.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a new Writer compressing with the level
+// from BestSpeed to BestCompression. The higher levels use the higher
+// model orders and the larger memory, up to 128 MiB.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, errors.New("ppmd: invalid compression level")
+	}
+	return NewWriterProps(w, levels[level])
+}
+
+// NewWriterProps returns a new Writer compressing with the parameters.
+func NewWriterProps(w io.Writer, props Props) (*Writer, error) {
+	if !props.valid() {
+		return nil, errors.New("ppmd: invalid parameters")
+	}
+	z := &Writer{props: props}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of the Writer and makes it write to w.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.m.init(uint32(z.props.MemSize)<<20, z.props.Order, z.props.Restore)
+	z.re.init()
+	z.header = false
+	z.err = nil
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	for i, b := range p {
+		z.m.encodeSymbol(&z.re, int(b))
+		if len(z.re.out) >= 1<<16 {
+			if err := z.flush(); err != nil {
+				return i + 1, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// Close writes the rest of the data.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	z.m.encodeSymbol(&z.re, -1)
+	z.re.flush()
+	if err := z.flush(); err != nil {
+		return err
+	}
+	z.err = errors.New("ppmd: write after close")
+	return nil
+}
+
+// flush writes the encoded data, following the header.
+func (z *Writer) flush() error {
+	if !z.header {
+		hdr := z.props.header()
+		if _, err := z.w.Write(hdr[:]); err != nil {
+			z.err = err
+			return err
+		}
+		z.header = true
+	}
+	if _, err := z.w.Write(z.re.out); err != nil {
+		z.err = err
+		return err
+	}
+	z.re.out = z.re.out[:0]
+	return nil
+}
@@ -0,0 +1,1121 @@
+package ppmd
+
+import "encoding/binary"
+
+// The model lives in a single memory block, as in the reference
+// implementation: the text area at the bottom, and the units of
+// 12 bytes above, holding the contexts and the states. The allocation
+// decides when the memory is exhausted, so it is reproduced exactly.
+//
+// A context (12 bytes) holds the number of the states minus one,
+// the flags, the sum of the frequencies, the offset of the states and
+// the offset of the suffix context. A context of one state holds the
+// state in place of the sum and the offset of the states.
+//
+// A state (6 bytes) holds the symbol, the frequency and the offset of
+// the successor: a context, or a position of the text area.
+//
+// A free block (12 bytes at the start) holds the stamp, the offset of
+// the next free block and the number of the units.
+
+const (
+	unitSize   = 12
+	stateSize  = 6
+	numIndexes = 4 + 4 + 4 + 26
+	maxFreq    = 124
+	intBits    = 7
+	periodBits = 7
+	binScale   = 1 << (intBits + periodBits)
+	emptyNode  = 0xffffffff
+)
+
+var (
+	indx2Units [numIndexes]uint8
+	units2Indx [128]uint8
+	ns2BSIndx  [256]uint8
+	ns2Indx    [260]uint8
+
+	expEscape  = [16]uint8{25, 14, 9, 7, 5, 5, 4, 4, 4, 3, 3, 3, 2, 2, 2, 2}
+	initBinEsc = [8]uint16{0x3CDD, 0x1F3F, 0x59BF, 0x48F3, 0x64A1, 0x5ABC, 0x6632, 0x6051}
+)
+
+func init() {
+	k := 0
+	for i := 0; i < numIndexes; i++ {
+		step := 4
+		if i < 12 {
+			step = i>>2 + 1
+		}
+		for ; step > 0; step-- {
+			units2Indx[k] = uint8(i)
+			k++
+		}
+		indx2Units[i] = uint8(k)
+	}
+
+	ns2BSIndx[0] = 0 << 1
+	ns2BSIndx[1] = 1 << 1
+	for i := 2; i < 11; i++ {
+		ns2BSIndx[i] = 2 << 1
+	}
+	for i := 11; i < 256; i++ {
+		ns2BSIndx[i] = 3 << 1
+	}
+
+	for i := 0; i < 5; i++ {
+		ns2Indx[i] = uint8(i)
+	}
+	m, k := 5, 1
+	for i := 5; i < len(ns2Indx); i++ {
+		ns2Indx[i] = uint8(m)
+		if k--; k == 0 {
+			m++
+			k = m - 4
+		}
+	}
+}
+
+func i2u(indx int) uint32  { return uint32(indx2Units[indx]) }
+func u2i(nu uint32) int    { return int(units2Indx[nu-1]) }
+func u2b(nu uint32) uint32 { return nu * unitSize }
+
+// see is an adaptive estimator of the escape frequency.
+type see struct {
+	summ  uint16
+	shift uint8
+	count uint8
+}
+
+func (s *see) update() {
+	if s.shift < periodBits {
+		if s.count--; s.count == 0 {
+			s.summ <<= 1
+			s.count = uint8(3 << s.shift)
+			s.shift++
+		}
+	}
+}
+
+// model is the PPMd variant I model.
+type model struct {
+	mem         []byte
+	size        uint32
+	alignOffset uint32
+
+	minContext, maxContext uint32
+	foundState             uint32
+	orderFall              int
+	initEsc                uint32
+	prevSuccess            int
+	maxOrder               int
+	restoreMethod          int
+	runLength, initRL      int32
+
+	glueCount                        uint32
+	loUnit, hiUnit, text, unitsStart uint32
+	freeList                         [numIndexes]uint32
+	stamps                           [numIndexes]uint32
+
+	dummySee see
+	see      [24][32]see
+	binSumm  [25][64]uint16
+
+	// the scratch space of the coding of a symbol
+	masked [256]bool   // the symbols of the escaped contexts
+	ps     [256]uint32 // the states not masked
+}
+
+// init allocates the memory of size bytes, and starts the model.
+func (m *model) init(size uint32, maxOrder, restoreMethod int) {
+	m.alignOffset = 4 - size&3
+	n := int(m.alignOffset + size)
+	if cap(m.mem) < n {
+		m.mem = nil // release the old memory first
+		m.mem = make([]byte, n)
+	}
+	m.mem = m.mem[:n]
+	m.size = size
+	m.maxOrder = maxOrder
+	m.restoreMethod = restoreMethod
+	m.restartModel()
+	m.dummySee = see{shift: periodBits, count: 64}
+}
+
+func (m *model) get16(off uint32) uint16 { return binary.LittleEndian.Uint16(m.mem[off:]) }
+func (m *model) get32(off uint32) uint32 { return binary.LittleEndian.Uint32(m.mem[off:]) }
+func (m *model) put16(off uint32, v uint16) {
+	binary.LittleEndian.PutUint16(m.mem[off:], v)
+}
+func (m *model) put32(off uint32, v uint32) {
+	binary.LittleEndian.PutUint32(m.mem[off:], v)
+}
+
+// the fields of the contexts
+func (m *model) numStats(c uint32) uint32       { return uint32(m.mem[c]) }
+func (m *model) flags(c uint32) uint8           { return m.mem[c+1] }
+func (m *model) summFreq(c uint32) uint32       { return uint32(m.get16(c + 2)) }
+func (m *model) setSummFreq(c uint32, v uint32) { m.put16(c+2, uint16(v)) }
+func (m *model) stats(c uint32) uint32          { return m.get32(c + 4) }
+func (m *model) setStats(c, s uint32)           { m.put32(c+4, s) }
+func (m *model) suffix(c uint32) uint32         { return m.get32(c + 8) }
+func oneState(c uint32) uint32                  { return c + 2 }
+
+// the fields of the states
+func (m *model) symbol(s uint32) uint8           { return m.mem[s] }
+func (m *model) freq(s uint32) uint32            { return uint32(m.mem[s+1]) }
+func (m *model) setFreq(s uint32, v uint32)      { m.mem[s+1] = uint8(v) }
+func (m *model) successor(s uint32) uint32       { return m.get32(s + 2) }
+func (m *model) setSuccessor(s uint32, v uint32) { m.put32(s+2, v) }
+
+func (m *model) copyState(dst, src uint32) {
+	copy(m.mem[dst:dst+stateSize], m.mem[src:src+stateSize])
+}
+
+func (m *model) swapStates(s1, s2 uint32) {
+	var tmp [stateSize]byte
+	copy(tmp[:], m.mem[s1:])
+	copy(m.mem[s1:s1+stateSize], m.mem[s2:])
+	copy(m.mem[s2:s2+stateSize], tmp[:])
+}
+
+// highFlag returns the flag of the symbols from 0x40.
+func highFlag(sym uint8, flag uint8) uint8 {
+	if sym >= 0x40 {
+		return flag
+	}
+	return 0
+}
+
+func (m *model) insertNode(node uint32, indx int) {
+	m.put32(node, emptyNode)
+	m.put32(node+4, m.freeList[indx])
+	m.put32(node+8, i2u(indx))
+	m.freeList[indx] = node
+	m.stamps[indx]++
+}
+
+func (m *model) removeNode(indx int) uint32 {
+	node := m.freeList[indx]
+	m.freeList[indx] = m.get32(node + 4)
+	m.stamps[indx]--
+	return node
+}
+
+func (m *model) splitBlock(ptr uint32, oldIndx, newIndx int) {
+	nu := i2u(oldIndx) - i2u(newIndx)
+	ptr += u2b(i2u(newIndx))
+	i := u2i(nu)
+	if i2u(i) != nu {
+		i--
+		k := i2u(i)
+		m.insertNode(ptr+u2b(k), int(nu-k-1))
+	}
+	m.insertNode(ptr, i)
+}
+
+// glueFreeBlocks joins the adjacent free blocks, and sorts them again
+// to the free lists.
+func (m *model) glueFreeBlocks() {
+	var head uint32
+	prev := uint32(0) // the offset of the link to the next block, or 0 for head
+	link := func(node uint32) {
+		if prev == 0 {
+			head = node
+		} else {
+			m.put32(prev, node)
+		}
+	}
+
+	m.glueCount = 1 << 13
+	m.stamps = [numIndexes]uint32{}
+
+	// The order-0 context is at the top unit, so that only a guard
+	// at loUnit is needed.
+	if m.loUnit != m.hiUnit {
+		m.put32(m.loUnit, 0)
+	}
+
+	for i := range m.freeList {
+		next := m.freeList[i]
+		m.freeList[i] = 0
+		for next != 0 {
+			node := next
+			if nu := m.get32(node + 8); nu != 0 {
+				link(node)
+				prev = node + 4
+				for {
+					node2 := node + u2b(nu)
+					if m.get32(node2) != emptyNode {
+						break
+					}
+					nu += m.get32(node2 + 8)
+					m.put32(node2+8, 0)
+					m.put32(node+8, nu)
+				}
+			}
+			next = m.get32(node + 4)
+		}
+	}
+	link(0)
+
+	for head != 0 {
+		node := head
+		head = m.get32(node + 4)
+		nu := m.get32(node + 8)
+		if nu == 0 {
+			continue
+		}
+		for ; nu > 128; nu, node = nu-128, node+u2b(128) {
+			m.insertNode(node, numIndexes-1)
+		}
+		i := u2i(nu)
+		if i2u(i) != nu {
+			i--
+			k := i2u(i)
+			m.insertNode(node+u2b(k), int(nu-k-1))
+		}
+		m.insertNode(node, i)
+	}
+}
+
+// allocUnitsRare allocates the units when the free list of indx is empty.
+// It returns 0 if the memory is exhausted.
+func (m *model) allocUnitsRare(indx int) uint32 {
+	if m.glueCount == 0 {
+		m.glueFreeBlocks()
+		if m.freeList[indx] != 0 {
+			return m.removeNode(indx)
+		}
+	}
+	i := indx
+	for {
+		if i++; i == numIndexes {
+			numBytes := u2b(i2u(indx))
+			m.glueCount--
+			if m.unitsStart-m.text > numBytes {
+				m.unitsStart -= numBytes
+				return m.unitsStart
+			}
+			return 0
+		}
+		if m.freeList[i] != 0 {
+			break
+		}
+	}
+	ptr := m.removeNode(i)
+	m.splitBlock(ptr, i, indx)
+	return ptr
+}
+
+func (m *model) allocUnits(indx int) uint32 {
+	if m.freeList[indx] != 0 {
+		return m.removeNode(indx)
+	}
+	numBytes := u2b(i2u(indx))
+	if numBytes <= m.hiUnit-m.loUnit {
+		ptr := m.loUnit
+		m.loUnit += numBytes
+		return ptr
+	}
+	return m.allocUnitsRare(indx)
+}
+
+func (m *model) allocContext() uint32 {
+	if m.hiUnit != m.loUnit {
+		m.hiUnit -= unitSize
+		return m.hiUnit
+	}
+	if m.freeList[0] != 0 {
+		return m.removeNode(0)
+	}
+	return m.allocUnitsRare(0)
+}
+
+func (m *model) shrinkUnits(oldPtr, oldNU, newNU uint32) uint32 {
+	i0, i1 := u2i(oldNU), u2i(newNU)
+	if i0 == i1 {
+		return oldPtr
+	}
+	if m.freeList[i1] != 0 {
+		ptr := m.removeNode(i1)
+		copy(m.mem[ptr:ptr+u2b(newNU)], m.mem[oldPtr:])
+		m.insertNode(oldPtr, i0)
+		return ptr
+	}
+	m.splitBlock(oldPtr, i0, i1)
+	return oldPtr
+}
+
+func (m *model) freeUnits(ptr, nu uint32) {
+	m.insertNode(ptr, u2i(nu))
+}
+
+func (m *model) specialFreeUnit(ptr uint32) {
+	if ptr != m.unitsStart {
+		m.insertNode(ptr, 0)
+	} else {
+		m.unitsStart += unitSize
+	}
+}
+
+// moveUnitsUp moves the units near the text area to a free block above.
+func (m *model) moveUnitsUp(oldPtr, nu uint32) uint32 {
+	indx := u2i(nu)
+	if oldPtr > m.unitsStart+16*1024 || oldPtr > m.freeList[indx] {
+		return oldPtr
+	}
+	ptr := m.removeNode(indx)
+	copy(m.mem[ptr:ptr+u2b(nu)], m.mem[oldPtr:])
+	if oldPtr != m.unitsStart {
+		m.insertNode(oldPtr, indx)
+	} else {
+		m.unitsStart += u2b(i2u(indx))
+	}
+	return ptr
+}
+
+// expandTextArea gives the free blocks at the bottom of the units
+// to the text area.
+func (m *model) expandTextArea() {
+	var count [numIndexes]uint32
+	if m.loUnit != m.hiUnit {
+		m.put32(m.loUnit, 0)
+	}
+
+	node := m.unitsStart
+	for m.get32(node) == emptyNode {
+		m.put32(node, 0)
+		nu := m.get32(node + 8)
+		count[u2i(nu)]++
+		node += u2b(nu)
+	}
+	m.unitsStart = node
+
+	for i := range m.freeList {
+		next := uint32(0) // the offset of the link, or 0 for the list head
+		for count[i] != 0 {
+			var node uint32
+			if next == 0 {
+				node = m.freeList[i]
+			} else {
+				node = m.get32(next)
+			}
+			for m.get32(node) == 0 {
+				node = m.get32(node + 4)
+				if next == 0 {
+					m.freeList[i] = node
+				} else {
+					m.put32(next, node)
+				}
+				m.stamps[i]--
+				if count[i]--; count[i] == 0 {
+					break
+				}
+			}
+			next = node + 4
+		}
+	}
+}
+
+func (m *model) usedMemory() uint32 {
+	var v uint32
+	for i, n := range m.stamps {
+		v += n * i2u(i)
+	}
+	return m.size - (m.hiUnit - m.loUnit) - (m.unitsStart - m.text) - u2b(v)
+}
+
+func (m *model) restartModel() {
+	m.freeList = [numIndexes]uint32{}
+	m.stamps = [numIndexes]uint32{}
+	m.text = m.alignOffset
+	m.hiUnit = m.text + m.size
+	m.loUnit = m.hiUnit - m.size/8/unitSize*7*unitSize
+	m.unitsStart = m.loUnit
+	m.glueCount = 0
+
+	m.orderFall = m.maxOrder
+	m.initRL = -int32(min(m.maxOrder, 12)) - 1
+	m.runLength = m.initRL
+	m.prevSuccess = 0
+
+	m.hiUnit -= unitSize
+	c := m.hiUnit
+	m.minContext, m.maxContext = c, c
+	m.put32(c+8, 0)
+	m.mem[c] = 255
+	m.mem[c+1] = 0
+	m.setSummFreq(c, 256+1)
+	m.foundState = m.loUnit
+	m.setStats(c, m.loUnit)
+	for i := uint32(0); i < 256; i++ {
+		s := m.loUnit + i*stateSize
+		m.mem[s] = uint8(i)
+		m.mem[s+1] = 1
+		m.setSuccessor(s, 0)
+	}
+	m.loUnit += u2b(256 / 2)
+
+	n := 0
+	for i := range m.binSumm {
+		for int(ns2Indx[n]) == i {
+			n++
+		}
+		for k, esc := range initBinEsc {
+			v := uint16(binScale - uint32(esc)/uint32(n+1))
+			for j := 0; j < 64; j += 8 {
+				m.binSumm[i][k+j] = v
+			}
+		}
+	}
+	n = 0
+	for i := range m.see {
+		for int(ns2Indx[n+3]) == i+3 {
+			n++
+		}
+		for k := range m.see[i] {
+			m.see[i][k] = see{
+				summ:  uint16((2*n + 5) << (periodBits - 4)),
+				shift: periodBits - 4,
+				count: 7,
+			}
+		}
+	}
+}
+
+// refresh halves the frequencies of the states of ctx, if scale is 1,
+// after some states are removed.
+func (m *model) refresh(ctx, oldNU uint32, scale uint32) {
+	i := m.numStats(ctx)
+	s := m.shrinkUnits(m.stats(ctx), oldNU, (i+2)>>1)
+	m.setStats(ctx, s)
+	flags := m.flags(ctx)&(0x10+0x04*uint8(scale)) + highFlag(m.symbol(s), 0x08)
+	escFreq := m.summFreq(ctx) - m.freq(s)
+	m.setFreq(s, (m.freq(s)+scale)>>scale)
+	sumFreq := m.freq(s)
+	for ; i > 0; i-- {
+		s += stateSize
+		escFreq -= m.freq(s)
+		m.setFreq(s, (m.freq(s)+scale)>>scale)
+		sumFreq += m.freq(s)
+		flags |= highFlag(m.symbol(s), 0x08)
+	}
+	m.setSummFreq(ctx, sumFreq+(escFreq+scale)>>scale)
+	m.mem[ctx+1] = flags
+}
+
+// cutOff removes the states whose successors are in the text area,
+// and the contexts above the maximum order. It returns the context,
+// or 0 if it is removed.
+func (m *model) cutOff(ctx uint32, order int) uint32 {
+	if m.numStats(ctx) == 0 {
+		s := oneState(ctx)
+		if m.successor(s) >= m.unitsStart {
+			if order < m.maxOrder {
+				m.setSuccessor(s, m.cutOff(m.successor(s), order+1))
+			} else {
+				m.setSuccessor(s, 0)
+			}
+			if m.successor(s) != 0 || order <= 9 {
+				return ctx
+			}
+		}
+		m.specialFreeUnit(ctx)
+		return 0
+	}
+
+	nu := (m.numStats(ctx) + 2) >> 1
+	m.setStats(ctx, m.moveUnitsUp(m.stats(ctx), nu))
+	stats := m.stats(ctx)
+	i := int(m.numStats(ctx))
+	for s := stats + uint32(i)*stateSize; ; s -= stateSize {
+		if m.successor(s) < m.unitsStart {
+			s2 := stats + uint32(i)*stateSize
+			i--
+			m.setSuccessor(s, 0)
+			m.swapStates(s, s2)
+		} else if order < m.maxOrder {
+			m.setSuccessor(s, m.cutOff(m.successor(s), order+1))
+		} else {
+			m.setSuccessor(s, 0)
+		}
+		if s == stats {
+			break
+		}
+	}
+
+	if i != int(m.numStats(ctx)) && order != 0 {
+		m.mem[ctx] = uint8(i)
+		s := stats
+		switch {
+		case i < 0:
+			m.freeUnits(s, nu)
+			m.specialFreeUnit(ctx)
+			return 0
+		case i == 0:
+			m.mem[ctx+1] = m.flags(ctx)&0x10 + highFlag(m.symbol(s), 0x08)
+			m.copyState(oneState(ctx), s)
+			m.freeUnits(s, nu)
+			s = oneState(ctx)
+			m.setFreq(s, (m.freq(s)+11)>>3)
+		default:
+			var scale uint32
+			if m.summFreq(ctx) > 16*uint32(i) {
+				scale = 1
+			}
+			m.refresh(ctx, nu, scale)
+		}
+	}
+	return ctx
+}
+
+// restoreModel removes the states added from maxContext to c1, and
+// restarts the model, or cuts it off, as the memory is exhausted.
+func (m *model) restoreModel(c1 uint32) {
+	m.text = m.alignOffset
+
+	c := m.maxContext
+	for ; c != c1; c = m.suffix(c) {
+		m.mem[c]--
+		if ns := m.numStats(c); ns == 0 {
+			s := m.stats(c)
+			m.mem[c+1] = m.flags(c)&0x10 + highFlag(m.symbol(s), 0x08)
+			m.copyState(oneState(c), s)
+			m.specialFreeUnit(s)
+			s = oneState(c)
+			m.setFreq(s, (m.freq(s)+11)>>3)
+		} else {
+			m.refresh(c, (ns+3)>>1, 0)
+		}
+	}
+	for ; c != m.minContext; c = m.suffix(c) {
+		if ns := m.numStats(c); ns == 0 {
+			s := oneState(c)
+			m.setFreq(s, m.freq(s)-m.freq(s)>>1)
+		} else {
+			m.setSummFreq(c, m.summFreq(c)+4)
+			if m.summFreq(c) > 128+4*ns {
+				m.refresh(c, (ns+2)>>1, 1)
+			}
+		}
+	}
+
+	if m.restoreMethod == RestoreRestart || m.usedMemory() < m.size>>1 {
+		m.restartModel()
+	} else {
+		for m.suffix(m.maxContext) != 0 {
+			m.maxContext = m.suffix(m.maxContext)
+		}
+		for {
+			m.cutOff(m.maxContext, 0)
+			m.expandTextArea()
+			if m.usedMemory() <= 3*(m.size>>2) {
+				break
+			}
+		}
+		m.glueCount = 0
+		m.orderFall = m.maxOrder
+	}
+	m.minContext = m.maxContext
+}
+
+// createSuccessors creates the contexts following the found state,
+// from the context c. It returns 0 if the memory is exhausted.
+func (m *model) createSuccessors(skip bool, s1, c uint32) uint32 {
+	upBranch := m.successor(m.foundState)
+	fSymbol := m.symbol(m.foundState)
+	var ps [MaxOrder + 1]uint32
+	numPs := 0
+	if !skip {
+		ps[numPs] = m.foundState
+		numPs++
+	}
+
+	for m.suffix(c) != 0 {
+		c = m.suffix(c)
+		var s uint32
+		switch {
+		case s1 != 0:
+			s = s1
+			s1 = 0
+		case m.numStats(c) != 0:
+			for s = m.stats(c); m.symbol(s) != fSymbol; s += stateSize {
+			}
+			if m.freq(s) < maxFreq-9 {
+				m.setFreq(s, m.freq(s)+1)
+				m.setSummFreq(c, m.summFreq(c)+1)
+			}
+		default:
+			s = oneState(c)
+			if m.numStats(m.suffix(c)) == 0 && m.freq(s) < 24 {
+				m.setFreq(s, m.freq(s)+1)
+			}
+		}
+		if successor := m.successor(s); successor != upBranch {
+			c = successor
+			if numPs == 0 {
+				return c
+			}
+			break
+		}
+		ps[numPs] = s
+		numPs++
+	}
+
+	upSymbol := m.symbol(upBranch)
+	flags := highFlag(fSymbol, 0x10) + highFlag(upSymbol, 0x08)
+	var upFreq uint32
+	if m.numStats(c) == 0 {
+		upFreq = m.freq(oneState(c))
+	} else {
+		s := m.stats(c)
+		for m.symbol(s) != upSymbol {
+			s += stateSize
+		}
+		cf := m.freq(s) - 1
+		s0 := m.summFreq(c) - m.numStats(c) - cf
+		if 2*cf <= s0 {
+			upFreq = 1
+			if 5*cf > s0 {
+				upFreq++
+			}
+		} else {
+			upFreq = 1 + (cf+2*s0-3)/s0
+		}
+	}
+
+	for numPs != 0 {
+		c1 := m.allocContext()
+		if c1 == 0 {
+			return 0
+		}
+		m.mem[c1] = 0
+		m.mem[c1+1] = flags
+		s := oneState(c1)
+		m.mem[s] = upSymbol
+		m.setFreq(s, upFreq)
+		m.setSuccessor(s, upBranch+1)
+		m.put32(c1+8, c)
+		numPs--
+		m.setSuccessor(ps[numPs], c1)
+		c = c1
+	}
+	return c
+}
+
+// reduceOrder makes the found state follow to the text, when the
+// order falls. It returns 0 if the memory is exhausted.
+func (m *model) reduceOrder(s1, c uint32) uint32 {
+	c1 := c
+	upBranch := m.text
+	fSymbol := m.symbol(m.foundState)
+	m.setSuccessor(m.foundState, upBranch)
+	m.orderFall++
+
+	var s uint32
+	for {
+		if s1 != 0 {
+			c = m.suffix(c)
+			s = s1
+			s1 = 0
+		} else {
+			if m.suffix(c) == 0 {
+				return c
+			}
+			c = m.suffix(c)
+			if m.numStats(c) != 0 {
+				for s = m.stats(c); m.symbol(s) != fSymbol; s += stateSize {
+				}
+				if m.freq(s) < maxFreq-9 {
+					m.setFreq(s, m.freq(s)+2)
+					m.setSummFreq(c, m.summFreq(c)+2)
+				}
+			} else {
+				s = oneState(c)
+				if m.freq(s) < 32 {
+					m.setFreq(s, m.freq(s)+1)
+				}
+			}
+		}
+		if m.successor(s) != 0 {
+			break
+		}
+		m.setSuccessor(s, upBranch)
+		m.orderFall++
+	}
+
+	if m.successor(s) <= upBranch {
+		s2 := m.foundState
+		m.foundState = s
+		m.setSuccessor(s, m.createSuccessors(false, 0, c))
+		m.foundState = s2
+	}
+	if m.orderFall == 1 && c1 == m.maxContext {
+		m.setSuccessor(m.foundState, m.successor(s))
+		m.text--
+	}
+	return m.successor(s)
+}
+
+// updateModel adds the found symbol to the contexts from maxContext
+// to minContext, and moves to the next context.
+func (m *model) updateModel() {
+	fs := m.foundState
+	fSymbol := m.symbol(fs)
+	fFreq := m.freq(fs)
+	fSuccessor := m.successor(fs)
+	var s uint32
+
+	if fFreq < maxFreq/4 && m.suffix(m.minContext) != 0 {
+		c := m.suffix(m.minContext)
+		if m.numStats(c) == 0 {
+			s = oneState(c)
+			if m.freq(s) < 32 {
+				m.setFreq(s, m.freq(s)+1)
+			}
+		} else {
+			s = m.stats(c)
+			if m.symbol(s) != fSymbol {
+				for {
+					s += stateSize
+					if m.symbol(s) == fSymbol {
+						break
+					}
+				}
+				if m.freq(s) >= m.freq(s-stateSize) {
+					m.swapStates(s, s-stateSize)
+					s -= stateSize
+				}
+			}
+			if m.freq(s) < maxFreq-9 {
+				m.setFreq(s, m.freq(s)+2)
+				m.setSummFreq(c, m.summFreq(c)+2)
+			}
+		}
+	}
+
+	c := m.maxContext
+	if m.orderFall == 0 && fSuccessor != 0 {
+		cs := m.createSuccessors(true, s, m.minContext)
+		m.setSuccessor(fs, cs)
+		if cs == 0 {
+			m.restoreModel(c)
+			return
+		}
+		m.maxContext = cs
+		return
+	}
+
+	m.mem[m.text] = fSymbol
+	m.text++
+	successor := m.text
+	if m.text >= m.unitsStart {
+		m.restoreModel(c)
+		return
+	}
+
+	if fSuccessor == 0 {
+		cs := m.reduceOrder(s, m.minContext)
+		if cs == 0 {
+			m.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	} else if fSuccessor < m.unitsStart {
+		cs := m.createSuccessors(false, s, m.minContext)
+		if cs == 0 {
+			m.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	}
+
+	if m.orderFall--; m.orderFall == 0 {
+		successor = fSuccessor
+		if m.maxContext != m.minContext {
+			m.text--
+		}
+	}
+
+	ns := m.numStats(m.minContext)
+	s0 := m.summFreq(m.minContext) - ns - fFreq
+	flag := highFlag(fSymbol, 0x08)
+
+	for ; c != m.minContext; c = m.suffix(c) {
+		ns1 := m.numStats(c)
+		if ns1 != 0 {
+			if ns1&1 != 0 {
+				// the states grow by a unit
+				oldNU := (ns1 + 1) >> 1
+				i := u2i(oldNU)
+				if i != u2i(oldNU+1) {
+					ptr := m.allocUnits(i + 1)
+					if ptr == 0 {
+						m.restoreModel(c)
+						return
+					}
+					oldPtr := m.stats(c)
+					copy(m.mem[ptr:ptr+u2b(oldNU)], m.mem[oldPtr:])
+					m.insertNode(oldPtr, i)
+					m.setStats(c, ptr)
+				}
+			}
+			if 3*ns1+1 < ns {
+				m.setSummFreq(c, m.summFreq(c)+1)
+			}
+		} else {
+			s2 := m.allocUnits(0)
+			if s2 == 0 {
+				m.restoreModel(c)
+				return
+			}
+			m.copyState(s2, oneState(c))
+			m.setStats(c, s2)
+			if m.freq(s2) < maxFreq/4-1 {
+				m.setFreq(s2, m.freq(s2)<<1)
+			} else {
+				m.setFreq(s2, maxFreq-4)
+			}
+			sf := m.freq(s2) + m.initEsc
+			if ns > 2 {
+				sf++
+			}
+			m.setSummFreq(c, sf)
+		}
+
+		cf := 2 * fFreq * (m.summFreq(c) + 6)
+		sf := s0 + m.summFreq(c)
+		if cf < 6*sf {
+			n := uint32(1)
+			if cf > sf {
+				n++
+			}
+			if cf >= 4*sf {
+				n++
+			}
+			cf = n
+			m.setSummFreq(c, m.summFreq(c)+4)
+		} else {
+			n := uint32(4)
+			if cf > 9*sf {
+				n++
+			}
+			if cf > 12*sf {
+				n++
+			}
+			if cf > 15*sf {
+				n++
+			}
+			cf = n
+			m.setSummFreq(c, m.summFreq(c)+cf)
+		}
+
+		s2 := m.stats(c) + (ns1+1)*stateSize
+		m.setSuccessor(s2, successor)
+		m.mem[s2] = fSymbol
+		m.setFreq(s2, cf)
+		m.mem[c+1] |= flag
+		m.mem[c] = uint8(ns1 + 1)
+	}
+	m.maxContext = fSuccessor
+	m.minContext = fSuccessor
+}
+
+// rescale halves the frequencies of the states of minContext, and
+// removes the states of zero frequency.
+func (m *model) rescale() {
+	mc := m.minContext
+	stats := m.stats(mc)
+	s := m.foundState
+
+	// move the found state to the front
+	if s != stats {
+		var tmp [stateSize]byte
+		copy(tmp[:], m.mem[s:])
+		copy(m.mem[stats+stateSize:s+stateSize], m.mem[stats:s])
+		copy(m.mem[stats:stats+stateSize], tmp[:])
+		s = stats
+	}
+
+	escFreq := m.summFreq(mc) - m.freq(s)
+	var adder uint32
+	if m.orderFall != 0 {
+		adder = 1
+	}
+	m.setFreq(s, (m.freq(s)+4+adder)>>1)
+	sumFreq := m.freq(s)
+
+	i := m.numStats(mc)
+	for ; i > 0; i-- {
+		s += stateSize
+		escFreq -= m.freq(s)
+		m.setFreq(s, (m.freq(s)+adder)>>1)
+		sumFreq += m.freq(s)
+		if m.freq(s) > m.freq(s-stateSize) {
+			// keep the states sorted by the frequency
+			var tmp [stateSize]byte
+			copy(tmp[:], m.mem[s:])
+			s1 := s
+			for {
+				m.copyState(s1, s1-stateSize)
+				s1 -= stateSize
+				if s1 == stats || uint32(tmp[1]) <= m.freq(s1-stateSize) {
+					break
+				}
+			}
+			copy(m.mem[s1:s1+stateSize], tmp[:])
+		}
+	}
+
+	if m.freq(s) == 0 {
+		numStats := m.numStats(mc)
+		for {
+			i++
+			s -= stateSize
+			if m.freq(s) != 0 {
+				break
+			}
+		}
+		escFreq += i
+		m.mem[mc] = uint8(numStats - i)
+		if m.numStats(mc) == 0 {
+			var tmp [stateSize]byte
+			copy(tmp[:], m.mem[stats:])
+			f := (2*uint32(tmp[1]) + escFreq - 1) / escFreq
+			if f > maxFreq/3 {
+				f = maxFreq / 3
+			}
+			tmp[1] = uint8(f)
+			m.insertNode(stats, u2i((numStats+2)>>1))
+			m.mem[mc+1] = m.flags(mc)&0x10 + highFlag(tmp[0], 0x08)
+			m.foundState = oneState(mc)
+			copy(m.mem[m.foundState:m.foundState+stateSize], tmp[:])
+			return
+		}
+		n0, n1 := (numStats+2)>>1, (m.numStats(mc)+2)>>1
+		if n0 != n1 {
+			m.setStats(mc, m.shrinkUnits(stats, n0, n1))
+		}
+		flags := m.flags(mc) &^ 0x08
+		s = m.stats(mc)
+		flags |= highFlag(m.symbol(s), 0x08)
+		for i := m.numStats(mc); i > 0; i-- {
+			s += stateSize
+			flags |= highFlag(m.symbol(s), 0x08)
+		}
+		m.mem[mc+1] = flags
+	}
+	m.setSummFreq(mc, sumFreq+escFreq-escFreq>>1)
+	m.mem[mc+1] |= 0x04
+	m.foundState = m.stats(mc)
+}
+
+// makeEscFreq returns the estimator of the escape frequency of
+// minContext, and the frequency.
+func (m *model) makeEscFreq(numMasked uint32) (*see, uint32) {
+	mc := m.minContext
+	ns := m.numStats(mc)
+	if ns == 0xff {
+		return &m.dummySee, 1
+	}
+	i := uint32(m.flags(mc))
+	if m.summFreq(mc) > 11*(ns+1) {
+		i++
+	}
+	if 2*ns < m.numStats(m.suffix(mc))+numMasked {
+		i += 2
+	}
+	e := &m.see[ns2Indx[ns+2]-3][i]
+	r := uint32(e.summ >> e.shift)
+	e.summ -= uint16(r)
+	if r == 0 {
+		r = 1
+	}
+	return e, r
+}
+
+// binProb returns the probability of the state of the binary context.
+func (m *model) binProb() *uint16 {
+	mc := m.minContext
+	i := uint32(ns2BSIndx[m.numStats(m.suffix(mc))]) + uint32(m.prevSuccess) +
+		uint32(m.flags(mc)) + uint32((m.runLength>>26)&0x20)
+	return &m.binSumm[ns2Indx[m.freq(oneState(mc))-1]][i]
+}
+
+func (m *model) nextContext() {
+	c := m.successor(m.foundState)
+	if m.orderFall == 0 && c >= m.unitsStart {
+		m.minContext, m.maxContext = c, c
+	} else {
+		m.updateModel()
+		m.minContext = m.maxContext
+	}
+}
+
+// update1 updates the model after the state, not the first one,
+// of minContext is coded.
+func (m *model) update1() {
+	s := m.foundState
+	m.setFreq(s, m.freq(s)+4)
+	m.setSummFreq(m.minContext, m.summFreq(m.minContext)+4)
+	if m.freq(s) > m.freq(s-stateSize) {
+		m.swapStates(s, s-stateSize)
+		s -= stateSize
+		m.foundState = s
+		if m.freq(s) > maxFreq {
+			m.rescale()
+		}
+	}
+	m.nextContext()
+}
+
+// update1First updates the model after the first state of minContext
+// is coded.
+func (m *model) update1First() {
+	s := m.foundState
+	m.prevSuccess = 0
+	if 2*m.freq(s) >= m.summFreq(m.minContext) {
+		m.prevSuccess = 1
+	}
+	m.runLength += int32(m.prevSuccess)
+	m.setSummFreq(m.minContext, m.summFreq(m.minContext)+4)
+	m.setFreq(s, m.freq(s)+4)
+	if m.freq(s) > maxFreq {
+		m.rescale()
+	}
+	m.nextContext()
+}
+
+// updateBin updates the model after the state of the binary context
+// is coded.
+func (m *model) updateBin() {
+	s := m.foundState
+	if m.freq(s) < 196 {
+		m.setFreq(s, m.freq(s)+1)
+	}
+	m.prevSuccess = 1
+	m.runLength++
+	m.nextContext()
+}
+
+// update2 updates the model after the state is coded following
+// the escapes.
+func (m *model) update2() {
+	s := m.foundState
+	m.setSummFreq(m.minContext, m.summFreq(m.minContext)+4)
+	m.setFreq(s, m.freq(s)+4)
+	if m.freq(s) > maxFreq {
+		m.rescale()
+	}
+	m.runLength = m.initRL
+	m.updateModel()
+	m.minContext = m.maxContext
+}
+
+func updateProb0(p uint16) uint16 {
+	return p + 1<<intBits - (p+1<<(periodBits-2))>>periodBits
+}
+
+func updateProb1(p uint16) uint16 {
+	return p - (p+1<<(periodBits-2))>>periodBits
+}
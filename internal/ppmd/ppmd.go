@@ -0,0 +1,83 @@
+// Package ppmd implements reading and writing of the PPMd variant I
+// (revision 1) compressed data in the framing of zip archives (method 98).
+//
+// The data starts with a 2-byte header of the model order, the memory
+// size and the restoration method. The end marker of the data is optional,
+// so readers should be limited to the uncompressed size.
+package ppmd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = 5
+)
+
+const (
+	MinOrder   = 2
+	MaxOrder   = 16
+	MaxMemSize = 256 // MiB
+
+	RestoreRestart = 0 // the model restarts when the memory is full
+	RestoreCutOff  = 1 // the model is cut off when the memory is full
+	restoreFreeze  = 2 // the model is frozen, which is not supported
+)
+
+var (
+	errCorrupt = errors.New("ppmd: corrupt input")
+	errFormat  = errors.New("ppmd: unsupported format")
+)
+
+// Props holds the parameters of PPMd data.
+type Props struct {
+	Order   int // the model order from MinOrder to MaxOrder
+	MemSize int // the memory size in MiB from 1 to MaxMemSize
+	Restore int // the restoration method
+}
+
+// levels are the parameters of the compression levels.
+var levels = [BestCompression + 1]Props{
+	1: {Order: 4, MemSize: 1, Restore: RestoreRestart},
+	2: {Order: 5, MemSize: 2, Restore: RestoreRestart},
+	3: {Order: 6, MemSize: 4, Restore: RestoreRestart},
+	4: {Order: 7, MemSize: 8, Restore: RestoreRestart},
+	5: {Order: 8, MemSize: 16, Restore: RestoreRestart},
+	6: {Order: 9, MemSize: 32, Restore: RestoreRestart},
+	7: {Order: 10, MemSize: 64, Restore: RestoreCutOff},
+	8: {Order: 11, MemSize: 128, Restore: RestoreCutOff},
+	9: {Order: 12, MemSize: 128, Restore: RestoreCutOff},
+}
+
+func (p *Props) valid() bool {
+	return MinOrder <= p.Order && p.Order <= MaxOrder &&
+		1 <= p.MemSize && p.MemSize <= MaxMemSize &&
+		(p.Restore == RestoreRestart || p.Restore == RestoreCutOff)
+}
+
+// header returns the header of the parameters: the order minus 1 in the
+// bits 0-3, the memory size minus 1 in the bits 4-11 and the restoration
+// method in the bits 12-15.
+func (p *Props) header() [2]byte {
+	var b [2]byte
+	v := uint16(p.Order-1) | uint16(p.MemSize-1)<<4 | uint16(p.Restore)<<12
+	binary.LittleEndian.PutUint16(b[:], v)
+	return b
+}
+
+func (p *Props) decodeHeader(b [2]byte) error {
+	v := binary.LittleEndian.Uint16(b[:])
+	p.Order = int(v&0xf) + 1
+	p.MemSize = int(v>>4&0xff) + 1
+	p.Restore = int(v >> 12)
+	if p.Order < MinOrder || p.Restore > restoreFreeze {
+		return errCorrupt
+	}
+	if p.Restore == restoreFreeze {
+		return errFormat
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package ppmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file  string
+		props Props
+	}{
+		{"testdata/e.ppmd", Props{Order: 6, MemSize: 16, Restore: RestoreRestart}},
+		{"testdata/e-noend.ppmd", Props{Order: 3, MemSize: 1, Restore: RestoreCutOff}},
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z := NewReader(bytes.NewReader(data))
+		if z.props != test.props {
+			t.Fatalf("%s: props=%+v, want %+v", test.file, z.props, test.props)
+		}
+		// the data without the end marker is limited to its size
+		got, err := ioutil.ReadAll(io.LimitReader(z, int64(len(want))))
+		if err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: decoded data is different", test.file)
+		}
+	}
+
+	// the end marker
+	data, err := ioutil.ReadFile("testdata/e.ppmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoded data is different")
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/e.ppmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{0x05}, io.ErrUnexpectedEOF},                      // header
+		{[]byte{0xf0, 0x00, 0, 0, 0, 0}, errCorrupt},             // order 1
+		{[]byte{0xf5, 0x30, 0, 0, 0, 0}, errCorrupt},             // restoration method 3
+		{[]byte{0xf5, 0x20, 0, 0, 0, 0}, errFormat},              // freeze
+		{[]byte{0xf5, 0x00, 0xff, 0xff, 0xff, 0xff}, errCorrupt}, // range coder
+		{data[:len(data)/2], io.ErrUnexpectedEOF},                // truncated
+	}
+	for i, test := range tests {
+		if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(test.data))); err != test.err {
+			t.Fatalf("#%d: err=%v, want %v", i, err, test.err)
+		}
+	}
+
+	// corrupted
+	rng := rand.New(rand.NewSource(1))
+	z := NewReader(nil)
+	for i := 0; i < 300; i++ {
+		b := append([]byte(nil), data...)
+		b[2+rng.Intn(len(b)-2)] ^= byte(1 << uint(rng.Intn(8)))
+		z.Reset(bytes.NewReader(b))
+		ioutil.ReadAll(io.LimitReader(z, 1<<16))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// larger than the memory of the small models
+	var large []byte
+	for len(large) < 1<<20 {
+		large = append(large, text...)
+		large = append(large, random[:len(text)/2]...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("hello")},
+		{"zeros", make([]byte, 300000)},
+		{"text", text},
+		{"random", random},
+		{"large", large},
+	}
+	props := []Props{
+		levels[BestSpeed],
+		levels[DefaultCompression],
+		{Order: MinOrder, MemSize: 1, Restore: RestoreCutOff},
+		{Order: MaxOrder, MemSize: 1, Restore: RestoreRestart},
+		{Order: MaxOrder, MemSize: 1, Restore: RestoreCutOff},
+	}
+	for _, test := range tests {
+		for _, p := range props {
+			if testing.Short() && test.name == "large" && (p.MemSize > 1 || p.Order == MaxOrder) {
+				continue
+			}
+			buf := new(bytes.Buffer)
+			w, err := NewWriterProps(buf, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p := test.data; len(p) > 0; {
+				n := 10000
+				if n > len(p) {
+					n = len(p)
+				}
+				if _, err := w.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				p = p[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(test.data) > 1000 && test.name != "random" && buf.Len() >= len(test.data)/2 {
+				t.Errorf("%s, %+v: compressed size %d of %d", test.name, p, buf.Len(), len(test.data))
+			}
+
+			got, err := ioutil.ReadAll(NewReader(buf))
+			if err != nil {
+				t.Fatalf("%s, %+v: %v", test.name, p, err)
+			}
+			if !bytes.Equal(got, test.data) {
+				t.Fatalf("%s, %+v: decoded data is different", test.name, p)
+			}
+		}
+	}
+
+	if _, err := NewWriterLevel(ioutil.Discard, BestCompression+1); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if _, err := NewWriterProps(ioutil.Discard, Props{Order: MinOrder - 1, MemSize: 1}); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w := NewWriter(nil)
+	for _, s := range []string{"first data, first data", "second data, second data"} {
+		buf := new(bytes.Buffer)
+		w.Reset(buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// forwardBitReader reads bits from the least significant bit of
+// the first byte on, as used by the FSE table descriptions.
+type forwardBitReader struct {
+	in  []byte
+	pos int // in bits
+}
+
+// peek returns the next n bits, without consuming them.
+// The bits beyond the input are zeros.
+func (r *forwardBitReader) peek(n uint) uint32 {
+	var v uint64
+	i := r.pos >> 3
+	for k := 0; k < 5 && i+k < len(r.in); k++ {
+		v |= uint64(r.in[i+k]) << (8 * uint(k))
+	}
+	return uint32(v>>(uint(r.pos)&7)) & (1<<n - 1)
+}
+
+func (r *forwardBitReader) skip(n uint) {
+	r.pos += int(n)
+}
+
+// overflow reports whether more bits are consumed than the input holds.
+func (r *forwardBitReader) overflow() bool {
+	return r.pos > len(r.in)*8
+}
+
+// bytesRead returns the number of bytes touched by the consumed bits.
+func (r *forwardBitReader) bytesRead() int {
+	return (r.pos + 7) >> 3
+}
+
+// backwardBitReader reads a bitstream from its end to its beginning.
+// The last byte holds a padding of zeros followed by a set bit.
+type backwardBitReader struct {
+	in  []byte
+	pos int // bits before pos are not read yet; negative after overflow
+}
+
+func (r *backwardBitReader) init(in []byte) error {
+	if len(in) == 0 || in[len(in)-1] == 0 {
+		return errCorrupt
+	}
+	r.in = in
+	r.pos = len(in)*8 - 8 + bits.Len8(in[len(in)-1]) - 1
+	return nil
+}
+
+// read returns the next n bits, n <= 56. Bits read beyond the
+// beginning of the stream are zeros.
+func (r *backwardBitReader) read(n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	r.pos -= int(n)
+	pos := r.pos
+	if pos < 0 {
+		if pos <= -int(n) {
+			return 0
+		}
+		return r.load(0) << uint(-pos) & (1<<n - 1)
+	}
+	return r.load(pos>>3) >> (uint(pos) & 7) & (1<<n - 1)
+}
+
+// load returns the 8 bytes starting at i, padded with zeros.
+func (r *backwardBitReader) load(i int) uint64 {
+	if i+8 <= len(r.in) {
+		return binary.LittleEndian.Uint64(r.in[i:])
+	}
+	var v uint64
+	for k := 0; i+k < len(r.in); k++ {
+		v |= uint64(r.in[i+k]) << (8 * uint(k))
+	}
+	return v
+}
+
+// bitWriter writes a bitstream, which is read backward by
+// backwardBitReader, or forward by forwardBitReader.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	nbits uint
+}
+
+// add writes the n low bits of v, n <= 32.
+func (w *bitWriter) add(v uint64, n uint) {
+	w.acc |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// flush writes the pending bits, padding the last byte with zeros.
+func (w *bitWriter) flush() []byte {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc = 0
+		w.nbits = 0
+	}
+	return w.out
+}
+
+// close terminates a backward bitstream with its marker bit.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	return w.flush()
+}
+
+func highBit(v uint32) uint {
+	return uint(bits.Len32(v)) - 1
+}
@@ -0,0 +1,578 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// A Reader decompresses the Zstandard frames read from an underlying
+// reader. Skippable frames are ignored.
+type Reader struct {
+	r   io.Reader
+	err error
+
+	// frame
+	inFrame  bool
+	last     bool // the last block is decoded
+	window   int
+	checksum bool
+	hash     xxhash64
+
+	// hist holds the decoded data; hist[read:] is not returned yet,
+	// and the window precedes it.
+	hist []byte
+	read int
+
+	block   []byte
+	literal []byte
+	reps    [3]uint32
+	huff    *huffTable
+	tables  [3]*fseTable // literals lengths, offsets, match lengths
+	seqs    []sequence
+}
+
+// sequence is a decoded sequence. Its offset is resolved.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32
+}
+
+// NewReader returns a new Reader decompressing r.
+func NewReader(r io.Reader) *Reader {
+	z := new(Reader)
+	z.Reset(r)
+	return z
+}
+
+// Reset discards the state of z, and makes it read from r.
+func (z *Reader) Reset(r io.Reader) {
+	*z = Reader{
+		r:       r,
+		hist:    z.hist[:0],
+		block:   z.block[:0],
+		literal: z.literal[:0],
+		seqs:    z.seqs[:0],
+	}
+}
+
+// Read reads the decompressed data into p.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.read == len(z.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.hist[z.read:])
+	z.read += n
+	return n, nil
+}
+
+// Close releases the decoded data. It does not close the underlying reader.
+func (z *Reader) Close() error {
+	z.hist = nil
+	z.block = nil
+	z.literal = nil
+	z.seqs = nil
+	if z.err == nil || z.err == io.EOF {
+		z.err = errors.New("zstd: reader is closed")
+	}
+	return nil
+}
+
+// next decodes the next block, starting a new frame if needed.
+func (z *Reader) next() error {
+	if !z.inFrame {
+		ok, err := z.readFrameHeader()
+		if err != nil || !ok {
+			return err
+		}
+	}
+	if z.last {
+		if z.checksum {
+			var b [4]byte
+			if _, err := io.ReadFull(z.r, b[:]); err != nil {
+				return noEOF(err)
+			}
+			if binary.LittleEndian.Uint32(b[:]) != uint32(z.hash.sum64()) {
+				return errChecksum
+			}
+		}
+		z.inFrame = false
+		return nil
+	}
+	z.slide()
+	start := len(z.hist)
+	if err := z.readBlock(); err != nil {
+		return err
+	}
+	if z.checksum {
+		z.hash.Write(z.hist[start:])
+	}
+	return nil
+}
+
+// slide drops the data, which is returned and out of the window.
+func (z *Reader) slide() {
+	keep := z.window
+	if z.read < keep {
+		return
+	}
+	if drop := z.read - keep; drop >= keep && drop >= maxBlockSize {
+		n := copy(z.hist, z.hist[drop:])
+		z.hist = z.hist[:n]
+		z.read -= drop
+	}
+}
+
+// readFrameHeader reads the next frame header. It returns false at the
+// end of the input.
+func (z *Reader) readFrameHeader() (bool, error) {
+	var b [14]byte
+	for {
+		if _, err := io.ReadFull(z.r, b[:4]); err != nil {
+			if err == io.EOF {
+				return false, io.EOF
+			}
+			return false, noEOF(err)
+		}
+		magic := binary.LittleEndian.Uint32(b[:])
+		if magic&skippableMagicMask == skippableMagic {
+			if _, err := io.ReadFull(z.r, b[:4]); err != nil {
+				return false, noEOF(err)
+			}
+			size := int64(binary.LittleEndian.Uint32(b[:]))
+			if n, err := io.CopyN(io.Discard, z.r, size); n != size {
+				return false, noEOF(err)
+			}
+			continue
+		}
+		if magic != frameMagic {
+			return false, errors.New("zstd: invalid magic number")
+		}
+		break
+	}
+
+	if _, err := io.ReadFull(z.r, b[:1]); err != nil {
+		return false, noEOF(err)
+	}
+	desc := b[0]
+	fcsFlag := desc >> 6
+	single := desc&0x20 != 0
+	if desc&0x08 != 0 {
+		return false, errors.New("zstd: reserved bit is set")
+	}
+	z.checksum = desc&0x04 != 0
+	dictSize := [4]int{0, 1, 2, 4}[desc&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if single && fcsFlag == 0 {
+		fcsSize = 1
+	}
+	n := dictSize + fcsSize
+	if !single {
+		n++
+	}
+	if _, err := io.ReadFull(z.r, b[:n]); err != nil {
+		return false, noEOF(err)
+	}
+	h := b[:n]
+
+	window := uint64(0)
+	if !single {
+		exp := uint(h[0] >> 3)
+		log := minWindowLog + exp
+		if log > maxWindowLog {
+			return false, errors.New("zstd: window too large")
+		}
+		base := uint64(1) << log
+		window = base + base/8*uint64(h[0]&7)
+		h = h[1:]
+	}
+	dict := uint32(0)
+	for i := 0; i < dictSize; i++ {
+		dict |= uint32(h[i]) << (8 * uint(i))
+	}
+	if dict != 0 {
+		return false, errors.New("zstd: dictionaries are not supported")
+	}
+	h = h[dictSize:]
+	if single {
+		var size uint64
+		for i := 0; i < fcsSize; i++ {
+			size |= uint64(h[i]) << (8 * uint(i))
+		}
+		if fcsSize == 2 {
+			size += 256
+		}
+		window = size
+	}
+	if window > 1<<maxWindowLog {
+		return false, errors.New("zstd: window too large")
+	}
+
+	z.inFrame = true
+	z.last = false
+	z.window = int(window)
+	if z.window < minMatch {
+		z.window = minMatch
+	}
+	z.hash.reset()
+	z.reps = [3]uint32{1, 4, 8}
+	z.huff = nil
+	z.tables = [3]*fseTable{}
+	// the data of the previous frame is not referenced
+	z.hist = z.hist[:copy(z.hist, z.hist[z.read:])]
+	z.read = 0
+	return true, nil
+}
+
+// readBlock decodes the next block, and appends it to z.hist.
+func (z *Reader) readBlock() error {
+	var b [3]byte
+	if _, err := io.ReadFull(z.r, b[:]); err != nil {
+		return noEOF(err)
+	}
+	header := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+	z.last = header&1 != 0
+	size := int(header >> 3)
+	limit := maxBlockSize
+	if z.window < limit {
+		limit = z.window
+	}
+
+	switch (header >> 1) & 3 {
+	case blockRaw:
+		if size > limit {
+			return errCorrupt
+		}
+		n := len(z.hist)
+		z.hist = grow(z.hist, size)
+		if _, err := io.ReadFull(z.r, z.hist[n:]); err != nil {
+			return noEOF(err)
+		}
+	case blockRLE:
+		if size > limit {
+			return errCorrupt
+		}
+		if _, err := io.ReadFull(z.r, b[:1]); err != nil {
+			return noEOF(err)
+		}
+		n := len(z.hist)
+		z.hist = grow(z.hist, size)
+		for i := n; i < len(z.hist); i++ {
+			z.hist[i] = b[0]
+		}
+	case blockCompressed:
+		if size > limit {
+			return errCorrupt
+		}
+		z.block = grow(z.block[:0], size)
+		if _, err := io.ReadFull(z.r, z.block); err != nil {
+			return noEOF(err)
+		}
+		if err := z.decodeBlock(z.block, limit); err != nil {
+			return err
+		}
+	default:
+		return errors.New("zstd: reserved block type")
+	}
+	return nil
+}
+
+// decodeBlock decodes the compressed block in, whose decoded
+// size must not exceed limit.
+func (z *Reader) decodeBlock(in []byte, limit int) error {
+	n, err := z.decodeLiterals(in)
+	if err != nil {
+		return err
+	}
+	in = in[n:]
+	if err := z.decodeSequences(in); err != nil {
+		return err
+	}
+	return z.execute(limit)
+}
+
+// decodeLiterals decodes the literals section into z.literal, and
+// returns its size.
+func (z *Reader) decodeLiterals(in []byte) (int, error) {
+	if len(in) == 0 {
+		return 0, errCorrupt
+	}
+	typ := in[0] & 3
+	format := (in[0] >> 2) & 3
+
+	if typ == literalsRaw || typ == literalsRLE {
+		var size, n int
+		switch format {
+		case 0, 2:
+			size, n = int(in[0]>>3), 1
+		case 1:
+			if len(in) < 2 {
+				return 0, errCorrupt
+			}
+			size, n = int(in[0]>>4)|int(in[1])<<4, 2
+		case 3:
+			if len(in) < 3 {
+				return 0, errCorrupt
+			}
+			size, n = int(in[0]>>4)|int(in[1])<<4|int(in[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return 0, errCorrupt
+		}
+		if typ == literalsRaw {
+			if len(in) < n+size {
+				return 0, errCorrupt
+			}
+			z.literal = append(z.literal[:0], in[n:n+size]...)
+			return n + size, nil
+		}
+		if len(in) < n+1 {
+			return 0, errCorrupt
+		}
+		z.literal = grow(z.literal[:0], size)
+		for i := range z.literal {
+			z.literal[i] = in[n]
+		}
+		return n + 1, nil
+	}
+
+	var regen, comp, n int
+	streams := 4
+	switch format {
+	case 0, 1:
+		if len(in) < 3 {
+			return 0, errCorrupt
+		}
+		h := uint32(in[0]) | uint32(in[1])<<8 | uint32(in[2])<<16
+		regen, comp, n = int(h>>4&0x3FF), int(h>>14&0x3FF), 3
+		if format == 0 {
+			streams = 1
+		}
+	case 2:
+		if len(in) < 4 {
+			return 0, errCorrupt
+		}
+		h := binary.LittleEndian.Uint32(in)
+		regen, comp, n = int(h>>4&0x3FFF), int(h>>18), 4
+	case 3:
+		if len(in) < 5 {
+			return 0, errCorrupt
+		}
+		h := uint64(binary.LittleEndian.Uint32(in)) | uint64(in[4])<<32
+		regen, comp, n = int(h>>4&0x3FFFF), int(h>>22&0x3FFFF), 5
+	}
+	if regen > maxBlockSize || len(in) < n+comp {
+		return 0, errCorrupt
+	}
+	data := in[n : n+comp]
+	if typ == literalsCompressed {
+		t, m, err := readHuffTable(data)
+		if err != nil {
+			return 0, err
+		}
+		z.huff = t
+		data = data[m:]
+	} else if z.huff == nil {
+		return 0, errCorrupt
+	}
+	z.literal = grow(z.literal[:0], regen)
+	if err := z.huff.decode(z.literal, data, streams); err != nil {
+		return 0, err
+	}
+	return n + comp, nil
+}
+
+// decodeSequences decodes the sequences section into z.seqs.
+func (z *Reader) decodeSequences(in []byte) error {
+	z.seqs = z.seqs[:0]
+	if len(in) == 0 {
+		return errCorrupt
+	}
+	count := int(in[0])
+	switch {
+	case count == 0:
+		if len(in) != 1 {
+			return errCorrupt
+		}
+		return nil
+	case count < 128:
+		in = in[1:]
+	case count < 255:
+		if len(in) < 2 {
+			return errCorrupt
+		}
+		count = (count-128)<<8 | int(in[1])
+		in = in[2:]
+	default:
+		if len(in) < 3 {
+			return errCorrupt
+		}
+		count = int(in[1]) | int(in[2])<<8 + 0x7F00
+		in = in[3:]
+	}
+
+	if len(in) == 0 {
+		return errCorrupt
+	}
+	modes := in[0]
+	if modes&3 != 0 {
+		return errors.New("zstd: reserved bits are set")
+	}
+	in = in[1:]
+	kinds := [3]struct {
+		mode     uint8
+		def      *fseTable
+		max      int
+		maxLog   uint
+		previous *fseTable
+	}{
+		{modes >> 6, litLenDefaultTable, maxLitLenCode, maxLitLenLog, z.tables[0]},
+		{modes >> 4 & 3, offsetDefaultTable, maxOffsetCode, maxOffsetLog, z.tables[1]},
+		{modes >> 2 & 3, matchDefaultTable, maxMatchCode, maxMatchLog, z.tables[2]},
+	}
+	for i, k := range kinds {
+		switch k.mode {
+		case modePredefined:
+			z.tables[i] = k.def
+		case modeRLE:
+			if len(in) == 0 || int(in[0]) > k.max {
+				return errCorrupt
+			}
+			z.tables[i] = rleFSETable(in[0])
+			in = in[1:]
+		case modeFSE:
+			norm, log, n, err := readFSETable(in, k.max, k.maxLog)
+			if err != nil {
+				return err
+			}
+			if z.tables[i], err = newFSETable(norm, log); err != nil {
+				return err
+			}
+			in = in[n:]
+		case modeRepeat:
+			if k.previous == nil {
+				return errCorrupt
+			}
+		}
+	}
+
+	var br backwardBitReader
+	if err := br.init(in); err != nil {
+		return err
+	}
+	var ll, of, ml fseState
+	ll.init(z.tables[0], &br)
+	of.init(z.tables[1], &br)
+	ml.init(z.tables[2], &br)
+	for i := 0; i < count; i++ {
+		ofCode := of.symbol()
+		mlCode := ml.symbol()
+		llCode := ll.symbol()
+		if ofCode > maxOffsetCode || mlCode > maxMatchCode || llCode > maxLitLenCode {
+			return errCorrupt
+		}
+		offset := uint32(1)<<ofCode + uint32(br.read(uint(ofCode)))
+		s := sequence{
+			matchLen: matchBase[mlCode] + uint32(br.read(uint(matchBits[mlCode]))),
+			litLen:   litLenBase[llCode] + uint32(br.read(uint(litLenBits[llCode]))),
+		}
+
+		if offset > 3 {
+			offset -= 3
+			z.reps = [3]uint32{offset, z.reps[0], z.reps[1]}
+		} else {
+			idx := offset - 1
+			if s.litLen == 0 {
+				idx++
+			}
+			switch idx {
+			case 0:
+				offset = z.reps[0]
+			case 1:
+				offset = z.reps[1]
+				z.reps = [3]uint32{offset, z.reps[0], z.reps[2]}
+			case 2:
+				offset = z.reps[2]
+				z.reps = [3]uint32{offset, z.reps[0], z.reps[1]}
+			case 3:
+				offset = z.reps[0] - 1
+				z.reps = [3]uint32{offset, z.reps[0], z.reps[1]}
+			}
+			if offset == 0 {
+				return errCorrupt
+			}
+		}
+		s.offset = offset
+		z.seqs = append(z.seqs, s)
+
+		if i < count-1 {
+			ll.update(&br)
+			ml.update(&br)
+			of.update(&br)
+		}
+		if br.pos < 0 {
+			return errCorrupt
+		}
+	}
+	if br.pos != 0 {
+		return errCorrupt
+	}
+	return nil
+}
+
+// execute appends the literals and the matches of the sequences to z.hist.
+func (z *Reader) execute(limit int) error {
+	lit := z.literal
+	limit -= len(lit)
+	for _, s := range z.seqs {
+		if int(s.litLen) > len(lit) {
+			return errCorrupt
+		}
+		if limit -= int(s.matchLen); limit < 0 {
+			return errCorrupt
+		}
+		z.hist = append(z.hist, lit[:s.litLen]...)
+		lit = lit[s.litLen:]
+
+		off := int(s.offset)
+		if off > len(z.hist) || off > z.window {
+			return errCorrupt
+		}
+		n := int(s.matchLen)
+		if len(z.hist)+n > cap(z.hist) {
+			z.hist = grow(z.hist, n)[:len(z.hist)]
+		}
+		start := len(z.hist) - off
+		if off >= n {
+			z.hist = append(z.hist, z.hist[start:start+n]...)
+			continue
+		}
+		// overlapping copy
+		for i := 0; i < n; i++ {
+			z.hist = append(z.hist, z.hist[start+i])
+		}
+	}
+	z.hist = append(z.hist, lit...)
+	return nil
+}
+
+// grow extends b by n bytes.
+func grow(b []byte, n int) []byte {
+	if len(b)+n <= cap(b) {
+		return b[:len(b)+n]
+	}
+	nb := make([]byte, len(b)+n, 2*cap(b)+n)
+	copy(nb, b)
+	return nb
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,683 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Compression levels.
+const (
+	BestSpeed          = 1
+	DefaultCompression = 3
+	BestCompression    = 22
+)
+
+// params are the parameters of a compression level.
+type params struct {
+	windowLog uint
+	hashLog   uint
+	depth     int // the number of searched positions
+	lazy      int // the number of positions tried before a match is taken
+	nice      int // a match at least this long is taken at once
+}
+
+var levels = [BestCompression + 1]params{
+	1:  {19, 15, 1, 0, 16},
+	2:  {19, 16, 2, 0, 24},
+	3:  {20, 17, 4, 1, 32},
+	4:  {20, 17, 8, 1, 32},
+	5:  {21, 17, 8, 1, 48},
+	6:  {21, 18, 16, 1, 64},
+	7:  {21, 18, 24, 2, 64},
+	8:  {21, 18, 32, 2, 96},
+	9:  {22, 18, 48, 2, 128},
+	10: {22, 19, 64, 2, 128},
+	11: {22, 19, 96, 2, 192},
+	12: {22, 19, 128, 2, 256},
+	13: {22, 20, 192, 2, 256},
+	14: {22, 20, 256, 2, 384},
+	15: {22, 20, 384, 2, 512},
+	16: {23, 20, 512, 2, 512},
+	17: {23, 20, 768, 2, 768},
+	18: {23, 20, 1024, 2, 1024},
+	19: {23, 21, 1536, 2, 2048},
+	20: {23, 21, 2048, 2, 4096},
+	21: {23, 21, 3072, 2, 8192},
+	22: {23, 21, 4096, 2, 1 << 16},
+}
+
+const (
+	hashLen      = 4 // the length of the hashed prefix of the matches
+	minLiterals  = 32
+	minFSESeqs   = 16
+	offsetBonus  = 1
+	hashMultiply = 2654435761
+)
+
+// A Writer compresses the data written to it into a Zstandard frame.
+type Writer struct {
+	w      io.Writer
+	p      params
+	err    error
+	closed bool
+
+	started bool // the frame header is written
+	hash    xxhash64
+	reps    [3]uint32
+
+	// hist holds the window and the data not compressed yet, from start
+	hist   []byte
+	start  int
+	head   []int32 // positions+1 by hash
+	chain  []int32 // previous positions+1 with the same hash, by position
+	insert int     // the positions before insert are in the hash chains
+
+	seqs    []encSequence
+	lits    []byte
+	out     []byte
+	codes   [3][]uint8
+	counts  [3][]int
+	litHist [256]int
+	huff    []byte
+}
+
+// encSequence is a sequence, with its offset as encoded.
+type encSequence struct {
+	litLen   uint32
+	matchLen uint32
+	offBase  uint32 // 1..3 for repeated offsets, offset+3 otherwise
+}
+
+// NewWriter returns a new Writer compressing data with the default
+// compression level.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel returns a new Writer compressing data with the level,
+// from BestSpeed to BestCompression.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < BestSpeed || level > BestCompression {
+		return nil, fmt.Errorf("zstd: invalid compression level: %d", level)
+	}
+	z := &Writer{p: levels[level]}
+	z.Reset(w)
+	return z, nil
+}
+
+// Reset discards the state of z, and makes it write to w with
+// the same compression level.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.err = nil
+	z.closed = false
+	z.started = false
+	z.hash.reset()
+	z.reps = [3]uint32{1, 4, 8}
+	z.hist = z.hist[:0]
+	z.chain = z.chain[:0]
+	z.start = 0
+	z.insert = 0
+	if len(z.head) != 1<<z.p.hashLog {
+		z.head = make([]int32, 1<<z.p.hashLog)
+	} else {
+		for i := range z.head {
+			z.head[i] = 0
+		}
+	}
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("zstd: write after close")
+	}
+	z.hash.Write(p)
+	n := len(p)
+	for len(p) > 0 {
+		m := maxBlockSize - (len(z.hist) - z.start)
+		if m > len(p) {
+			m = len(p)
+		}
+		z.hist = append(z.hist, p[:m]...)
+		p = p[m:]
+		if len(z.hist)-z.start == maxBlockSize && len(p) > 0 {
+			if z.err = z.writeBlock(false); z.err != nil {
+				return 0, z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the remaining data and the end of the frame.
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if z.err = z.writeBlock(true); z.err != nil {
+		return z.err
+	}
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(z.hash.sum64()))
+	_, z.err = z.w.Write(b[:])
+	return z.err
+}
+
+// writeBlock compresses the data not compressed yet into a block.
+func (z *Writer) writeBlock(last bool) error {
+	out := z.out[:0]
+	if !z.started {
+		z.started = true
+		out = binary.LittleEndian.AppendUint32(out, frameMagic)
+		out = append(out, 0x04, byte(z.p.windowLog-minWindowLog)<<3) // checksum, window
+	}
+
+	src := z.hist[z.start:]
+	header := len(out)
+	out = append(out, 0, 0, 0)
+	typ := blockCompressed
+	reps := z.reps
+	switch {
+	case len(src) == 0:
+		typ = blockRaw
+	case isRLE(src):
+		typ = blockRLE
+		out = append(out, src[0])
+	default:
+		z.parse()
+		out = z.encodeLiterals(out)
+		out = z.encodeSequences(out)
+		if len(out)-header-3 >= len(src) {
+			typ = blockRaw
+			out = append(out[:header+3], src...)
+			z.reps = reps
+		}
+	}
+	size := len(src)
+	if typ == blockCompressed {
+		size = len(out) - header - 3
+	}
+	h := uint32(size)<<3 | uint32(typ)<<1
+	if last {
+		h |= 1
+	}
+	out[header] = byte(h)
+	out[header+1] = byte(h >> 8)
+	out[header+2] = byte(h >> 16)
+	z.out = out
+
+	z.start = len(z.hist)
+	z.slide()
+	_, err := z.w.Write(out)
+	return err
+}
+
+func isRLE(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return len(b) > 1
+}
+
+// slide drops the data out of the window, when it is large enough.
+func (z *Writer) slide() {
+	window := 1 << z.p.windowLog
+	drop := z.start - window
+	if drop < window {
+		return
+	}
+	n := copy(z.hist, z.hist[drop:])
+	z.hist = z.hist[:n]
+	z.start -= drop
+	// The chains do not cover the blocks which were not parsed,
+	// such as RLE blocks.
+	if drop < len(z.chain) {
+		copy(z.chain, z.chain[drop:])
+		z.chain = z.chain[:len(z.chain)-drop]
+	} else {
+		z.chain = z.chain[:0]
+	}
+	z.insert -= drop
+	if z.insert < 0 {
+		z.insert = 0
+	}
+	d := int32(drop)
+	for i, v := range z.chain {
+		if v > d {
+			z.chain[i] = v - d
+		} else {
+			z.chain[i] = 0
+		}
+	}
+	for i, v := range z.head {
+		if v > d {
+			z.head[i] = v - d
+		} else {
+			z.head[i] = 0
+		}
+	}
+}
+
+func (z *Writer) hashAt(i int) uint32 {
+	return binary.LittleEndian.Uint32(z.hist[i:]) * hashMultiply >> (32 - z.p.hashLog)
+}
+
+// insertTo adds the positions before end to the hash chains.
+func (z *Writer) insertTo(end int) {
+	if limit := len(z.hist) - hashLen + 1; end > limit {
+		end = limit
+	}
+	for len(z.chain) < end {
+		z.chain = append(z.chain, 0)
+	}
+	for i := z.insert; i < end; i++ {
+		h := z.hashAt(i)
+		z.chain[i] = z.head[h]
+		z.head[h] = int32(i + 1)
+	}
+	if end > z.insert {
+		z.insert = end
+	}
+}
+
+// matchLen returns the length of the common prefix of hist[a:] and hist[b:end].
+func (z *Writer) matchLen(a, b, end int) int {
+	n := 0
+	for b+n+8 <= end {
+		x := binary.LittleEndian.Uint64(z.hist[a+n:]) ^ binary.LittleEndian.Uint64(z.hist[b+n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for b+n < end && z.hist[a+n] == z.hist[b+n] {
+		n++
+	}
+	return n
+}
+
+// findMatch returns the longest match at i, ending before end,
+// and its offset. The repeated offsets are preferred.
+func (z *Writer) findMatch(i, end int, reps [3]uint32) (int, int) {
+	z.insertTo(i)
+	window := 1 << z.p.windowLog
+	best, bestOff := 0, 0
+	for _, r := range reps {
+		off := int(r)
+		if off > i || off >= window {
+			continue
+		}
+		if n := z.matchLen(i-off, i, end); n > best {
+			best, bestOff = n, off
+		}
+	}
+	if best >= z.p.nice || i+hashLen > len(z.hist) {
+		z.insertTo(i + 1)
+		return best, bestOff
+	}
+
+	limit := i - window
+	cand := int(z.head[z.hashAt(i)]) - 1
+	for tries := z.p.depth; cand > limit && cand >= 0 && tries > 0; tries-- {
+		if best == 0 || i+best < end && z.hist[cand+best] == z.hist[i+best] {
+			// prefer a repeated offset to a slightly longer match
+			if n := z.matchLen(cand, i, end); n > best+offsetBonus || (n > best && bestOff == 0) {
+				best, bestOff = n, i-cand
+				if n >= z.p.nice {
+					break
+				}
+			}
+		}
+		cand = int(z.chain[cand]) - 1
+	}
+	z.insertTo(i + 1)
+	return best, bestOff
+}
+
+// parse splits the data not compressed yet into sequences and literals.
+func (z *Writer) parse() {
+	z.seqs = z.seqs[:0]
+	z.lits = z.lits[:0]
+	end := len(z.hist)
+	anchor := z.start
+	reps := z.reps
+	for i := anchor; i+hashLen <= end; {
+		n, off := z.findMatch(i, end, reps)
+		if n < hashLen {
+			i++
+			continue
+		}
+		for k := 0; k < z.p.lazy && i+1+hashLen <= end; k++ {
+			n1, off1 := z.findMatch(i+1, end, reps)
+			if n1 <= n {
+				break
+			}
+			i, n, off = i+1, n1, off1
+		}
+
+		z.lits = append(z.lits, z.hist[anchor:i]...)
+		reps = z.addSequence(uint32(i-anchor), uint32(off), uint32(n), reps)
+		i += n
+		anchor = i
+	}
+	z.lits = append(z.lits, z.hist[anchor:end]...)
+	z.insertTo(end)
+	z.reps = reps
+}
+
+// addSequence adds a sequence, and returns the updated repeated offsets.
+func (z *Writer) addSequence(litLen, off, matchLen uint32, reps [3]uint32) [3]uint32 {
+	var offBase uint32
+	if litLen > 0 {
+		switch off {
+		case reps[0]:
+			offBase = 1
+		case reps[1]:
+			offBase = 2
+		case reps[2]:
+			offBase = 3
+		default:
+			offBase = off + 3
+		}
+	} else {
+		switch off {
+		case reps[1]:
+			offBase = 1
+		case reps[2]:
+			offBase = 2
+		case reps[0] - 1:
+			offBase = 3
+		default:
+			offBase = off + 3
+		}
+	}
+	z.seqs = append(z.seqs, encSequence{litLen: litLen, matchLen: matchLen, offBase: offBase})
+
+	if offBase > 3 {
+		return [3]uint32{off, reps[0], reps[1]}
+	}
+	idx := offBase - 1
+	if litLen == 0 {
+		idx++
+	}
+	switch idx {
+	case 1:
+		return [3]uint32{off, reps[0], reps[2]}
+	case 2, 3:
+		return [3]uint32{off, reps[0], reps[1]}
+	}
+	return reps
+}
+
+// encodeLiterals appends the literals section to out.
+func (z *Writer) encodeLiterals(out []byte) []byte {
+	lits := z.lits
+	n := len(lits)
+	if n >= minLiterals {
+		if isRLE(lits) {
+			out = appendLiteralsHeader(out, literalsRLE, n)
+			return append(out, lits[0])
+		}
+		if c := z.compressLiterals(out, lits); c != nil {
+			return c
+		}
+	}
+	out = appendLiteralsHeader(out, literalsRaw, n)
+	return append(out, lits...)
+}
+
+// appendLiteralsHeader appends the header of raw or RLE literals.
+func appendLiteralsHeader(out []byte, typ, n int) []byte {
+	switch {
+	case n < 32:
+		return append(out, byte(typ|n<<3))
+	case n < 4096:
+		return append(out, byte(typ|1<<2|(n&15)<<4), byte(n>>4))
+	default:
+		return append(out, byte(typ|3<<2|(n&15)<<4), byte(n>>4), byte(n>>12))
+	}
+}
+
+// compressLiterals appends the Huffman compressed literals to out. It
+// returns nil if they are not smaller than the raw literals.
+func (z *Writer) compressLiterals(out, lits []byte) []byte {
+	counts := z.litHist[:]
+	for i := range counts {
+		counts[i] = 0
+	}
+	for _, c := range lits {
+		counts[c]++
+	}
+	e := newHuffEncoder(counts)
+	n := len(lits)
+	if e.estimate(counts)/8 >= n-n/32 {
+		return nil
+	}
+
+	streams := 4
+	if n < 256 {
+		streams = 1
+	}
+	body := e.writeTable(z.huff[:0])
+	if body == nil {
+		return nil
+	}
+	body = e.encode(body, lits, streams)
+	z.huff = body
+	comp := len(body)
+
+	var format uint64
+	var h uint64
+	headerLen := 0
+	switch {
+	case streams == 1 && comp <= 1023:
+		format, headerLen = 0, 3
+		h = uint64(n)<<4 | uint64(comp)<<14
+	case streams == 1:
+		return nil
+	case n <= 1023 && comp <= 1023:
+		format, headerLen = 1, 3
+		h = uint64(n)<<4 | uint64(comp)<<14
+	case n <= 16383 && comp <= 16383:
+		format, headerLen = 2, 4
+		h = uint64(n)<<4 | uint64(comp)<<18
+	case comp <= 262143:
+		format, headerLen = 3, 5
+		h = uint64(n)<<4 | uint64(comp)<<22
+	default:
+		return nil
+	}
+	if headerLen+comp >= n+3 {
+		return nil
+	}
+	h |= literalsCompressed | format<<2
+	for i := 0; i < headerLen; i++ {
+		out = append(out, byte(h>>(8*uint(i))))
+	}
+	return append(out, body...)
+}
+
+// encodeSequences appends the sequences section to out.
+func (z *Writer) encodeSequences(out []byte) []byte {
+	n := len(z.seqs)
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8+128), byte(n))
+	default:
+		out = append(out, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return out
+	}
+
+	for k := range z.codes {
+		z.codes[k] = z.codes[k][:0]
+	}
+	for _, s := range z.seqs {
+		z.codes[0] = append(z.codes[0], litLenCode(s.litLen))
+		z.codes[1] = append(z.codes[1], uint8(highBit(s.offBase)))
+		z.codes[2] = append(z.codes[2], matchCode(s.matchLen))
+	}
+
+	kinds := [3]struct {
+		max    int
+		maxLog uint
+		def    []int16
+		defLog uint
+		defEnc *fseEncTable
+	}{
+		{maxLitLenCode, maxLitLenLog, litLenDefault, litLenDefaultLog, litLenDefaultEnc},
+		{maxOffsetCode, maxOffsetLog, offsetDefault, offsetDefaultLog, offsetDefaultEnc},
+		{maxMatchCode, maxMatchLog, matchDefault, matchDefaultLog, matchDefaultEnc},
+	}
+	modesAt := len(out)
+	out = append(out, 0)
+	var tables [3]*fseEncTable
+	var modes byte
+	for k, kind := range kinds {
+		counts := z.counts[k]
+		if len(counts) != kind.max+1 {
+			counts = make([]int, kind.max+1)
+			z.counts[k] = counts
+		}
+		for i := range counts {
+			counts[i] = 0
+		}
+		maxSymbol, distinct := 0, 0
+		for _, c := range z.codes[k] {
+			if counts[c] == 0 {
+				distinct++
+			}
+			counts[c]++
+			if int(c) > maxSymbol {
+				maxSymbol = int(c)
+			}
+		}
+
+		mode := modePredefined
+		tables[k] = kind.defEnc
+		cost := fseCost(counts, kind.def, kind.defLog)
+		switch {
+		case distinct == 1:
+			mode = modeRLE
+			tables[k] = nil
+			out = append(out, byte(maxSymbol))
+		case n >= minFSESeqs || cost == maxCost:
+			log := optimalLog(n, maxSymbol, distinct, kind.maxLog)
+			norm := normalizeCounts(counts[:maxSymbol+1], n, log)
+			bw := &bitWriter{out: out}
+			writeFSETable(bw, norm, log)
+			custom := fseCost(counts, norm, log) + (len(bw.out)-len(out))*8
+			if custom < cost {
+				mode = modeFSE
+				tables[k] = newFSEEncTable(norm, log)
+				out = bw.out
+			}
+		}
+		modes |= byte(mode) << (6 - 2*uint(k))
+	}
+	out[modesAt] = modes
+
+	ll, of, ml := z.codes[0], z.codes[1], z.codes[2]
+	bw := &bitWriter{out: out}
+	var sll, sof, sml fseEncState
+	last := n - 1
+	if tables[2] != nil {
+		sml.init(tables[2], ml[last])
+	}
+	if tables[1] != nil {
+		sof.init(tables[1], of[last])
+	}
+	if tables[0] != nil {
+		sll.init(tables[0], ll[last])
+	}
+	z.addExtraBits(bw, last)
+	for i := last - 1; i >= 0; i-- {
+		if tables[1] != nil {
+			sof.encode(bw, of[i])
+		}
+		if tables[2] != nil {
+			sml.encode(bw, ml[i])
+		}
+		if tables[0] != nil {
+			sll.encode(bw, ll[i])
+		}
+		z.addExtraBits(bw, i)
+	}
+	if tables[2] != nil {
+		sml.flush(bw)
+	}
+	if tables[1] != nil {
+		sof.flush(bw)
+	}
+	if tables[0] != nil {
+		sll.flush(bw)
+	}
+	return bw.close()
+}
+
+// addExtraBits writes the extra bits of the i-th sequence.
+func (z *Writer) addExtraBits(bw *bitWriter, i int) {
+	s := z.seqs[i]
+	llCode, ofCode, mlCode := z.codes[0][i], z.codes[1][i], z.codes[2][i]
+	bw.add(uint64(s.litLen-litLenBase[llCode]), uint(litLenBits[llCode]))
+	bw.add(uint64(s.matchLen-matchBase[mlCode]), uint(matchBits[mlCode]))
+	bw.add(uint64(s.offBase), uint(ofCode))
+}
+
+func litLenCode(n uint32) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	return uint8(sort.Search(len(litLenBase), func(c int) bool { return litLenBase[c] > n }) - 1)
+}
+
+func matchCode(n uint32) uint8 {
+	if n < 35 {
+		return uint8(n - 3)
+	}
+	return uint8(sort.Search(len(matchBase), func(c int) bool { return matchBase[c] > n }) - 1)
+}
+
+// optimalLog returns the accuracy log of an FSE table for n symbols.
+func optimalLog(n, maxSymbol, distinct int, maxLog uint) uint {
+	log := int(maxLog)
+	if b := bits.Len32(uint32(n-1)) - 3; b < log {
+		log = b
+	}
+	minBits := bits.Len32(uint32(n))
+	if b := bits.Len32(uint32(maxSymbol)) + 1; b < minBits {
+		minBits = b
+	}
+	if minBits > log {
+		log = minBits
+	}
+	for 1<<uint(log) < distinct {
+		log++
+	}
+	if log < minAccuracyLog {
+		log = minAccuracyLog
+	}
+	if log > int(maxLog) {
+		log = int(maxLog)
+	}
+	return uint(log)
+}
@@ -0,0 +1,384 @@
+package zstd
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	minAccuracyLog = 5
+	maxCost        = math.MaxInt32
+)
+
+// fseEntry is a state of an FSE decoding table.
+type fseEntry struct {
+	symbol uint8
+	nbBits uint8
+	base   uint16
+}
+
+// fseTable is an FSE decoding table.
+type fseTable struct {
+	accuracyLog uint
+	entries     []fseEntry
+}
+
+// readFSETable reads an FSE table description from in, and returns
+// the normalized counts, the accuracy log, and the number of bytes read.
+func readFSETable(in []byte, maxSymbol int, maxLog uint) ([]int16, uint, int, error) {
+	r := forwardBitReader{in: in}
+	log := uint(r.peek(4)) + minAccuracyLog
+	r.skip(4)
+	if log > maxLog {
+		return nil, 0, 0, errors.New("zstd: FSE accuracy log too large")
+	}
+
+	norm := make([]int16, 0, maxSymbol+1)
+	remaining := int32(1<<log) + 1
+	threshold := int32(1 << log)
+	nbBits := log + 1
+	previous0 := false
+	for remaining > 1 {
+		if previous0 {
+			n := len(norm)
+			for r.peek(16) == 0xFFFF && !r.overflow() {
+				n += 24
+				r.skip(16)
+			}
+			for r.peek(2) == 3 {
+				n += 3
+				r.skip(2)
+			}
+			n += int(r.peek(2))
+			r.skip(2)
+			if n > maxSymbol {
+				return nil, 0, 0, errCorrupt
+			}
+			for len(norm) < n {
+				norm = append(norm, 0)
+			}
+		}
+		if len(norm) > maxSymbol {
+			return nil, 0, 0, errCorrupt
+		}
+
+		max := 2*threshold - 1 - remaining
+		var count int32
+		if v := int32(r.peek(nbBits - 1)); v < max {
+			count = v
+			r.skip(nbBits - 1)
+		} else {
+			count = int32(r.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			r.skip(nbBits)
+		}
+		count-- // 0 stands for the probability "less than 1"
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		if r.overflow() {
+			return nil, 0, 0, errCorrupt
+		}
+	}
+	if remaining != 1 || r.overflow() {
+		return nil, 0, 0, errCorrupt
+	}
+	return norm, log, r.bytesRead(), nil
+}
+
+// spreadSymbols returns the symbols of the states of an FSE table.
+func spreadSymbols(norm []int16, log uint) ([]uint8, error) {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	high := size
+	for s, n := range norm {
+		if n == -1 {
+			high--
+			symbols[high] = uint8(s)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			symbols[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos >= high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errCorrupt
+	}
+	return symbols, nil
+}
+
+// newFSETable builds the decoding table of the normalized counts.
+func newFSETable(norm []int16, log uint) (*fseTable, error) {
+	symbols, err := spreadSymbols(norm, log)
+	if err != nil {
+		return nil, err
+	}
+
+	size := 1 << log
+	next := make([]uint32, len(norm))
+	for s, n := range norm {
+		if n == -1 {
+			next[s] = 1
+		} else {
+			next[s] = uint32(n)
+		}
+	}
+	t := &fseTable{accuracyLog: log, entries: make([]fseEntry, size)}
+	for i, s := range symbols {
+		desc := next[s]
+		next[s]++
+		nb := log - highBit(desc)
+		t.entries[i] = fseEntry{
+			symbol: s,
+			nbBits: uint8(nb),
+			base:   uint16(desc<<nb) - uint16(size),
+		}
+	}
+	return t, nil
+}
+
+// rleFSETable returns a table that always decodes symbol.
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+// fseState is the state of an FSE decoder.
+type fseState struct {
+	t     *fseTable
+	state uint16
+}
+
+func (s *fseState) init(t *fseTable, br *backwardBitReader) {
+	s.t = t
+	s.state = uint16(br.read(t.accuracyLog))
+}
+
+func (s *fseState) symbol() uint8 {
+	return s.t.entries[s.state].symbol
+}
+
+func (s *fseState) update(br *backwardBitReader) {
+	e := s.t.entries[s.state]
+	s.state = e.base + uint16(br.read(uint(e.nbBits)))
+}
+
+// normalizeCounts returns counts normalized to a sum of 1<<log, keeping
+// at least one state for each used symbol.
+func normalizeCounts(counts []int, total int, log uint) []int16 {
+	size := 1 << log
+	norm := make([]int16, len(counts))
+	sum := 0
+	largest := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		n := int((uint64(c)*uint64(size) + uint64(total)/2) / uint64(total))
+		if n == 0 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+		if c > counts[largest] {
+			largest = s
+		}
+	}
+
+	// give the missing states to the most frequent symbol, or take
+	// the excess states from the symbols with the most states
+	for diff := size - sum; diff != 0; diff++ {
+		if diff > 0 {
+			norm[largest] += int16(diff)
+			break
+		}
+		best := largest
+		for s, n := range norm {
+			if n > norm[best] {
+				best = s
+			}
+		}
+		norm[best]--
+	}
+	return norm
+}
+
+// fseCost returns the estimated size in bits of the symbols counted by
+// counts, encoded with the normalized counts. It returns maxCost
+// if a counted symbol has no state.
+func fseCost(counts []int, norm []int16, log uint) int {
+	cost := 0.0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if s >= len(norm) || norm[s] == 0 {
+			return maxCost
+		}
+		n := float64(norm[s])
+		if n < 0 {
+			n = 1
+		}
+		cost += float64(c) * (float64(log) - math.Log2(n))
+	}
+	return int(cost) + 1
+}
+
+// writeFSETable writes the description of the normalized counts.
+func writeFSETable(bw *bitWriter, norm []int16, log uint) {
+	bw.add(uint64(log-minAccuracyLog), 4)
+
+	maxSymbol := len(norm) - 1
+	for maxSymbol > 0 && norm[maxSymbol] == 0 {
+		maxSymbol--
+	}
+	remaining := int32(1<<log) + 1
+	threshold := int32(1 << log)
+	nbBits := log + 1
+	previous0 := false
+	for s := 0; s <= maxSymbol && remaining > 1; {
+		if previous0 {
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				bw.add(0xFFFF, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				bw.add(3, 2)
+			}
+			bw.add(uint64(s-start), 2)
+		}
+
+		count := int32(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		n := nbBits
+		if count < max {
+			n--
+		}
+		bw.add(uint64(count), n)
+		previous0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	bw.flush()
+}
+
+// fseSymbolTransform is the encoding transform of a symbol.
+type fseSymbolTransform struct {
+	deltaFindState int32
+	deltaNbBits    uint32
+}
+
+// fseEncTable is an FSE encoding table.
+type fseEncTable struct {
+	accuracyLog uint
+	stateTable  []uint16
+	symbolTT    []fseSymbolTransform
+}
+
+// newFSEEncTable builds the encoding table of the normalized counts.
+func newFSEEncTable(norm []int16, log uint) *fseEncTable {
+	symbols, err := spreadSymbols(norm, log)
+	if err != nil {
+		panic("zstd: invalid normalized counts")
+	}
+
+	size := 1 << log
+	cumul := make([]int, len(norm)+1)
+	for s, n := range norm {
+		if n == -1 {
+			n = 1
+		}
+		cumul[s+1] = cumul[s] + int(n)
+	}
+	t := &fseEncTable{
+		accuracyLog: log,
+		stateTable:  make([]uint16, size),
+		symbolTT:    make([]fseSymbolTransform, len(norm)),
+	}
+	pos := append([]int(nil), cumul...)
+	for u, s := range symbols {
+		t.stateTable[pos[s]] = uint16(size + u)
+		pos[s]++
+	}
+
+	total := int32(0)
+	for s, n := range norm {
+		switch n {
+		case 0:
+			t.symbolTT[s].deltaNbBits = uint32(log+1)<<16 - uint32(size)
+		case -1, 1:
+			t.symbolTT[s].deltaNbBits = uint32(log)<<16 - uint32(size)
+			t.symbolTT[s].deltaFindState = total - 1
+			total++
+		default:
+			maxBitsOut := log - highBit(uint32(n-1))
+			minStatePlus := uint32(n) << maxBitsOut
+			t.symbolTT[s].deltaNbBits = uint32(maxBitsOut)<<16 - minStatePlus
+			t.symbolTT[s].deltaFindState = total - int32(n)
+			total += int32(n)
+		}
+	}
+	return t
+}
+
+// fseEncState is the state of an FSE encoder.
+type fseEncState struct {
+	t     *fseEncTable
+	value uint32
+}
+
+// init sets the state to the one after the symbol,
+// which is the last one to be encoded.
+func (s *fseEncState) init(t *fseEncTable, symbol uint8) {
+	s.t = t
+	tt := t.symbolTT[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	s.value = uint32(t.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (s *fseEncState) encode(bw *bitWriter, symbol uint8) {
+	tt := s.t.symbolTT[symbol]
+	nbBitsOut := (s.value + tt.deltaNbBits) >> 16
+	bw.add(uint64(s.value), uint(nbBitsOut))
+	s.value = uint32(s.t.stateTable[int32(s.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (s *fseEncState) flush(bw *bitWriter) {
+	bw.add(uint64(s.value), s.t.accuracyLog)
+}
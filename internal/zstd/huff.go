@@ -0,0 +1,462 @@
+package zstd
+
+import (
+	"errors"
+	"sort"
+)
+
+const (
+	maxHuffBits    = 11
+	maxHuffSymbols = 256
+)
+
+// huffTable is a Huffman decoding table, indexed by
+// the next maxBits bits of the stream.
+type huffTable struct {
+	maxBits uint
+	symbols []uint8
+	nbBits  []uint8
+}
+
+// readHuffTable reads a Huffman tree description from in, and returns
+// the decoding table and the number of bytes read.
+func readHuffTable(in []byte) (*huffTable, int, error) {
+	if len(in) == 0 {
+		return nil, 0, errCorrupt
+	}
+	var weights []uint8
+	n := 1
+	if header := int(in[0]); header >= 128 {
+		// direct representation, 4 bits per weight
+		count := header - 127
+		n += (count + 1) / 2
+		if len(in) < n {
+			return nil, 0, errCorrupt
+		}
+		weights = make([]uint8, count)
+		for i := range weights {
+			b := in[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+	} else {
+		// FSE compressed weights
+		n += header
+		if len(in) < n {
+			return nil, 0, errCorrupt
+		}
+		var err error
+		if weights, err = readHuffWeights(in[1:n]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	t, err := newHuffTable(weights)
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, n, nil
+}
+
+// readHuffWeights decodes the weights, compressed with two
+// interleaved FSE states.
+func readHuffWeights(in []byte) ([]uint8, error) {
+	norm, log, n, err := readFSETable(in, maxHuffBits+1, 6)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var br backwardBitReader
+	if err := br.init(in[n:]); err != nil {
+		return nil, err
+	}
+	var s1, s2 fseState
+	s1.init(t, &br)
+	s2.init(t, &br)
+	weights := make([]uint8, 0, maxHuffSymbols)
+	for {
+		if len(weights) >= maxHuffSymbols-2 {
+			return nil, errCorrupt
+		}
+		weights = append(weights, s1.symbol())
+		s1.update(&br)
+		if br.pos < 0 {
+			weights = append(weights, s2.symbol())
+			break
+		}
+		weights = append(weights, s2.symbol())
+		s2.update(&br)
+		if br.pos < 0 {
+			weights = append(weights, s1.symbol())
+			break
+		}
+	}
+	return weights, nil
+}
+
+// newHuffTable builds the decoding table of the weights,
+// completing the weight of the last symbol.
+func newHuffTable(weights []uint8) (*huffTable, error) {
+	if len(weights) >= maxHuffSymbols {
+		return nil, errCorrupt
+	}
+	total := uint32(0)
+	for _, w := range weights {
+		if w > maxHuffBits {
+			return nil, errCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, errCorrupt
+	}
+	maxBits := highBit(total) + 1
+	if maxBits > maxHuffBits {
+		return nil, errors.New("zstd: Huffman table too deep")
+	}
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return nil, errCorrupt
+	}
+	weights = append(weights[:len(weights):len(weights)], uint8(highBit(rest)+1))
+
+	var rankCount [maxHuffBits + 1]uint32
+	for _, w := range weights {
+		if w > 0 {
+			rankCount[maxBits+1-uint(w)]++
+		}
+	}
+	var rankIdx [maxHuffBits + 1]uint32
+	for i := maxBits; i >= 1; i-- {
+		rankIdx[i-1] = rankIdx[i] + rankCount[i]<<(maxBits-i)
+	}
+	size := uint32(1) << maxBits
+	if rankIdx[0] != size {
+		return nil, errCorrupt
+	}
+
+	t := &huffTable{
+		maxBits: maxBits,
+		symbols: make([]uint8, size),
+		nbBits:  make([]uint8, size),
+	}
+	for s, w := range weights {
+		if w == 0 {
+			continue
+		}
+		nb := maxBits + 1 - uint(w)
+		code := rankIdx[nb]
+		n := uint32(1) << (maxBits - nb)
+		for i := code; i < code+n; i++ {
+			t.symbols[i] = uint8(s)
+			t.nbBits[i] = uint8(nb)
+		}
+		rankIdx[nb] += n
+	}
+	return t, nil
+}
+
+// decodeStream decodes len(out) symbols of the Huffman stream in.
+func (t *huffTable) decodeStream(out, in []byte) error {
+	var br backwardBitReader
+	if err := br.init(in); err != nil {
+		return err
+	}
+	state := uint32(br.read(t.maxBits))
+	mask := uint32(1)<<t.maxBits - 1
+	for i := range out {
+		out[i] = t.symbols[state]
+		nb := uint(t.nbBits[state])
+		state = (state<<nb | uint32(br.read(nb))) & mask
+	}
+	if br.pos != -int(t.maxBits) {
+		return errCorrupt
+	}
+	return nil
+}
+
+// decode decodes the literals of in, in one or four streams.
+func (t *huffTable) decode(out, in []byte, streams int) error {
+	if streams == 1 {
+		return t.decodeStream(out, in)
+	}
+	if len(in) < 6 {
+		return errCorrupt
+	}
+	sizes := [4]int{
+		int(in[0]) | int(in[1])<<8,
+		int(in[2]) | int(in[3])<<8,
+		int(in[4]) | int(in[5])<<8,
+	}
+	in = in[6:]
+	sizes[3] = len(in) - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return errCorrupt
+	}
+	segment := (len(out) + 3) / 4
+	for i := 0; i < 4; i++ {
+		o := out[min(i*segment, len(out)):min((i+1)*segment, len(out))]
+		if err := t.decodeStream(o, in[:sizes[i]]); err != nil {
+			return err
+		}
+		in = in[sizes[i]:]
+	}
+	return nil
+}
+
+// huffEncoder holds the codes of a Huffman table.
+type huffEncoder struct {
+	maxBits uint
+	codes   [maxHuffSymbols]uint16
+	nbBits  [maxHuffSymbols]uint8
+	last    int // last symbol with a code
+}
+
+// newHuffEncoder builds the Huffman codes of the counted symbols. At
+// least two symbols must be counted.
+func newHuffEncoder(counts []int) *huffEncoder {
+	lengths := huffLengths(counts, maxHuffBits)
+	e := new(huffEncoder)
+	for s, l := range lengths {
+		if l > 0 {
+			e.last = s
+			if uint(l) > e.maxBits {
+				e.maxBits = uint(l)
+			}
+		}
+	}
+
+	// assign the codes as the decoder does
+	var rankCount [maxHuffBits + 1]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			rankCount[l]++
+		}
+	}
+	var rankIdx [maxHuffBits + 1]uint32
+	for i := e.maxBits; i >= 1; i-- {
+		rankIdx[i-1] = rankIdx[i] + rankCount[i]<<(e.maxBits-i)
+	}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		n := uint32(1) << (e.maxBits - uint(l))
+		e.codes[s] = uint16(rankIdx[l] >> (e.maxBits - uint(l)))
+		e.nbBits[s] = l
+		rankIdx[l] += n
+	}
+	return e
+}
+
+// huffLengths returns the code lengths of a Huffman code of the counted
+// symbols, limited to maxBits bits. The code is complete.
+func huffLengths(counts []int, maxBits uint) []uint8 {
+	type node struct {
+		count       int
+		symbol      int // -1 for internal nodes
+		left, right int
+	}
+	var nodes []node
+	for s, c := range counts {
+		if c > 0 {
+			nodes = append(nodes, node{count: c, symbol: s})
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+	leaves := len(nodes)
+
+	// two queues: the sorted leaves, and the internal nodes
+	// created in increasing order of counts
+	li, ni := 0, leaves
+	pick := func() int {
+		if li < leaves && (ni >= len(nodes) || nodes[li].count <= nodes[ni].count) {
+			li++
+			return li - 1
+		}
+		ni++
+		return ni - 1
+	}
+	for len(nodes)-leaves < leaves-1 {
+		a := pick()
+		b := pick()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, symbol: -1, left: a, right: b})
+	}
+
+	lengths := make([]uint8, len(counts))
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 1; i >= leaves; i-- {
+		depth[nodes[i].left] = depth[i] + 1
+		depth[nodes[i].right] = depth[i] + 1
+	}
+
+	// limit the lengths, keeping the code complete: the sum of
+	// 2^(maxBits-length) must be 2^maxBits
+	kraft := 0
+	for i := 0; i < leaves; i++ {
+		d := depth[i]
+		if d > int(maxBits) {
+			d = int(maxBits)
+		}
+		depth[i] = d
+		kraft += 1 << (maxBits - uint(d))
+	}
+	// the leaves are in increasing order of counts; lengthen the codes
+	// of the rarest symbols first
+	for kraft > 1<<maxBits {
+		for i := 0; i < leaves && kraft > 1<<maxBits; i++ {
+			if depth[i] < int(maxBits) {
+				kraft -= 1 << (maxBits - uint(depth[i]) - 1)
+				depth[i]++
+			}
+		}
+	}
+	// shorten the codes of the most frequent symbols, while it fits;
+	// the deepest code always fits
+	for kraft < 1<<maxBits {
+		for i := leaves - 1; i >= 0; i-- {
+			if depth[i] > 1 && kraft+1<<(maxBits-uint(depth[i])) <= 1<<maxBits {
+				kraft += 1 << (maxBits - uint(depth[i]))
+				depth[i]--
+				break
+			}
+		}
+	}
+	for i := 0; i < leaves; i++ {
+		lengths[nodes[i].symbol] = uint8(depth[i])
+	}
+	return lengths
+}
+
+// weights returns the weights of the symbols before the last one.
+func (e *huffEncoder) weights() []uint8 {
+	w := make([]uint8, e.last)
+	for s := range w {
+		if e.nbBits[s] > 0 {
+			w[s] = uint8(e.maxBits + 1 - uint(e.nbBits[s]))
+		}
+	}
+	return w
+}
+
+// writeTable appends the tree description to out. It returns nil if
+// the weights cannot be described.
+func (e *huffEncoder) writeTable(out []byte) []byte {
+	w := e.weights()
+	if c := compressHuffWeights(w); c != nil && (len(w) > 128 || len(c) < (len(w)+1)/2) {
+		out = append(out, byte(len(c)))
+		return append(out, c...)
+	}
+	if len(w) > 128 {
+		return nil
+	}
+	out = append(out, byte(127+len(w)))
+	for i := 0; i < len(w); i += 2 {
+		b := w[i] << 4
+		if i+1 < len(w) {
+			b |= w[i+1]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// compressHuffWeights compresses the weights with two interleaved FSE
+// states. It returns nil if they cannot be compressed.
+func compressHuffWeights(w []uint8) []byte {
+	if len(w) < 2 {
+		return nil
+	}
+	counts := make([]int, maxHuffBits+1)
+	distinct := 0
+	for _, v := range w {
+		if counts[v] == 0 {
+			distinct++
+		}
+		counts[v]++
+	}
+	if distinct < 2 {
+		return nil
+	}
+	const log = 6
+	norm := normalizeCounts(counts, len(w), log)
+	ct := newFSEEncTable(norm, log)
+
+	bw := new(bitWriter)
+	writeFSETable(bw, norm, log)
+	table := append([]byte(nil), bw.out...)
+
+	bw = new(bitWriter)
+	var s1, s2 fseEncState
+	i := len(w)
+	if i%2 == 1 {
+		s1.init(ct, w[i-1])
+		s2.init(ct, w[i-2])
+		s1.encode(bw, w[i-3])
+		i -= 3
+	} else {
+		s2.init(ct, w[i-1])
+		s1.init(ct, w[i-2])
+		i -= 2
+	}
+	for i > 0 {
+		s2.encode(bw, w[i-1])
+		s1.encode(bw, w[i-2])
+		i -= 2
+	}
+	s2.flush(bw)
+	s1.flush(bw)
+	out := append(table, bw.close()...)
+	if len(out) >= 128 {
+		return nil
+	}
+	return out
+}
+
+// encodeStream appends the Huffman stream of the symbols to out.
+func (e *huffEncoder) encodeStream(out, src []byte) []byte {
+	bw := &bitWriter{out: out}
+	for i := len(src) - 1; i >= 0; i-- {
+		s := src[i]
+		bw.add(uint64(e.codes[s]), uint(e.nbBits[s]))
+	}
+	return bw.close()
+}
+
+// encode appends the literals of src to out, in one or four streams.
+func (e *huffEncoder) encode(out, src []byte, streams int) []byte {
+	if streams == 1 {
+		return e.encodeStream(out, src)
+	}
+	start := len(out)
+	out = append(out, 0, 0, 0, 0, 0, 0)
+	segment := (len(src) + 3) / 4
+	for i := 0; i < 4; i++ {
+		n := len(out)
+		out = e.encodeStream(out, src[min(i*segment, len(src)):min((i+1)*segment, len(src))])
+		if i < 3 {
+			size := len(out) - n
+			out[start+2*i] = byte(size)
+			out[start+2*i+1] = byte(size >> 8)
+		}
+	}
+	return out
+}
+
+// estimate returns the size in bits of the counted symbols.
+func (e *huffEncoder) estimate(counts []int) int {
+	n := 0
+	for s, c := range counts {
+		n += c * int(e.nbBits[s])
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime64x1 = 11400714785074694791
+	prime64x2 = 14029467366897019727
+	prime64x3 = 1609587929392839161
+	prime64x4 = 9650029242287828579
+	prime64x5 = 2870177450012600261
+)
+
+// xxhash64 computes the XXH64 hash with seed 0, which is used
+// for the content checksums.
+type xxhash64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int // bytes in mem
+}
+
+func (h *xxhash64) reset() {
+	p1, p2 := uint64(prime64x1), uint64(prime64x2)
+	h.v = [4]uint64{p1 + p2, p2, 0, -p1}
+	h.total = 0
+	h.n = 0
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * prime64x2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime64x1
+}
+
+func xxMerge(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*prime64x1 + prime64x4
+}
+
+func (h *xxhash64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 32 {
+		h.n += copy(h.mem[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		h.blocks(h.mem[:])
+		p = p[c:]
+		h.n = 0
+	}
+	if m := len(p) &^ 31; m > 0 {
+		h.blocks(p[:m])
+		p = p[m:]
+	}
+	h.n = copy(h.mem[:], p)
+	return n, nil
+}
+
+func (h *xxhash64) blocks(p []byte) {
+	for ; len(p) >= 32; p = p[32:] {
+		h.v[0] = xxRound(h.v[0], binary.LittleEndian.Uint64(p[0:]))
+		h.v[1] = xxRound(h.v[1], binary.LittleEndian.Uint64(p[8:]))
+		h.v[2] = xxRound(h.v[2], binary.LittleEndian.Uint64(p[16:]))
+		h.v[3] = xxRound(h.v[3], binary.LittleEndian.Uint64(p[24:]))
+	}
+}
+
+func (h *xxhash64) sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v[0], 1) + bits.RotateLeft64(h.v[1], 7) +
+			bits.RotateLeft64(h.v[2], 12) + bits.RotateLeft64(h.v[3], 18)
+		for _, v := range h.v {
+			acc = xxMerge(acc, v)
+		}
+	} else {
+		acc = prime64x5
+	}
+	acc += h.total
+
+	p := h.mem[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		acc ^= xxRound(0, binary.LittleEndian.Uint64(p))
+		acc = bits.RotateLeft64(acc, 27)*prime64x1 + prime64x4
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * prime64x1
+		acc = bits.RotateLeft64(acc, 23)*prime64x2 + prime64x3
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc ^= uint64(b) * prime64x5
+		acc = bits.RotateLeft64(acc, 11) * prime64x1
+	}
+
+	acc ^= acc >> 33
+	acc *= prime64x2
+	acc ^= acc >> 29
+	acc *= prime64x3
+	acc ^= acc >> 32
+	return acc
+}
@@ -0,0 +1,126 @@
+// Package zstd implements reading and writing of the Zstandard
+// compressed data format, as specified by RFC 8878.
+//
+// Dictionaries are not supported.
+package zstd
+
+import "errors"
+
+const (
+	frameMagic         = 0xFD2FB528
+	skippableMagicMask = 0xFFFFFFF0
+	skippableMagic     = 0x184D2A50
+
+	maxBlockSize  = 128 << 10
+	minWindowLog  = 10
+	maxWindowLog  = 31
+	minMatch      = 3
+	maxLitLenCode = 35
+	maxMatchCode  = 52
+	maxOffsetCode = 31
+	maxLitLenLog  = 9
+	maxMatchLog   = 9
+	maxOffsetLog  = 8
+)
+
+var (
+	errCorrupt  = errors.New("zstd: corrupt input")
+	errChecksum = errors.New("zstd: checksum mismatch")
+)
+
+// Block types.
+const (
+	blockRaw = iota
+	blockRLE
+	blockCompressed
+	blockReserved
+)
+
+// Literals block types.
+const (
+	literalsRaw = iota
+	literalsRLE
+	literalsCompressed
+	literalsTreeless
+)
+
+// Symbol compression modes of the sequences.
+const (
+	modePredefined = iota
+	modeRLE
+	modeFSE
+	modeRepeat
+)
+
+// Baselines and numbers of extra bits of the literals length codes.
+var (
+	litLenBase = [maxLitLenCode + 1]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	litLenBits = [maxLitLenCode + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+)
+
+// Baselines and numbers of extra bits of the match length codes.
+var (
+	matchBase = [maxMatchCode + 1]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchBits = [maxMatchCode + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined distributions of the sequence codes.
+var (
+	litLenDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	litLenDefaultLog = 6
+	matchDefaultLog  = 6
+	offsetDefaultLog = 5
+)
+
+var (
+	litLenDefaultTable = mustFSETable(litLenDefault, litLenDefaultLog)
+	matchDefaultTable  = mustFSETable(matchDefault, matchDefaultLog)
+	offsetDefaultTable = mustFSETable(offsetDefault, offsetDefaultLog)
+
+	litLenDefaultEnc = newFSEEncTable(litLenDefault, litLenDefaultLog)
+	matchDefaultEnc  = newFSEEncTable(matchDefault, matchDefaultLog)
+	offsetDefaultEnc = newFSEEncTable(offsetDefault, offsetDefaultLog)
+)
+
+func mustFSETable(norm []int16, log uint) *fseTable {
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
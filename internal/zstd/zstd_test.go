@@ -0,0 +1,160 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []string{
+		"testdata/e.txt.zst",     // zstd -19, single segment
+		"testdata/e-nocheck.zst", // zstd -1 --no-check
+		"testdata/e-multi.zst",   // two frames and a skippable frame
+	}
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("%s: %v", test, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: decoded data is different", test)
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/e.txt.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checksum
+	b := append([]byte(nil), data...)
+	b[len(b)-1] ^= 1
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(b))); err != errChecksum {
+		t.Fatalf("err=%v, want %v", err, errChecksum)
+	}
+
+	// truncated
+	b = data[:len(data)/2]
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(b))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err=%v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// corrupted
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := append([]byte(nil), data...)
+		b[4+rng.Intn(len(b)-4)] ^= byte(1 << uint(rng.Intn(8)))
+		ioutil.ReadAll(NewReader(bytes.NewReader(b)))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/e.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	// larger than the windows of the fast levels
+	var large []byte
+	for len(large) < 3<<20 {
+		large = append(large, text...)
+		large = append(large, random[:len(text)/2]...)
+	}
+
+	// RLE blocks slid out of the window
+	var zerosText []byte
+	zerosText = append(zerosText, make([]byte, 3<<20)...)
+	zerosText = append(zerosText, text...)
+	zerosText = append(zerosText, make([]byte, 3<<20)...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("hello")},
+		{"zeros", make([]byte, 300000)},
+		{"long zeros", make([]byte, 20<<20)},
+		{"zeros text", zerosText},
+		{"text", text},
+		{"random", random},
+		{"large", large},
+	}
+	for _, test := range tests {
+		for _, level := range []int{BestSpeed, DefaultCompression, 9, 19} {
+			if testing.Short() && test.name == "large" && level > DefaultCompression {
+				continue
+			}
+			buf := new(bytes.Buffer)
+			w, err := NewWriterLevel(buf, level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for p := test.data; len(p) > 0; {
+				n := 10000
+				if n > len(p) {
+					n = len(p)
+				}
+				if _, err := w.Write(p[:n]); err != nil {
+					t.Fatal(err)
+				}
+				p = p[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(test.data) > 1000 && test.name != "random" && buf.Len() >= len(test.data)/2 {
+				t.Errorf("%s, level %d: compressed size %d of %d", test.name, level, buf.Len(), len(test.data))
+			}
+
+			got, err := ioutil.ReadAll(NewReader(buf))
+			if err != nil {
+				t.Fatalf("%s, level %d: %v", test.name, level, err)
+			}
+			if !bytes.Equal(got, test.data) {
+				t.Fatalf("%s, level %d: decoded data is different", test.name, level)
+			}
+		}
+	}
+
+	if _, err := NewWriterLevel(ioutil.Discard, BestCompression+1); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	w := NewWriter(nil)
+	for _, s := range []string{"first data, first data", "second data, second data"} {
+		buf := new(bytes.Buffer)
+		w.Reset(buf)
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s {
+			t.Fatalf("got %q, want %q", got, s)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A ConflictPolicy tells Merge and Deduplicate what to do with a file
+// whose name is already used in the Updater.
+type ConflictPolicy int
+
+const (
+	ConflictError     ConflictPolicy = iota // fail without merging any file
+	ConflictSkip                            // keep the existing file
+	ConflictOverwrite                       // replace the existing file
+	ConflictRename                          // add the file as "name (n).ext"
+)
+
+// A MergePolicy controls how Merge adds the files of an archive.
+type MergePolicy struct {
+	Conflict ConflictPolicy
+	Prefix   string // prepended to the names of the added files, such as "dir/"
+}
+
+// Merge adds all the files of r, resolving name conflicts with
+// policy.Conflict. Directories that already exist are not conflicts.
+//
+// The files are copied without recompression when the changes are
+// saved, so r must stay readable until then.
+func (u *Updater) Merge(r *Reader, policy MergePolicy) error {
+	type merge struct {
+		zf   *File
+		name string
+	}
+	var merges []merge
+
+	// Resolve the names first, so that nothing is merged on error.
+	taken := make(map[string]bool)
+	exists := func(name string) bool {
+		return u.lookup(name) != nil || taken[name]
+	}
+	for _, zf := range r.File {
+		name := policy.Prefix + zf.Name
+		if exists(name) {
+			if strings.HasSuffix(name, "/") {
+				continue
+			}
+			switch policy.Conflict {
+			case ConflictError:
+				return fmt.Errorf("zip: %s already exists", name)
+			case ConflictSkip:
+				continue
+			case ConflictOverwrite:
+			case ConflictRename:
+				name = uniqueName(name, exists)
+			default:
+				return errors.New("zip: invalid conflict policy")
+			}
+		}
+		taken[name] = true
+		merges = append(merges, merge{zf, name})
+	}
+
+	for _, m := range merges {
+		u.copyFile(m.zf, m.name)
+	}
+	return nil
+}
+
+// CopyFrom adds the file name of r as newName, or as name if newName
+// is empty. The file is copied without recompression when the changes
+// are saved, so r must stay readable until then.
+func (u *Updater) CopyFrom(r *Reader, name, newName string) error {
+	if newName == "" {
+		newName = name
+	}
+	if u.lookup(newName) != nil {
+		return errors.New("invalid duplicate file name")
+	}
+	for _, zf := range r.File {
+		if zf.Name == name {
+			u.copyFile(zf, newName)
+			return nil
+		}
+	}
+	return errors.New("not found file name")
+}
+
+// copyFile sets the file name to the raw contents of zf,
+// replacing the existing file.
+func (u *Updater) copyFile(zf *File, name string) {
+	fh := zf.FileHeader
+	fh.Name = name
+	fh.Extra = removeExtra(fh.Extra, zip64ExtraID) // regenerated by Writer
+
+	e := u.lookup(name)
+	if e == nil {
+		u.add(&entry{header: &fh, origin: zf})
+		return
+	}
+	e.header = &fh
+	old := e.buf
+	e.buf = nil
+	u.releaseBuffer(old)
+	e.origin = zf
+	e.source = nil
+	e.recompress = false
+}
+
+// uniqueName returns name with the smallest " (n)" suffix,
+// inserted before the extension, for which exists returns false.
+func uniqueName(name string, exists func(string) bool) string {
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for n := 1; ; n++ {
+		s := fmt.Sprintf("%s%s (%d)%s", dir, stem, n, ext)
+		if !exists(s) {
+			return s
+		}
+	}
+}
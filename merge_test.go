@@ -0,0 +1,150 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+var mergeTest = []ZipTestFile{
+	{Name: "hello", Content: []byte("merged hello\n")},
+	{Name: "dir/", Content: []byte{}},
+	{Name: "dir/new.txt", Content: []byte("new file\n")},
+}
+
+func testMergeReader(t *testing.T) *Reader {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, ztf := range mergeTest {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestUpdaterMerge(t *testing.T) {
+	tests := []struct {
+		policy MergePolicy
+		want   []ZipTestFile
+	}{
+		{
+			policy: MergePolicy{Conflict: ConflictSkip},
+			want: append(updateTest.File[:len(updateTest.File):len(updateTest.File)],
+				mergeTest[1], mergeTest[2]),
+		},
+		{
+			policy: MergePolicy{Conflict: ConflictOverwrite},
+			want: append([]ZipTestFile{mergeTest[0]},
+				updateTest.File[1], updateTest.File[2], updateTest.File[3],
+				mergeTest[1], mergeTest[2]),
+		},
+		{
+			policy: MergePolicy{Conflict: ConflictRename},
+			want: append(updateTest.File[:len(updateTest.File):len(updateTest.File)],
+				ZipTestFile{Name: "hello (1)", Content: mergeTest[0].Content},
+				mergeTest[1], mergeTest[2]),
+		},
+		{
+			policy: MergePolicy{Conflict: ConflictError, Prefix: "sub/"},
+			want: append(updateTest.File[:len(updateTest.File):len(updateTest.File)],
+				ZipTestFile{Name: "sub/hello", Content: mergeTest[0].Content},
+				ZipTestFile{Name: "sub/dir/", Content: mergeTest[1].Content},
+				ZipTestFile{Name: "sub/dir/new.txt", Content: mergeTest[2].Content}),
+		},
+	}
+
+	for _, test := range tests {
+		file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+		defer file.Close()
+		defer z.Close()
+
+		if err := z.Merge(testMergeReader(t), test.policy); err != nil {
+			t.Fatal(err)
+		}
+		compareContents(t, z, test.want)
+
+		// save
+		wdump := new(bytes.Buffer)
+		if err := z.SaveAs(wdump); err != nil {
+			t.Fatal(err)
+		}
+
+		// check file
+		zr, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareContents(t, zr, test.want)
+	}
+}
+
+func TestUpdaterMergeError(t *testing.T) {
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	if err := z.Merge(testMergeReader(t), MergePolicy{Conflict: ConflictError}); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// nothing is merged
+	compareContents(t, z, updateTest.File)
+}
+
+func TestUpdaterCopyFrom(t *testing.T) {
+	file, z := testOpenTempFile(t, "testdata/"+updateTest.Name)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	defer z.Close()
+
+	zr := testMergeReader(t)
+	if err := z.CopyFrom(zr, "hello", ""); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.CopyFrom(zr, "not-exist", "other"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.CopyFrom(zr, "hello", "hello2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.CopyFrom(zr, "dir/new.txt", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	testcase := append(updateTest.File[:len(updateTest.File):len(updateTest.File)],
+		ZipTestFile{Name: "hello2", Content: mergeTest[0].Content},
+		mergeTest[2])
+	compareContents(t, z, testcase)
+
+	// save
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	st, _ := file.Stat()
+	zu, err := NewUpdater(file, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, testcase)
+}
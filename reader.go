@@ -26,11 +26,12 @@ type Reader struct {
 	r             io.ReaderAt
 	File          []*File
 	Comment       string
-	decompressors map[uint16]Decompressor
+	decompressors map[uint16]MethodDecompressor
+	options       DecompressOptions
 }
 
 type ReadCloser struct {
-	f *os.File
+	f []*os.File
 	Reader
 }
 
@@ -40,6 +41,7 @@ type File struct {
 	zipr         io.ReaderAt
 	zipsize      int64
 	headerOffset int64
+	diskNbr      uint32 // number of the disk on which the file starts
 }
 
 func (f *File) hasDataDescriptor() bool {
@@ -58,11 +60,11 @@ func OpenReader(name string) (*ReadCloser, error) {
 		return nil, err
 	}
 	r := new(ReadCloser)
-	if err := r.init(f, fi.Size()); err != nil {
+	if err := r.init(f, fi.Size(), nil); err != nil {
 		f.Close()
 		return nil, err
 	}
-	r.f = f
+	r.f = []*os.File{f}
 	return r, nil
 }
 
@@ -70,14 +72,17 @@ func OpenReader(name string) (*ReadCloser, error) {
 // have the given size in bytes.
 func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
 	zr := new(Reader)
-	if err := zr.init(r, size); err != nil {
+	if err := zr.init(r, size, nil); err != nil {
 		return nil, err
 	}
 	return zr, nil
 }
 
-func (z *Reader) init(r io.ReaderAt, size int64) error {
-	end, err := readDirectoryEnd(r, size)
+// init reads the central directory of the archive r.
+// disks holds the offset of each disk within r for multi-volume archives,
+// and is nil for ordinary archives.
+func (z *Reader) init(r io.ReaderAt, size int64, disks []int64) error {
+	end, err := readDirectoryEnd(r, size, disks)
 	if err != nil {
 		return err
 	}
@@ -106,6 +111,9 @@ func (z *Reader) init(r io.ReaderAt, size int64) error {
 		if err != nil {
 			return err
 		}
+		if f.headerOffset, err = diskOffset(disks, f.diskNbr, f.headerOffset); err != nil {
+			return err
+		}
 		z.File = append(z.File, f)
 	}
 	if uint16(len(z.File)) != uint16(end.directoryRecords) { // only compare 16 bits here
@@ -120,13 +128,29 @@ func (z *Reader) init(r io.ReaderAt, size int64) error {
 // specific method ID. If a decompressor for a given method is not found,
 // Reader will default to looking up the decompressor at the package level.
 func (z *Reader) RegisterDecompressor(method uint16, dcomp Decompressor) {
+	if dcomp == nil {
+		z.RegisterMethodDecompressor(method, nil)
+		return
+	}
+	z.RegisterMethodDecompressor(method, dcomp)
+}
+
+// RegisterMethodDecompressor is like RegisterDecompressor,
+// for a MethodDecompressor.
+func (z *Reader) RegisterMethodDecompressor(method uint16, dcomp MethodDecompressor) {
 	if z.decompressors == nil {
-		z.decompressors = make(map[uint16]Decompressor)
+		z.decompressors = make(map[uint16]MethodDecompressor)
 	}
 	z.decompressors[method] = dcomp
 }
 
-func (z *Reader) decompressor(method uint16) Decompressor {
+// SetDecompressOptions sets the options given to the decompressors
+// by File.Open.
+func (z *Reader) SetDecompressOptions(opts DecompressOptions) {
+	z.options = opts
+}
+
+func (z *Reader) decompressor(method uint16) MethodDecompressor {
 	dcomp := z.decompressors[method]
 	if dcomp == nil {
 		dcomp = decompressor(method)
@@ -136,7 +160,13 @@ func (z *Reader) decompressor(method uint16) Decompressor {
 
 // Close closes the Zip file, rendering it unusable for I/O.
 func (rc *ReadCloser) Close() error {
-	return rc.f.Close()
+	var err error
+	for _, f := range rc.f {
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+	}
+	return err
 }
 
 // DataOffset returns the offset of the file's possibly-compressed
@@ -152,6 +182,24 @@ func (f *File) DataOffset() (offset int64, err error) {
 	return f.headerOffset + bodyOffset, nil
 }
 
+// endOffset returns the offset just past the file's data and data
+// descriptor. The data descriptor is assumed to have a signature.
+func (f *File) endOffset() (int64, error) {
+	bodyOffset, err := f.findBodyOffset()
+	if err != nil {
+		return 0, err
+	}
+	end := f.headerOffset + bodyOffset + int64(f.CompressedSize64)
+	if f.hasDataDescriptor() {
+		if f.isZip64() {
+			end += dataDescriptor64Len
+		} else {
+			end += dataDescriptorLen
+		}
+	}
+	return end, nil
+}
+
 // Open returns a ReadCloser that provides access to the File's contents.
 // Multiple files may be read concurrently.
 func (f *File) Open() (io.ReadCloser, error) {
@@ -165,7 +213,18 @@ func (f *File) Open() (io.ReadCloser, error) {
 	if dcomp == nil {
 		return nil, ErrAlgorithm
 	}
-	var rc io.ReadCloser = dcomp(r)
+	opts := f.zip.options
+	rc, err := dcomp.NewReader(r, &f.FileHeader, &opts)
+	if err != nil {
+		return nil, err
+	}
+	if f.noEndMarker() {
+		// Without the end marker, the data ends at the uncompressed size.
+		rc = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(rc, int64(f.UncompressedSize64)), rc}
+	}
 	var desr io.Reader
 	if f.hasDataDescriptor() {
 		desr = io.NewSectionReader(f.zipr, f.headerOffset+bodyOffset+size, dataDescriptorLen)
@@ -179,6 +238,17 @@ func (f *File) Open() (io.ReadCloser, error) {
 	return rc, nil
 }
 
+// noEndMarker reports whether the compressed data may have no end marker.
+func (f *File) noEndMarker() bool {
+	switch f.Method {
+	case Reduce1, Reduce2, Reduce3, Reduce4, Implode, PPMd:
+		return true
+	case LZMA:
+		return f.Flags&FlagLZMAEndMarker == 0
+	}
+	return false
+}
+
 // rawReader returns a io.Reader that provides access to the File's compressed contents.
 func (f *File) rawReader() (io.Reader, error) {
 	bodyOffset, err := f.findBodyOffset()
@@ -281,7 +351,8 @@ func readDirectoryHeader(f *File, r io.Reader) error {
 	filenameLen := int(b.uint16())
 	extraLen := int(b.uint16())
 	commentLen := int(b.uint16())
-	b = b[4:] // skipped start disk number and internal attributes (2x uint16)
+	f.diskNbr = uint32(b.uint16())
+	b = b[2:] // skipped internal attributes (uint16)
 	f.ExternalAttrs = b.uint32()
 	f.headerOffset = int64(b.uint32())
 	d := make([]byte, filenameLen+extraLen+commentLen)
@@ -313,6 +384,7 @@ func readDirectoryHeader(f *File, r io.Reader) error {
 	needUSize := f.UncompressedSize == ^uint32(0)
 	needCSize := f.CompressedSize == ^uint32(0)
 	needHeaderOffset := f.headerOffset == int64(^uint32(0))
+	needDiskNbr := f.diskNbr == uint16max
 
 	// Best effort to find what we need.
 	// Other zip authors might not even follow the basic format,
@@ -354,6 +426,10 @@ parseExtras:
 				}
 				f.headerOffset = int64(fieldBuf.uint64())
 			}
+			if needDiskNbr && len(fieldBuf) >= 4 {
+				needDiskNbr = false
+				f.diskNbr = fieldBuf.uint32()
+			}
 		case ntfsExtraID:
 			if len(fieldBuf) < 4 {
 				continue parseExtras
@@ -468,7 +544,7 @@ func readDataDescriptor(r io.Reader, f *File) error {
 	return nil
 }
 
-func readDirectoryEnd(r io.ReaderAt, size int64) (dir *directoryEnd, err error) {
+func readDirectoryEnd(r io.ReaderAt, size int64, disks []int64) (dir *directoryEnd, err error) {
 	// look for directoryEndSignature in the last 1k, then in the last 65k
 	var buf []byte
 	var directoryEndOffset int64
@@ -509,7 +585,7 @@ func readDirectoryEnd(r io.ReaderAt, size int64) (dir *directoryEnd, err error)
 
 	// These values mean that the file can be a zip64 file
 	if d.directoryRecords == 0xffff || d.directorySize == 0xffff || d.directoryOffset == 0xffffffff {
-		p, err := findDirectory64End(r, directoryEndOffset)
+		p, err := findDirectory64End(r, directoryEndOffset, disks)
 		if err == nil && p >= 0 {
 			err = readDirectory64End(r, p, d)
 		}
@@ -517,6 +593,17 @@ func readDirectoryEnd(r io.ReaderAt, size int64) (dir *directoryEnd, err error)
 			return nil, err
 		}
 	}
+	if disks != nil {
+		// The end record is stored on the last disk.
+		if int(d.diskNbr) != len(disks)-1 {
+			return nil, errMissingVolume
+		}
+		o, err := diskOffset(disks, d.dirDiskNbr, int64(d.directoryOffset))
+		if err != nil {
+			return nil, err
+		}
+		d.directoryOffset = uint64(o)
+	}
 	// Make sure directoryOffset points to somewhere in our file.
 	if o := int64(d.directoryOffset); o < 0 || o >= size {
 		return nil, ErrFormat
@@ -526,8 +613,8 @@ func readDirectoryEnd(r io.ReaderAt, size int64) (dir *directoryEnd, err error)
 
 // findDirectory64End tries to read the zip64 locator just before the
 // directory end and returns the offset of the zip64 directory end if
-// found.
-func findDirectory64End(r io.ReaderAt, directoryEndOffset int64) (int64, error) {
+// found. disks is the same as for Reader.init.
+func findDirectory64End(r io.ReaderAt, directoryEndOffset int64, disks []int64) (int64, error) {
 	locOffset := directoryEndOffset - directory64LocLen
 	if locOffset < 0 {
 		return -1, nil // no need to look for a header outside the file
@@ -540,11 +627,16 @@ func findDirectory64End(r io.ReaderAt, directoryEndOffset int64) (int64, error)
 	if sig := b.uint32(); sig != directory64LocSignature {
 		return -1, nil
 	}
-	if b.uint32() != 0 { // number of the disk with the start of the zip64 end of central directory
-		return -1, nil // the file is not a valid zip64-file
+	disk := b.uint32()  // number of the disk with the start of the zip64 end of central directory
+	p := b.uint64()     // relative offset of the zip64 end of central directory record
+	total := b.uint32() // total number of disks
+	if disks != nil {
+		if int(total) != len(disks) {
+			return -1, errMissingVolume
+		}
+		return diskOffset(disks, disk, int64(p))
 	}
-	p := b.uint64()      // relative offset of the zip64 end of central directory record
-	if b.uint32() != 1 { // total number of disks
+	if disk != 0 || total != 1 {
 		return -1, nil // the file is not a valid zip64-file
 	}
 	return int64(p), nil
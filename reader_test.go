@@ -479,6 +479,211 @@ var tests = []ZipTest{
 			},
 		},
 	},
+	{
+		Name: "bzip2-infozip.zip",
+		File: []ZipTestFile{
+			{
+				Name:     "readme.txt",
+				File:     "readme.notzip",
+				Mode:     0644,
+				Modified: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:     "test.txt",
+				Content:  []byte("This is a test text file.\n"),
+				Mode:     0644,
+				Modified: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "bzip2-python.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "deflate64.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "shrink.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "reduce.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme-1.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-2.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-3.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-4.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "implode.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme-4k.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-4k-literals.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-8k.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "readme-8k-literals.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "lzma-python.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "lzma-noeos.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
+	{
+		Name: "xz.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}, {
+		Name: "ppmd.zip",
+		File: []ZipTestFile{
+			{
+				Name:    "readme.txt",
+				File:    "readme.notzip",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:    "gophercolor16x16.png",
+				File:    "gophercolor16x16.png",
+				Mode:    0644,
+				ModTime: time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	},
 }
 
 func TestReader(t *testing.T) {
@@ -804,7 +1009,6 @@ func returnRecursiveZip() (r io.ReaderAt, size int64) {
 //
 // It's here in hex for the same reason as rZipBytes above: to avoid
 // problems with on-disk virus scanners or other zip processors.
-//
 func biggestZipBytes() []byte {
 	s := `
 0000000 50 4b 03 04 14 00 08 00 08 00 00 00 00 00 00 00
@@ -5,11 +5,19 @@
 package zip
 
 import (
+	"compress/bzip2"
 	"compress/flate"
 	"errors"
 	"io"
 	"io/ioutil"
 	"sync"
+
+	bzip2EX "github.com/hidez8891/zip/internal/bzip2"
+	"github.com/hidez8891/zip/internal/deflate64"
+	"github.com/hidez8891/zip/internal/legacy"
+	"github.com/hidez8891/zip/internal/lzma"
+	"github.com/hidez8891/zip/internal/ppmd"
+	"github.com/hidez8891/zip/internal/zstd"
 )
 
 // A Compressor returns a new compressing writer, writing to w.
@@ -26,42 +34,108 @@ type Compressor func(w io.Writer) (io.WriteCloser, error)
 // one goroutine at a time.
 type Decompressor func(r io.Reader) io.ReadCloser
 
-var flateWriterPool sync.Pool
+// CompressOptions are the options of a compression by a MethodCompressor.
+type CompressOptions struct {
+	// Level is the compression level, as FileHeader.Level.
+	Level int
 
-func newFlateWriter(w io.Writer) io.WriteCloser {
-	fw, ok := flateWriterPool.Get().(*flate.Writer)
-	if ok {
-		fw.Reset(w)
-	} else {
-		fw, _ = flate.NewWriter(w, 5)
+	// Dictionary is the preset dictionary of the methods supporting
+	// one, such as Deflate. It is not stored in the archive: the data
+	// must be decompressed with the same dictionary.
+	Dictionary []byte
+
+	// Concurrency is the number of goroutines the compression may use.
+	// Zero or one compresses sequentially. The built-in methods
+	// compress sequentially.
+	Concurrency int
+}
+
+// DecompressOptions are the options of a decompression
+// by a MethodDecompressor.
+type DecompressOptions struct {
+	// Dictionary is the preset dictionary the data was compressed with.
+	Dictionary []byte
+}
+
+// A MethodCompressor returns a new compressing writer, writing the data
+// of the file fh to w. Unlike a Compressor, it is given the FileHeader
+// and the options of the compression. The MethodCompressor must be safe
+// to invoke from multiple goroutines simultaneously, as a Compressor.
+type MethodCompressor interface {
+	NewWriter(w io.Writer, fh *FileHeader, opts *CompressOptions) (io.WriteCloser, error)
+}
+
+// A MethodDecompressor returns a new decompressing reader, reading the
+// data of the file fh from r. Unlike a Decompressor, it is given the
+// FileHeader and the options of the decompression, and can report the
+// errors found on creating the reader, such as invalid properties.
+// The MethodDecompressor must be safe to invoke from multiple goroutines
+// simultaneously, as a Decompressor.
+type MethodDecompressor interface {
+	NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error)
+}
+
+// NewWriter returns comp(w), ignoring fh and opts.
+func (comp Compressor) NewWriter(w io.Writer, fh *FileHeader, opts *CompressOptions) (io.WriteCloser, error) {
+	return comp(w)
+}
+
+// NewReader returns dcomp(r), ignoring fh and opts.
+func (dcomp Decompressor) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	return dcomp(r), nil
+}
+
+// defaultDeflateLevel is the level of the built-in Deflate Compressor.
+const defaultDeflateLevel = 5
+
+// DeflateCompressor returns a Compressor for the Deflate method, compressing
+// with the level from 1 (best speed) to 9 (best compression), or
+// flate.HuffmanOnly. The level -1 or 0 selects the default level 5.
+func DeflateCompressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = defaultDeflateLevel
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newFlateWriter(w, level)
 	}
-	return &pooledFlateWriter{fw: fw}
 }
 
-type pooledFlateWriter struct {
-	mu sync.Mutex // guards Close and Write
-	fw *flate.Writer
+// deflateMethod is the built-in MethodCompressor and MethodDecompressor
+// of Deflate, supporting a preset dictionary.
+type deflateMethod struct{}
+
+func (deflateMethod) NewWriter(w io.Writer, fh *FileHeader, opts *CompressOptions) (io.WriteCloser, error) {
+	level := opts.Level
+	if level == -1 || level == 0 {
+		level = defaultDeflateLevel
+	}
+	if opts.Dictionary != nil {
+		return flate.NewWriterDict(w, level, opts.Dictionary)
+	}
+	return newFlateWriter(w, level)
 }
 
-func (w *pooledFlateWriter) Write(p []byte) (n int, err error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	if w.fw == nil {
-		return 0, errors.New("Write after Close")
+func (deflateMethod) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	if opts.Dictionary != nil {
+		return flate.NewReaderDict(r, opts.Dictionary), nil
 	}
-	return w.fw.Write(p)
+	return newFlateReader(r), nil
 }
 
-func (w *pooledFlateWriter) Close() error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	var err error
-	if w.fw != nil {
-		err = w.fw.Close()
-		flateWriterPool.Put(w.fw)
-		w.fw = nil
+var flateWriterPools [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+
+func newFlateWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
 	}
-	return err
+	pool := &flateWriterPools[level-flate.HuffmanOnly]
+	fw, ok := pool.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(w)
+	} else {
+		fw, _ = flate.NewWriter(w, level)
+	}
+	return &pooledWriter{w: fw, pool: pool}, nil
 }
 
 var flateReaderPool sync.Pool
@@ -102,47 +176,483 @@ func (r *pooledFlateReader) Close() error {
 	return err
 }
 
+var deflate64ReaderPool sync.Pool
+
+func newDeflate64Reader(r io.Reader) io.ReadCloser {
+	dr, ok := deflate64ReaderPool.Get().(*deflate64.Reader)
+	if ok {
+		dr.Reset(r)
+	} else {
+		dr = deflate64.NewReader(r)
+	}
+	return &pooledReader{r: dr, pool: &deflate64ReaderPool}
+}
+
+func newShrinkReader(r io.Reader) io.ReadCloser {
+	return ioutil.NopCloser(legacy.NewShrinkReader(r))
+}
+
+// reduceMethod is the built-in MethodDecompressor of the Reduce method
+// of the compression factor.
+type reduceMethod int
+
+func (m reduceMethod) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	rr, err := legacy.NewReduceReader(r, int(m))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(rr), nil
+}
+
+// implodeMethod is the built-in MethodDecompressor of the Implode method,
+// whose variant of the data is given by the flags.
+type implodeMethod struct{}
+
+func (implodeMethod) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	largeDict := fh.Flags&FlagImplode8KDictionary != 0
+	literalTree := fh.Flags&FlagImplodeLiteralTree != 0
+	return ioutil.NopCloser(legacy.NewImplodeReader(r, largeDict, literalTree)), nil
+}
+
+// A resetWriter is a compressing writer which can be reused by Reset.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// pooledWriter puts its writer back to the pool when it is closed.
+type pooledWriter struct {
+	mu   sync.Mutex // guards Close and Write
+	w    resetWriter
+	pool *sync.Pool
+}
+
+func (w *pooledWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.w == nil {
+		return 0, errors.New("Write after Close")
+	}
+	return w.w.Write(p)
+}
+
+func (w *pooledWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	if w.w != nil {
+		err = w.w.Close()
+		w.pool.Put(w.w)
+		w.w = nil
+	}
+	return err
+}
+
+// A resetReader is a decompressing reader which can be reused by Reset.
+type resetReader interface {
+	io.Reader
+	Reset(r io.Reader)
+}
+
+// pooledReader puts its reader back to the pool when it is closed.
+type pooledReader struct {
+	mu   sync.Mutex // guards Close and Read
+	r    resetReader
+	pool *sync.Pool
+}
+
+func (r *pooledReader) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.r == nil {
+		return 0, errors.New("Read after Close")
+	}
+	return r.r.Read(p)
+}
+
+func (r *pooledReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.r != nil {
+		r.r.Reset(nil)
+		r.pool.Put(r.r)
+		r.r = nil
+	}
+	return nil
+}
+
+// Bzip2Compressor returns a Compressor for the Bzip2 method, compressing
+// with the level from 1 to 9, which selects blocks of level*100k bytes.
+// The level -1 or 0 selects the default level 9.
+func Bzip2Compressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = bzip2EX.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newBzip2Writer(w, level)
+	}
+}
+
+var bzip2WriterPools [bzip2EX.BestCompression + 1]sync.Pool
+
+func newBzip2Writer(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < bzip2EX.BestSpeed || level > bzip2EX.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
+	}
+	bw, ok := bzip2WriterPools[level].Get().(*bzip2EX.Writer)
+	if ok {
+		bw.Reset(w)
+	} else {
+		bw, _ = bzip2EX.NewWriterLevel(w, level)
+	}
+	return &pooledWriter{w: bw, pool: &bzip2WriterPools[level]}, nil
+}
+
+func newBzip2Reader(r io.Reader) io.ReadCloser {
+	return ioutil.NopCloser(bzip2.NewReader(r))
+}
+
+// LZMACompressor returns a Compressor for the LZMA method, compressing
+// with the level from 1 (best speed) to 9 (best compression).
+// The level -1 or 0 selects the default level 6.
+// The data ends with the end marker, as FlagLZMAEndMarker shows.
+func LZMACompressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = lzma.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newLZMAWriter(w, level)
+	}
+}
+
+var lzmaWriterPools [lzma.BestCompression + 1]sync.Pool
+
+func newLZMAWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < lzma.BestSpeed || level > lzma.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
+	}
+	lw, ok := lzmaWriterPools[level].Get().(*lzma.Writer)
+	if ok {
+		lw.Reset(w)
+	} else {
+		lw, _ = lzma.NewWriterLevel(w, level)
+	}
+	return &pooledWriter{w: lw, pool: &lzmaWriterPools[level]}, nil
+}
+
+var lzmaReaderPool sync.Pool
+
+func newLZMAReader(r io.Reader) io.ReadCloser {
+	lr, ok := lzmaReaderPool.Get().(*lzma.Reader)
+	if ok {
+		lr.Reset(r)
+	} else {
+		lr = lzma.NewReader(r)
+	}
+	return &pooledReader{r: lr, pool: &lzmaReaderPool}
+}
+
+// XZCompressor returns a Compressor for the XZ method, compressing
+// with the level from 1 (best speed) to 9 (best compression).
+// The level -1 or 0 selects the default level 6.
+func XZCompressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = lzma.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newXZWriter(w, level)
+	}
+}
+
+var xzWriterPools [lzma.BestCompression + 1]sync.Pool
+
+func newXZWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < lzma.BestSpeed || level > lzma.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
+	}
+	xw, ok := xzWriterPools[level].Get().(*lzma.XZWriter)
+	if ok {
+		xw.Reset(w)
+	} else {
+		xw, _ = lzma.NewXZWriterLevel(w, level)
+	}
+	return &pooledWriter{w: xw, pool: &xzWriterPools[level]}, nil
+}
+
+var xzReaderPool sync.Pool
+
+func newXZReader(r io.Reader) io.ReadCloser {
+	xr, ok := xzReaderPool.Get().(*lzma.XZReader)
+	if ok {
+		xr.Reset(r)
+	} else {
+		xr = lzma.NewXZReader(r)
+	}
+	return &pooledReader{r: xr, pool: &xzReaderPool}
+}
+
+// ZstdCompressor returns a Compressor for the Zstd method, compressing
+// with the level from 1 (best speed) to 22 (best compression).
+// The level -1 or 0 selects the default level 3.
+//
+// The level of the following files of a Writer is chosen with
+//
+//	w.RegisterCompressor(zip.Zstd, zip.ZstdCompressor(19))
+func ZstdCompressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = zstd.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newZstdWriter(w, level)
+	}
+}
+
+var zstdWriterPools [zstd.BestCompression + 1]sync.Pool
+
+func newZstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < zstd.BestSpeed || level > zstd.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
+	}
+	zw, ok := zstdWriterPools[level].Get().(*zstd.Writer)
+	if ok {
+		zw.Reset(w)
+	} else {
+		zw, _ = zstd.NewWriterLevel(w, level)
+	}
+	return &pooledWriter{w: zw, pool: &zstdWriterPools[level]}, nil
+}
+
+var zstdReaderPool sync.Pool
+
+func newZstdReader(r io.Reader) io.ReadCloser {
+	zr, ok := zstdReaderPool.Get().(*zstd.Reader)
+	if ok {
+		zr.Reset(r)
+	} else {
+		zr = zstd.NewReader(r)
+	}
+	return &pooledReader{r: zr, pool: &zstdReaderPool}
+}
+
+// PPMdCompressor returns a Compressor for the PPMd method, compressing
+// with the level from 1 (best speed) to 9 (best compression).
+// The higher levels use the higher model orders and more memory,
+// up to 128 MiB for compression and decompression alike.
+// The level -1 or 0 selects the default level 5.
+func PPMdCompressor(level int) Compressor {
+	if level == -1 || level == 0 {
+		level = ppmd.DefaultCompression
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newPPMdWriter(w, level)
+	}
+}
+
+var ppmdWriterPools [ppmd.BestCompression + 1]sync.Pool
+
+func newPPMdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < ppmd.BestSpeed || level > ppmd.BestCompression {
+		return nil, errors.New("zip: invalid compression level")
+	}
+	pw, ok := ppmdWriterPools[level].Get().(*ppmd.Writer)
+	if ok {
+		pw.Reset(w)
+	} else {
+		pw, _ = ppmd.NewWriterLevel(w, level)
+	}
+	return &pooledWriter{w: pw, pool: &ppmdWriterPools[level]}, nil
+}
+
+var ppmdReaderPool sync.Pool
+
+func newPPMdReader(r io.Reader) io.ReadCloser {
+	pr, ok := ppmdReaderPool.Get().(*ppmd.Reader)
+	if ok {
+		pr.Reset(r)
+	} else {
+		pr = ppmd.NewReader(r)
+	}
+	return &pooledReader{r: pr, pool: &ppmdReaderPool}
+}
+
+// levelMethod is the built-in MethodCompressor of a method with levels,
+// other than Deflate.
+type levelMethod uint16
+
+func (m levelMethod) NewWriter(w io.Writer, fh *FileHeader, opts *CompressOptions) (io.WriteCloser, error) {
+	return levelCompressor(uint16(m), opts.Level)(w)
+}
+
+// headerMethod is the built-in MethodDecompressor of a method other than
+// Deflate. The first byte is read in advance, so the errors of the stream
+// header are returned by NewReader rather than by the first Read.
+type headerMethod uint16
+
+func (m headerMethod) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	method := uint16(m)
+	switch method {
+	case Deflate64:
+		rc = newDeflate64Reader(r)
+	case Bzip2:
+		rc = newBzip2Reader(r)
+	case LZMA:
+		rc = newLZMAReader(r)
+	case XZ:
+		rc = newXZReader(r)
+	case Zstd:
+		rc = newZstdReader(r)
+	case PPMd:
+		rc = newPPMdReader(r)
+	default:
+		return nil, ErrAlgorithm
+	}
+	if fh.UncompressedSize64 == 0 && (method == PPMd || method == LZMA && fh.Flags&FlagLZMAEndMarker == 0) {
+		// Without the end marker, empty data is never decoded.
+		return rc, nil
+	}
+	pr := &peekReader{ReadCloser: rc}
+	if _, err := io.ReadFull(rc, pr.buf[:]); err == io.EOF {
+		pr.err = err
+	} else if err != nil {
+		rc.Close()
+		return nil, err
+	} else {
+		pr.peeked = true
+	}
+	return pr, nil
+}
+
+// peekReader returns the byte read in advance before the rest of the data.
+type peekReader struct {
+	io.ReadCloser
+	buf    [1]byte
+	peeked bool
+	err    error
+}
+
+func (r *peekReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if !r.peeked {
+		return r.ReadCloser.Read(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0] = r.buf[0]
+	r.peeked = false
+	return 1, nil
+}
+
 var (
-	compressors   sync.Map // map[uint16]Compressor
-	decompressors sync.Map // map[uint16]Decompressor
+	compressors   sync.Map // map[uint16]MethodCompressor
+	decompressors sync.Map // map[uint16]MethodDecompressor
 )
 
 func init() {
 	compressors.Store(Store, Compressor(func(w io.Writer) (io.WriteCloser, error) { return &nopCloser{w}, nil }))
-	compressors.Store(Deflate, Compressor(func(w io.Writer) (io.WriteCloser, error) { return newFlateWriter(w), nil }))
+	compressors.Store(Deflate, deflateMethod{})
+	compressors.Store(Bzip2, levelMethod(Bzip2))
+	compressors.Store(LZMA, levelMethod(LZMA))
+	compressors.Store(XZ, levelMethod(XZ))
+	compressors.Store(Zstd, levelMethod(Zstd))
+	compressors.Store(PPMd, levelMethod(PPMd))
 
 	decompressors.Store(Store, Decompressor(ioutil.NopCloser))
-	decompressors.Store(Deflate, Decompressor(newFlateReader))
+	decompressors.Store(Shrink, Decompressor(newShrinkReader))
+	decompressors.Store(Reduce1, reduceMethod(1))
+	decompressors.Store(Reduce2, reduceMethod(2))
+	decompressors.Store(Reduce3, reduceMethod(3))
+	decompressors.Store(Reduce4, reduceMethod(4))
+	decompressors.Store(Implode, implodeMethod{})
+	decompressors.Store(Deflate, deflateMethod{})
+	decompressors.Store(Deflate64, headerMethod(Deflate64))
+	decompressors.Store(Bzip2, headerMethod(Bzip2))
+	decompressors.Store(LZMA, headerMethod(LZMA))
+	decompressors.Store(XZ, headerMethod(XZ))
+	decompressors.Store(Zstd, headerMethod(Zstd))
+	decompressors.Store(PPMd, headerMethod(PPMd))
 }
 
 // RegisterDecompressor allows custom decompressors for a specified method ID.
-// The common methods Store and Deflate are built in.
+// The common methods Store, Deflate, Deflate64, Bzip2, LZMA, XZ, Zstd and
+// PPMd, and the legacy methods Shrink, Reduce1 to Reduce4 and Implode are built in.
 func RegisterDecompressor(method uint16, dcomp Decompressor) {
+	RegisterMethodDecompressor(method, dcomp)
+}
+
+// RegisterMethodDecompressor is like RegisterDecompressor,
+// for a MethodDecompressor.
+func RegisterMethodDecompressor(method uint16, dcomp MethodDecompressor) {
 	if _, dup := decompressors.LoadOrStore(method, dcomp); dup {
 		panic("decompressor already registered")
 	}
 }
 
 // RegisterCompressor registers custom compressors for a specified method ID.
-// The common methods Store and Deflate are built in.
+// The common methods Store, Deflate, Bzip2, LZMA, XZ, Zstd and PPMd
+// are built in.
 func RegisterCompressor(method uint16, comp Compressor) {
+	RegisterMethodCompressor(method, comp)
+}
+
+// RegisterMethodCompressor is like RegisterCompressor,
+// for a MethodCompressor.
+func RegisterMethodCompressor(method uint16, comp MethodCompressor) {
 	if _, dup := compressors.LoadOrStore(method, comp); dup {
 		panic("compressor already registered")
 	}
 }
 
-func compressor(method uint16) Compressor {
+// validLevel reports whether level is a compression level of the method.
+func validLevel(method uint16, level int) bool {
+	switch method {
+	case Bzip2:
+		return -1 <= level && level <= bzip2EX.BestCompression
+	case LZMA, XZ:
+		return -1 <= level && level <= lzma.BestCompression
+	case Zstd:
+		return -1 <= level && level <= zstd.BestCompression
+	case PPMd:
+		return -1 <= level && level <= ppmd.BestCompression
+	}
+	return flate.HuffmanOnly <= level && level <= flate.BestCompression
+}
+
+// levelCompressor returns the built-in Compressor of the method
+// compressing with the level, or nil if the method has no levels.
+func levelCompressor(method uint16, level int) Compressor {
+	switch method {
+	case Deflate:
+		return DeflateCompressor(level)
+	case Bzip2:
+		return Bzip2Compressor(level)
+	case LZMA:
+		return LZMACompressor(level)
+	case XZ:
+		return XZCompressor(level)
+	case Zstd:
+		return ZstdCompressor(level)
+	case PPMd:
+		return PPMdCompressor(level)
+	}
+	return nil
+}
+
+func compressor(method uint16) MethodCompressor {
 	ci, ok := compressors.Load(method)
 	if !ok {
 		return nil
 	}
-	return ci.(Compressor)
+	return ci.(MethodCompressor)
 }
 
-func decompressor(method uint16) Decompressor {
+func decompressor(method uint16) MethodDecompressor {
 	di, ok := decompressors.Load(method)
 	if !ok {
 		return nil
 	}
-	return di.(Decompressor)
+	return di.(MethodDecompressor)
 }
@@ -0,0 +1,158 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import "errors"
+
+// A Savepoint is a state of an Updater, created by Updater.Savepoint.
+type Savepoint struct {
+	entries []entry
+	comment string
+}
+
+// Savepoint records the current files, their order, headers and
+// contents, and the comment, so that RollbackTo can restore them.
+// The pending entries are shared with the Updater, not copied.
+//
+// Savepoint must not be called while a writer returned by Create or
+// Update is open. Savepoints are discarded by SaveInPlace, Save,
+// Cancel and Close.
+func (u *Updater) Savepoint() *Savepoint {
+	sp := &Savepoint{
+		entries: make([]entry, len(u.entries)),
+		comment: u.Comment,
+	}
+	for i, e := range u.entries {
+		sp.entries[i] = e.copy()
+	}
+	u.savepoints = append(u.savepoints, sp)
+	return sp
+}
+
+// RollbackTo restores the state recorded by sp, undoing the later
+// changes. The savepoints created after sp are discarded; sp itself
+// can be used again.
+func (u *Updater) RollbackTo(sp *Savepoint) error {
+	i := u.savepointIndex(sp)
+	if i < 0 {
+		return errors.New("zip: invalid savepoint")
+	}
+
+	var bufs []EntryBuffer
+	for _, e := range u.entries {
+		bufs = append(bufs, e.buf)
+	}
+	for _, later := range u.savepoints[i+1:] {
+		for _, e := range later.entries {
+			bufs = append(bufs, e.buf)
+		}
+	}
+	u.savepoints = u.savepoints[:i+1]
+
+	u.entries = make([]*entry, 0, len(sp.entries))
+	u.names = make(map[string][]*entry, len(sp.entries))
+	u.handles = make(map[Handle]*entry, len(sp.entries))
+	for _, c := range sp.entries {
+		e := c.copy()
+		u.entries = append(u.entries, &e)
+		u.names[e.header.Name] = append(u.names[e.header.Name], &e)
+		u.handles[e.id] = &e
+	}
+	u.Comment = sp.comment
+
+	u.releaseBuffers(bufs...)
+	return nil
+}
+
+// ReleaseSavepoint discards sp and the savepoints created after it,
+// releasing the pending entries used only by them.
+func (u *Updater) ReleaseSavepoint(sp *Savepoint) error {
+	i := u.savepointIndex(sp)
+	if i < 0 {
+		return errors.New("zip: invalid savepoint")
+	}
+
+	var bufs []EntryBuffer
+	for _, later := range u.savepoints[i:] {
+		for _, e := range later.entries {
+			bufs = append(bufs, e.buf)
+		}
+	}
+	u.savepoints = u.savepoints[:i]
+	u.releaseBuffers(bufs...)
+	return nil
+}
+
+func (u *Updater) savepointIndex(sp *Savepoint) int {
+	for i, v := range u.savepoints {
+		if v == sp {
+			return i
+		}
+	}
+	return -1
+}
+
+// copy returns a copy of e with its own header.
+func (e *entry) copy() entry {
+	c := *e
+	fh := *e.header
+	fh.Extra = append([]byte(nil), e.header.Extra...)
+	c.header = &fh
+	return c
+}
+
+// releaseBuffer closes buf, which is no longer used by the files,
+// unless a savepoint uses it. A nil buf is ignored.
+func (u *Updater) releaseBuffer(buf EntryBuffer) {
+	if len(u.savepoints) > 0 {
+		u.releaseBuffers(buf)
+	} else if buf != nil {
+		buf.Close()
+	}
+}
+
+// releaseBuffers closes the buffers that are not used by the files
+// or by the savepoints. Nil buffers are ignored.
+func (u *Updater) releaseBuffers(bufs ...EntryBuffer) {
+	used := make(map[EntryBuffer]bool)
+	for _, e := range u.entries {
+		used[e.buf] = true
+	}
+	for _, sp := range u.savepoints {
+		for _, e := range sp.entries {
+			used[e.buf] = true
+		}
+	}
+	for _, buf := range bufs {
+		if buf == nil || used[buf] {
+			continue
+		}
+		used[buf] = true // close once
+		buf.Close()
+	}
+}
+
+// replaceSource records in the savepoints that the contents of src,
+// which can be read only once, are now in the pending entry of e.
+func (u *Updater) replaceSource(src *entrySource, e *entry) {
+	for _, sp := range u.savepoints {
+		for i := range sp.entries {
+			c := &sp.entries[i]
+			if c.source != src {
+				continue
+			}
+			c.buf = e.buf
+			c.source = nil
+			c.header.ReaderVersion = e.header.ReaderVersion
+			c.header.Flags = c.header.Flags&^dataFlags | e.header.Flags&dataFlags
+			c.header.Method = e.header.Method
+			c.header.CRC32 = e.header.CRC32
+			c.header.CompressedSize = e.header.CompressedSize
+			c.header.CompressedSize64 = e.header.CompressedSize64
+			c.header.UncompressedSize = e.header.UncompressedSize
+			c.header.UncompressedSize64 = e.header.UncompressedSize64
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUpdaterSavepoint(t *testing.T) {
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+	updatefile1 := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+	updatefile2 := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("second update"),
+	}
+
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+	z.SetStorage(NewSpillStorage(0, dir))
+
+	sp1 := z.Savepoint()
+	testAddFile(t, z, addfile)
+	testUpdateFile(t, z, updatefile1)
+
+	sp2 := z.Savepoint()
+	testUpdateFile(t, z, updatefile2)
+	if err := z.Remove(addfile.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Rename("hello", "hello2"); err != nil {
+		t.Fatal(err)
+	}
+	z.Comment = "changed"
+
+	// the pending entries are kept for sp2
+	if n := testCountFiles(t, dir); n != 3 {
+		t.Fatalf("temporary file count=%d, want %d", n, 3)
+	}
+
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	testcase[1] = updatefile1
+	testcase = append(testcase, addfile)
+
+	// rollback twice to sp2
+	for i := 0; i < 2; i++ {
+		if err := z.RollbackTo(sp2); err != nil {
+			t.Fatal(err)
+		}
+		compareContents(t, z, testcase)
+		if z.Comment != updateTest.Comment {
+			t.Fatalf("comment=%q, want %q", z.Comment, updateTest.Comment)
+		}
+		if err := z.Rename("hello", "hello2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := testCountFiles(t, dir); n != 2 {
+		t.Fatalf("temporary file count=%d, want %d", n, 2)
+	}
+
+	// rollback to sp1 discards sp2
+	if err := z.RollbackTo(sp1); err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, z, updateTest.File)
+	if n := testCountFiles(t, dir); n != 0 {
+		t.Fatalf("temporary file count=%d, want %d", n, 0)
+	}
+	if err := z.RollbackTo(sp2); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, updateTest.File)
+}
+
+func TestUpdaterSavepointSource(t *testing.T) {
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	if err := z.CreateFrom(addfile.Name, bytes.NewReader(addfile.Content), nil); err != nil {
+		t.Fatal(err)
+	}
+	sp := z.Savepoint()
+
+	// the reader is read once, and kept for sp
+	compareContent(t, z, addfile)
+	if err := z.Remove(addfile.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RollbackTo(sp); err != nil {
+		t.Fatal(err)
+	}
+	compareContent(t, z, addfile)
+
+	if err := z.ReleaseSavepoint(sp); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RollbackTo(sp); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, append(updateTest.File[:len(updateTest.File):len(updateTest.File)], addfile))
+}
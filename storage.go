@@ -0,0 +1,170 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	bytesEX "github.com/hidez8891/zip/internal/bytes"
+)
+
+// DefaultSpillThreshold is the number of bytes of an entry that the
+// default storage of Updater keeps in memory before using a temporary file.
+const DefaultSpillThreshold = 32 << 20
+
+// An EntryStorage creates the buffers that hold the entries written by
+// Updater.Create and Updater.Update until they are saved.
+type EntryStorage interface {
+	NewBuffer() (EntryBuffer, error)
+}
+
+// An EntryBuffer holds the data of a pending entry.
+// The Close method releases the buffer and any associated resources.
+type EntryBuffer interface {
+	io.Writer
+	io.WriterAt
+	io.ReaderAt
+	io.Closer
+
+	// Size returns the number of bytes written to the buffer.
+	Size() int64
+}
+
+// NewMemoryStorage returns an EntryStorage that keeps entries in memory.
+func NewMemoryStorage() EntryStorage {
+	return memoryStorage{}
+}
+
+type memoryStorage struct{}
+
+func (memoryStorage) NewBuffer() (EntryBuffer, error) {
+	return new(memoryBuffer), nil
+}
+
+type memoryBuffer struct {
+	bytesEX.BufferAt
+}
+
+func (b *memoryBuffer) Size() int64 { return int64(b.Len()) }
+
+func (b *memoryBuffer) Close() error {
+	b.Reset()
+	return nil
+}
+
+// NewSpillStorage returns an EntryStorage that keeps up to threshold
+// bytes of each entry in memory, and moves the entry to a temporary
+// file in dir when it grows larger. If dir is the empty string,
+// the default directory for temporary files is used.
+func NewSpillStorage(threshold int64, dir string) EntryStorage {
+	return &spillStorage{threshold: threshold, dir: dir}
+}
+
+type spillStorage struct {
+	threshold int64
+	dir       string
+}
+
+func (s *spillStorage) NewBuffer() (EntryBuffer, error) {
+	return &spillBuffer{threshold: s.threshold, dir: s.dir}, nil
+}
+
+// spillBuffer is an EntryBuffer that starts in memory
+// and moves to a temporary file beyond its threshold.
+type spillBuffer struct {
+	threshold int64
+	dir       string
+	mem       bytesEX.BufferAt
+	file      *os.File // non-nil once spilled
+	size      int64
+	closed    bool
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	n, err := b.WriteAt(p, b.size)
+	return n, err
+}
+
+func (b *spillBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if b.closed {
+		return 0, errors.New("zip: write to closed buffer")
+	}
+	end := off + int64(len(p))
+	if b.file == nil && end > b.threshold {
+		if err := b.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.WriteAt(p, off)
+	} else {
+		n, err = b.mem.WriteAt(p, off)
+	}
+	if end := off + int64(n); end > b.size {
+		b.size = end
+	}
+	return n, err
+}
+
+// spill moves the buffered data to a temporary file.
+func (b *spillBuffer) spill() error {
+	f, err := ioutil.TempFile(b.dir, "zip-entry-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.mem.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	b.file = f
+	b.mem = bytesEX.BufferAt{}
+	return nil
+}
+
+func (b *spillBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if b.closed {
+		return 0, errors.New("zip: read from closed buffer")
+	}
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	if rest := b.size - off; int64(len(p)) > rest {
+		n, err := b.ReadAt(p[:rest], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	if b.file != nil {
+		return b.file.ReadAt(p, off)
+	}
+	return b.mem.ReadAt(p, off)
+}
+
+func (b *spillBuffer) Size() int64 { return b.size }
+
+func (b *spillBuffer) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.mem = bytesEX.BufferAt{}
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if err1 := os.Remove(b.file.Name()); err == nil {
+		err = err1
+	}
+	b.file = nil
+	return err
+}
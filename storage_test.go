@@ -0,0 +1,110 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf, err := NewSpillStorage(8, dir).NewBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := buf.Write([]byte("0123")); err != nil {
+		t.Fatal(err)
+	}
+	if n := testCountFiles(t, dir); n != 0 {
+		t.Fatalf("temporary file count=%d, want %d", n, 0)
+	}
+
+	if _, err := buf.Write([]byte("456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buf.WriteAt([]byte("ab"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if n := testCountFiles(t, dir); n != 1 {
+		t.Fatalf("temporary file count=%d, want %d", n, 1)
+	}
+	if buf.Size() != 10 {
+		t.Fatalf("size=%d, want %d", buf.Size(), 10)
+	}
+
+	p := make([]byte, 12)
+	n, err := buf.ReadAt(p, 0)
+	if err != io.EOF {
+		t.Fatalf("read error=%v, want %v", err, io.EOF)
+	}
+	if get, want := string(p[:n]), "0ab3456789"; get != want {
+		t.Fatalf("read=%q, want %q", get, want)
+	}
+
+	if err := buf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testCountFiles(t, dir); n != 0 {
+		t.Fatalf("temporary file count=%d, want %d", n, 0)
+	}
+}
+
+func TestUpdaterSpillStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string, long enough to be stored in a temporary file"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+	z.SetStorage(NewSpillStorage(16, dir))
+
+	// add file
+	testAddFile(t, z, addfile)
+	if n := testCountFiles(t, dir); n != 1 {
+		t.Fatalf("temporary file count=%d, want %d", n, 1)
+	}
+
+	// check file
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	testcase = append(testcase, addfile)
+	compareContents(t, z, testcase)
+
+	// release
+	if err := z.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if n := testCountFiles(t, dir); n != 0 {
+		t.Fatalf("temporary file count=%d, want %d", n, 0)
+	}
+}
+
+func testCountFiles(t *testing.T, dir string) int {
+	t.Helper()
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(fis)
+}
@@ -7,7 +7,9 @@ Package zip provides support for reading and writing ZIP archives.
 
 See: https://www.pkware.com/appnote
 
-This package does not support disk spanning.
+Split and spanned archives (.z01, .z02, ..., .zip) can be read with
+NewMultiVolumeReader and OpenMultiVolumeReader, and written with
+NewSplitWriter and CreateMultiVolumeWriter.
 
 A note about ZIP64:
 
@@ -27,10 +29,27 @@ import (
 
 // Compression methods.
 const (
-	Store   uint16 = 0 // no compression
-	Deflate uint16 = 8 // DEFLATE compressed
+	Store     uint16 = 0  // no compression
+	Shrink    uint16 = 1  // shrunk (decompression only)
+	Reduce1   uint16 = 2  // reduced with compression factor 1 (decompression only)
+	Reduce2   uint16 = 3  // reduced with compression factor 2 (decompression only)
+	Reduce3   uint16 = 4  // reduced with compression factor 3 (decompression only)
+	Reduce4   uint16 = 5  // reduced with compression factor 4 (decompression only)
+	Implode   uint16 = 6  // imploded (decompression only)
+	Deflate   uint16 = 8  // DEFLATE compressed
+	Deflate64 uint16 = 9  // Deflate64 compressed (decompression only)
+	Bzip2     uint16 = 12 // bzip2 compressed
+	LZMA      uint16 = 14 // LZMA compressed
+	Zstd      uint16 = 93 // Zstandard compressed
+	XZ        uint16 = 95 // XZ compressed
+	PPMd      uint16 = 98 // PPMd variant I compressed
 )
 
+// SelectMethod is not a compression method. As the Method of the
+// FileHeader given to Writer.CreateHeader, it selects the method chosen
+// by the MethodSelector of the Writer, or Deflate if none is set.
+const SelectMethod uint16 = 0xffff
+
 const (
 	fileHeaderSignature      = 0x04034b50
 	directoryHeaderSignature = 0x02014b50
@@ -38,6 +57,8 @@ const (
 	directory64LocSignature  = 0x07064b50
 	directory64EndSignature  = 0x06064b50
 	dataDescriptorSignature  = 0x08074b50 // de-facto standard; required by OS X Finder
+	splitArchiveSignature    = 0x08074b50 // first bytes of a split archive
+	spanningMarkerSignature  = 0x30304b50 // split archive that fit in a single volume
 	fileHeaderLen            = 30         // + filename + extra
 	directoryHeaderLen       = 46         // + filename + extra + comment
 	directoryEndLen          = 22         // + comment
@@ -56,6 +77,8 @@ const (
 	// Version numbers.
 	zipVersion20 = 20 // 2.0
 	zipVersion45 = 45 // 4.5 (reads and writes zip64 archives)
+	zipVersion46 = 46 // 4.6 (bzip2)
+	zipVersion63 = 63 // 6.3 (LZMA, PPMd, Zstandard, ...)
 
 	// Limits for non zip64 files.
 	uint16max = (1 << 16) - 1
@@ -115,6 +138,19 @@ type FileHeader struct {
 	// Method is the compression method. If zero, Store is used.
 	Method uint16
 
+	// Level is the compression level of Method, as accepted by the
+	// Compressor functions of the method such as DeflateCompressor.
+	// For every method and every function of this package taking a level,
+	// zero and -1 select the default level of the method. Thus Deflate has
+	// no level storing the data uncompressed, flate.NoCompression: Store
+	// is the method for that.
+	// When writing, a non-zero Level is given to the MethodCompressor of
+	// the method, or selects the built-in Compressor of the level in place
+	// of a registered Compressor function; the methods without levels
+	// ignore it. Level is not stored in the archive, except for
+	// Deflate, whose level class is recorded in the flag bits 1 and 2.
+	Level int
+
 	// Modified is the modified time of the file.
 	//
 	// When reading, an extended timestamp is preferred over the legacy MS-DOS
@@ -183,12 +219,12 @@ func FileInfoHeader(fi os.FileInfo) (*FileHeader, error) {
 }
 
 type directoryEnd struct {
-	diskNbr            uint32 // unused
-	dirDiskNbr         uint32 // unused
+	diskNbr            uint32 // number of this disk
+	dirDiskNbr         uint32 // number of the disk with the start of the central directory
 	dirRecordsThisDisk uint64 // unused
 	directoryRecords   uint64
 	directorySize      uint64
-	directoryOffset    uint64 // relative to file
+	directoryOffset    uint64 // relative to file (or to the first disk)
 	commentLen         uint16
 	comment            string
 }
@@ -307,6 +343,18 @@ func (fh *FileHeader) isZip64() bool {
 	return fh.CompressedSize64 >= uint32max || fh.UncompressedSize64 >= uint32max
 }
 
+// methodVersion returns the version needed to extract
+// the files compressed with the method.
+func methodVersion(method uint16) uint16 {
+	switch method {
+	case Bzip2:
+		return zipVersion46
+	case LZMA, XZ, Zstd, PPMd:
+		return zipVersion63
+	}
+	return zipVersion20
+}
+
 func msdosModeToFileMode(m uint32) (mode os.FileMode) {
 	if m&msdosDir != 0 {
 		mode = os.ModeDir | 0777
@@ -9,8 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
-
-	bytesEX "github.com/hidez8891/zip/internal/bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // A WriteCloser implements the io.WriteCloser
@@ -30,12 +35,47 @@ func (w *WriteCloser) Close() error {
 }
 
 // Updater provides editing of zip files.
+//
+// An archive may contain several files with the same name. The methods
+// taking a name use the last of them; the methods taking a Handle can
+// address each of them.
 type Updater struct {
-	files   []string
-	headers map[string]*FileHeader
-	entries map[string]*bytesEX.BufferAt
-	r       *Reader
-	Comment string
+	entries    []*entry            // in file order
+	names      map[string][]*entry // entries by name, in file order
+	handles    map[Handle]*entry
+	nextID     Handle
+	initial    []Handle // handles of the files of r
+	savepoints []*Savepoint
+	storage    EntryStorage
+	selector   MethodSelector
+	minSaving  float64
+	r          *Reader
+	size       int64
+	file       *os.File // opened by OpenUpdater
+	path       string   // name of file
+	Comment    string
+
+	compressors   map[uint16]MethodCompressor
+	decompressors map[uint16]MethodDecompressor
+}
+
+// A Handle identifies a file of an Updater, even among files with the
+// same name. It stays valid until the file is removed, including across
+// Rename, Sort and SaveInPlace.
+type Handle int
+
+// An entry is a file of the Updater.
+// Its contents are in buf, origin or source.
+type entry struct {
+	id     Handle
+	header *FileHeader
+	buf    EntryBuffer  // pending data written by Create or Update
+	origin *File        // data in the opened or a merged archive
+	orig   *File        // file of the opened archive, or nil if added
+	source *entrySource // contents read at SaveAs
+
+	recompress bool // compress again at SaveAs, with level
+	level      int
 }
 
 // NewUpdater returns a new Updater from r and size.
@@ -45,69 +85,258 @@ func NewUpdater(r io.ReaderAt, size int64) (*Updater, error) {
 		return nil, err
 	}
 
-	files := make([]string, len(zr.File))
-	headers := make(map[string]*FileHeader, len(zr.File))
-	for i, zf := range zr.File {
-		files[i] = zf.Name
-		headers[zf.Name] = &zf.FileHeader
+	u := &Updater{
+		storage: NewSpillStorage(DefaultSpillThreshold, ""),
+	}
+	u.init(zr, size, nil)
+	return u, nil
+}
+
+// OpenUpdater opens the zip file name for editing.
+// The changes can be written back to the file with Save.
+func OpenUpdater(name string) (*Updater, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	u, err := NewUpdater(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	u.file = f
+	u.path = name
+	return u, nil
+}
+
+// SetStorage sets the storage of the entries written by Create and Update.
+// By default, entries larger than DefaultSpillThreshold are stored in
+// temporary files. It affects only the entries created after the call.
+func (u *Updater) SetStorage(s EntryStorage) {
+	u.storage = s
+}
+
+// SetMethodSelector sets the MethodSelector consulted by Create,
+// as by Writer.SetMethodSelector.
+func (u *Updater) SetMethodSelector(sel MethodSelector) {
+	u.selector = sel
+}
+
+// SetAdaptive sets the adaptive method selection of the files written by
+// Create, as by Writer.SetAdaptive.
+func (u *Updater) SetAdaptive(minSaving float64) {
+	u.minSaving = minSaving
+}
+
+// RegisterCompressor registers or overrides a custom compressor for a
+// specific method ID, used by the Writers of Create, Update and SaveAs.
+// If a compressor for a given method is not found, Updater will default
+// to looking up the compressor at the package level.
+func (u *Updater) RegisterCompressor(method uint16, comp Compressor) {
+	if comp == nil {
+		u.RegisterMethodCompressor(method, nil)
+		return
+	}
+	u.RegisterMethodCompressor(method, comp)
+}
+
+// RegisterMethodCompressor is like RegisterCompressor,
+// for a MethodCompressor.
+func (u *Updater) RegisterMethodCompressor(method uint16, comp MethodCompressor) {
+	if u.compressors == nil {
+		u.compressors = make(map[uint16]MethodCompressor)
+	}
+	u.compressors[method] = comp
+}
+
+// RegisterDecompressor registers or overrides a custom decompressor for a
+// specific method ID, used by the Readers of the opened archive and of the
+// pending entries. If a decompressor for a given method is not found,
+// Updater will default to looking up the decompressor at the package level.
+func (u *Updater) RegisterDecompressor(method uint16, dcomp Decompressor) {
+	if dcomp == nil {
+		u.RegisterMethodDecompressor(method, nil)
+		return
 	}
+	u.RegisterMethodDecompressor(method, dcomp)
+}
 
-	return &Updater{
-		files:   files,
-		headers: headers,
-		entries: make(map[string]*bytesEX.BufferAt),
-		r:       zr,
-		Comment: zr.Comment,
-	}, nil
+// RegisterMethodDecompressor is like RegisterDecompressor,
+// for a MethodDecompressor.
+func (u *Updater) RegisterMethodDecompressor(method uint16, dcomp MethodDecompressor) {
+	if u.decompressors == nil {
+		u.decompressors = make(map[uint16]MethodDecompressor)
+	}
+	u.decompressors[method] = dcomp
+	if u.r != nil {
+		u.r.RegisterMethodDecompressor(method, dcomp)
+	}
+}
+
+// newWriter returns a new Writer writing to w,
+// with the compressors registered to u.
+func (u *Updater) newWriter(w io.Writer) *Writer {
+	z := NewWriter(w)
+	for method, comp := range u.compressors {
+		z.RegisterMethodCompressor(method, comp)
+	}
+	return z
+}
+
+// newReader returns a new Reader reading r of size,
+// with the decompressors registered to u.
+func (u *Updater) newReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for method, dcomp := range u.decompressors {
+		zr.RegisterMethodDecompressor(method, dcomp)
+	}
+	return zr, nil
+}
+
+func (u *Updater) compressor(method uint16) MethodCompressor {
+	comp := u.compressors[method]
+	if comp == nil {
+		comp = compressor(method)
+	}
+	return comp
+}
+
+func (u *Updater) decompressor(method uint16) MethodDecompressor {
+	dcomp := u.decompressors[method]
+	if dcomp == nil {
+		dcomp = decompressor(method)
+	}
+	return dcomp
+}
+
+// init starts editing zr. If handles is not nil, it holds the handles
+// of the files of zr; otherwise new handles are used.
+func (u *Updater) init(zr *Reader, size int64, handles []Handle) {
+	u.entries = make([]*entry, 0, len(zr.File))
+	u.names = make(map[string][]*entry, len(zr.File))
+	u.handles = make(map[Handle]*entry, len(zr.File))
+	for i, zf := range zr.File {
+		// Edit a copy, so that the File still describes
+		// the entry stored in the archive.
+		fh := zf.FileHeader
+		fh.Extra = removeExtra(fh.Extra, zip64ExtraID) // regenerated by Writer
+		e := &entry{header: &fh, origin: zf, orig: zf}
+		u.add(e)
+		if handles != nil {
+			delete(u.handles, e.id)
+			e.id = handles[i]
+			u.handles[e.id] = e
+		}
+	}
+	u.initial = u.Handles()
+	u.r = zr
+	u.size = size
+	u.Comment = zr.Comment
 }
 
 // Files returns a FileHeader list.
 func (u *Updater) Files() []*FileHeader {
-	files := make([]*FileHeader, len(u.files))
-	for i, name := range u.files {
-		files[i] = u.headers[name]
+	files := make([]*FileHeader, len(u.entries))
+	for i, e := range u.entries {
+		files[i] = e.header
 	}
 	return files
 }
 
+// Handles returns the handles of the files, in the order of Files.
+func (u *Updater) Handles() []Handle {
+	handles := make([]Handle, len(u.entries))
+	for i, e := range u.entries {
+		handles[i] = e.id
+	}
+	return handles
+}
+
+// Lookup returns the handles of the files named name, in file order.
+func (u *Updater) Lookup(name string) []Handle {
+	var handles []Handle
+	for _, e := range u.names[name] {
+		handles = append(handles, e.id)
+	}
+	return handles
+}
+
+// Header returns the FileHeader of the file h, or nil if there is none.
+func (u *Updater) Header(h Handle) *FileHeader {
+	if e, ok := u.handles[h]; ok {
+		return e.header
+	}
+	return nil
+}
+
 // Open returns a ReadCloser that provides access to the File's contents.
 func (u *Updater) Open(name string) (io.ReadCloser, error) {
-	if _, ok := u.headers[name]; !ok {
+	e := u.lookup(name)
+	if e == nil {
 		return nil, errors.New("File not found")
 	}
+	return u.open(e)
+}
 
-	if buf, ok := u.entries[name]; ok {
-		b := buf.Bytes()
-		z, err := NewReader(bytes.NewReader(b), int64(len(b)))
-		if err != nil {
+// OpenHandle is like Open, for the file h.
+func (u *Updater) OpenHandle(h Handle) (io.ReadCloser, error) {
+	e, ok := u.handles[h]
+	if !ok {
+		return nil, errors.New("File not found")
+	}
+	return u.open(e)
+}
+
+func (u *Updater) open(e *entry) (io.ReadCloser, error) {
+	if e.source != nil {
+		if e.source.reopen {
+			return e.source.open()
+		}
+		// The source can be read only once: keep its content.
+		if err := u.bufferSource(e); err != nil {
 			return nil, err
 		}
-		return z.File[0].Open()
 	}
 
-	for _, zf := range u.r.File {
-		if zf.Name == name {
-			return zf.Open()
-		}
+	zf, err := u.dataFile(e)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("internal error: name not found")
+	return zf.Open()
 }
 
 // Create returns a Writer to which the file contents should be written.
+// The file is compressed with Deflate, or the method chosen by the
+// MethodSelector set by SetMethodSelector.
 func (u *Updater) Create(name string) (io.WriteCloser, error) {
-	if _, ok := u.headers[name]; ok {
+	if u.lookup(name) != nil {
 		return nil, errors.New("invalid duplicate file name")
 	}
 
-	u.entries[name] = new(bytesEX.BufferAt)
-	z := NewWriter(u.entries[name])
+	e := new(entry)
+	buf, err := u.newBuffer(e)
+	if err != nil {
+		return nil, err
+	}
+	z := u.newWriter(buf)
+	z.SetMethodSelector(u.selector)
+	z.SetAdaptive(u.minSaving)
 
 	w, err := z.Create(name)
 	if err != nil {
+		buf.Close()
 		return nil, err
 	}
-	u.files = append(u.files, name)
-	u.headers[name] = z.dir[0].FileHeader
+	e.header = z.dir[0].FileHeader
+	u.add(e)
 
 	wc := &WriteCloser{
 		writer: w,
@@ -117,23 +346,47 @@ func (u *Updater) Create(name string) (io.WriteCloser, error) {
 }
 
 // Update returns a Writer to which the file contents should be overwritten.
+// A file of a method which can only be decompressed, such as Implode,
+// is written with Deflate.
 func (u *Updater) Update(name string) (io.WriteCloser, error) {
-	if _, ok := u.headers[name]; !ok {
+	e := u.lookup(name)
+	if e == nil {
 		return nil, errors.New("not found file name")
 	}
-	useDataDescriptor := u.headers[name].Flags&FlagDataDescriptor != 0
+	return u.update(e)
+}
 
-	u.entries[name] = new(bytesEX.BufferAt)
-	z := NewWriter(u.entries[name])
+// UpdateHandle is like Update, for the file h.
+func (u *Updater) UpdateHandle(h Handle) (io.WriteCloser, error) {
+	e, ok := u.handles[h]
+	if !ok {
+		return nil, errors.New("not found file name")
+	}
+	return u.update(e)
+}
 
-	w, err := z.CreateHeader(u.headers[name])
+func (u *Updater) update(e *entry) (io.WriteCloser, error) {
+	useDataDescriptor := e.header.Flags&FlagDataDescriptor != 0
+	fh := *e.header
+	u.migrateMethod(&fh)
+
+	buf, err := u.newBuffer(e)
+	if err != nil {
+		return nil, err
+	}
+	z := u.newWriter(buf)
+
+	w, err := z.CreateHeader(&fh)
 	if err != nil {
 		return nil, err
 	}
 	if !useDataDescriptor {
 		z.dir[0].FileHeader.Flags &^= FlagDataDescriptor
 	}
-	u.headers[name] = z.dir[0].FileHeader
+	e.header = z.dir[0].FileHeader
+	e.origin = nil
+	e.source = nil
+	e.recompress = false
 
 	wc := &WriteCloser{
 		writer: w,
@@ -142,151 +395,1098 @@ func (u *Updater) Update(name string) (io.WriteCloser, error) {
 	return wc, nil
 }
 
+// UpdateIfChanged overwrites the contents of the file name with the
+// contents of r, only if they differ from the current contents.
+// The new contents are compressed into a pending entry while their
+// CRC-32 and size are computed. If both match the file's header, the
+// pending entry is discarded, and the file keeps its compressed data
+// and metadata. UpdateIfChanged reports whether the file was changed.
+func (u *Updater) UpdateIfChanged(name string, r io.Reader) (bool, error) {
+	e := u.lookup(name)
+	if e == nil {
+		return false, errors.New("not found file name")
+	}
+	if strings.HasSuffix(name, "/") {
+		return false, errors.New("zip: cannot update a directory")
+	}
+
+	buf, err := u.storage.NewBuffer()
+	if err != nil {
+		return false, err
+	}
+	fh := *e.header
+	u.migrateMethod(&fh)
+	if err := u.updateBuffer(buf, &fh, r); err != nil {
+		buf.Close()
+		return false, err
+	}
+
+	// The CRC-32 of a source is not known until it is read.
+	if e.source == nil && fh.CRC32 == e.header.CRC32 && fh.UncompressedSize64 == e.header.UncompressedSize64 {
+		buf.Close()
+		return false, nil
+	}
+
+	old := e.buf
+	e.buf = buf
+	u.releaseBuffer(old)
+	e.header = &fh
+	e.origin = nil
+	e.source = nil
+	e.recompress = false
+	return true, nil
+}
+
+// migrateMethod changes the method of fh to Deflate, if its method can
+// be decompressed but not compressed, as the legacy methods, so that
+// the new contents of the file can be written.
+func (u *Updater) migrateMethod(fh *FileHeader) {
+	if u.compressor(fh.Method) != nil || u.decompressor(fh.Method) == nil {
+		return
+	}
+	if fh.Method == Implode {
+		fh.Flags &^= FlagImplode8KDictionary | FlagImplodeLiteralTree
+	}
+	fh.Method = Deflate
+}
+
+// updateBuffer writes a zip archive holding a single file described by
+// fh, with the contents of r, to buf. fh is updated as by Update.
+func (u *Updater) updateBuffer(buf EntryBuffer, fh *FileHeader, r io.Reader) error {
+	useDataDescriptor := fh.Flags&FlagDataDescriptor != 0
+
+	z := u.newWriter(buf)
+	w, err := z.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if !useDataDescriptor {
+		fh.Flags &^= FlagDataDescriptor
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
 // Rename changes the file name.
 func (u *Updater) Rename(oldName, newName string) error {
-	if _, ok := u.headers[newName]; ok {
-		return errors.New("new file name already exists")
+	e := u.lookup(oldName)
+	if e == nil {
+		return errors.New("not found file name")
 	}
+	return u.renameEntry(e, newName)
+}
 
-	header, ok := u.headers[oldName]
+// RenameHandle changes the name of the file h.
+func (u *Updater) RenameHandle(h Handle, newName string) error {
+	e, ok := u.handles[h]
 	if !ok {
 		return errors.New("not found file name")
 	}
-	header.Name = newName
-	u.headers[newName] = header
-	delete(u.headers, oldName)
+	return u.renameEntry(e, newName)
+}
+
+func (u *Updater) renameEntry(e *entry, newName string) error {
+	if u.lookup(newName) != nil {
+		return errors.New("new file name already exists")
+	}
+	u.rename(e, newName)
+	return nil
+}
+
+// UpdateHeader changes the metadata of the file name with f, without
+// rewriting its contents: the compressed data is copied as is when saving.
+//
+// f may change Comment, NonUTF8, Modified, CreatorVersion, ExternalAttrs
+// (or call SetMode) and Extra. Changes to the other fields are ignored;
+// use Rename to change the name.
+// If Modified is changed, the legacy MS-DOS time and the timestamp extra
+// fields are updated too.
+func (u *Updater) UpdateHeader(name string, f func(fh *FileHeader)) error {
+	e := u.lookup(name)
+	if e == nil {
+		return errors.New("not found file name")
+	}
+	fh := e.header
 
-	for i, v := range u.files {
-		if v == oldName {
-			u.files[i] = newName
+	edit := *fh
+	edit.Extra = append([]byte(nil), fh.Extra...)
+	f(&edit)
+
+	// keep the fields describing the contents
+	edit.Name = fh.Name
+	edit.ReaderVersion = fh.ReaderVersion
+	edit.Method = fh.Method
+	edit.CRC32 = fh.CRC32
+	edit.CompressedSize = fh.CompressedSize
+	edit.UncompressedSize = fh.UncompressedSize
+	edit.CompressedSize64 = fh.CompressedSize64
+	edit.UncompressedSize64 = fh.UncompressedSize64
+	edit.Flags = fh.Flags
+
+	if len(edit.Comment) > uint16max {
+		return errors.New("zip: FileHeader.Comment too long")
+	}
+	if !edit.Modified.Equal(fh.Modified) {
+		edit.ModifiedDate, edit.ModifiedTime = timeToMsDosTime(edit.Modified)
+		for _, id := range []uint16{ntfsExtraID, unixExtraID, extTimeExtraID, infoZipUnixExtraID} {
+			edit.Extra = removeExtra(edit.Extra, id)
 		}
+		edit.Extra = append(edit.Extra, extTimeExtra(edit.Modified)...)
+	}
+	if len(edit.Extra) > uint16max {
+		return errLongExtra
 	}
 
-	if entry, ok := u.entries[oldName]; ok {
-		u.entries[newName] = entry
-		delete(u.entries, oldName)
+	// Set the UTF-8 flag, as Writer.CreateHeader does.
+	utf8Valid1, utf8Require1 := detectUTF8(edit.Name)
+	utf8Valid2, utf8Require2 := detectUTF8(edit.Comment)
+	switch {
+	case edit.NonUTF8:
+		edit.Flags &^= 0x800
+	case (utf8Require1 || utf8Require2) && (utf8Valid1 && utf8Valid2):
+		edit.Flags |= 0x800
 	}
+
+	*fh = edit
 	return nil
 }
 
 // Remove deletes the file.
 func (u *Updater) Remove(name string) error {
-	if _, ok := u.headers[name]; !ok {
+	e := u.lookup(name)
+	if e == nil {
+		return errors.New("not found file name")
+	}
+	u.remove(e)
+	return nil
+}
+
+// RemoveHandle deletes the file h.
+func (u *Updater) RemoveHandle(h Handle) error {
+	e, ok := u.handles[h]
+	if !ok {
+		return errors.New("not found file name")
+	}
+	u.remove(e)
+	return nil
+}
+
+// Deduplicate resolves the files that have the same name as an
+// earlier file, with policy: ConflictSkip keeps the first file,
+// ConflictOverwrite keeps the last one, ConflictRename renames the
+// later files as "name (n).ext", and ConflictError only reports
+// an error if there are duplicates. Duplicate directories are removed,
+// except with ConflictError.
+func (u *Updater) Deduplicate(policy ConflictPolicy) error {
+	var dups [][]*entry
+	for _, e := range u.entries {
+		if entries := u.names[e.header.Name]; len(entries) > 1 && entries[0] == e {
+			dups = append(dups, entries)
+		}
+	}
+	if len(dups) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case ConflictError:
+		return fmt.Errorf("zip: %s is duplicated", dups[0][0].header.Name)
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+	default:
+		return errors.New("zip: invalid conflict policy")
+	}
+
+	exists := func(name string) bool {
+		return u.lookup(name) != nil
+	}
+	for _, entries := range dups {
+		entries := append([]*entry(nil), entries...)
+		keep := entries[0]
+		if policy == ConflictOverwrite {
+			keep = entries[len(entries)-1]
+		}
+		for _, e := range entries {
+			switch {
+			case e == keep:
+			case policy == ConflictRename && !strings.HasSuffix(e.header.Name, "/"):
+				u.rename(e, uniqueName(e.header.Name, exists))
+			default:
+				u.remove(e)
+			}
+		}
+	}
+	return nil
+}
+
+// Recompress changes the compression method of the file name.
+// The file is decompressed with the registered Decompressor and
+// compressed again with the Compressor of method when the changes are
+// saved, keeping its other metadata. level is the compression level of
+// method, as FileHeader.Level; the methods without levels ignore it.
+func (u *Updater) Recompress(name string, method uint16, level int) error {
+	e := u.lookup(name)
+	if e == nil {
 		return errors.New("not found file name")
 	}
-	delete(u.headers, name)
+	return u.recompressEntry(e, method, level)
+}
 
-	newfiles := make([]string, 0)
-	for _, v := range u.files {
-		if v != name {
-			newfiles = append(newfiles, v)
+func (u *Updater) recompressEntry(e *entry, method uint16, level int) error {
+	if strings.HasSuffix(e.header.Name, "/") {
+		return errors.New("zip: cannot recompress a directory")
+	}
+	if !validLevel(method, level) {
+		return errors.New("zip: invalid compression level")
+	}
+	if u.compressor(method) == nil {
+		return ErrAlgorithm
+	}
+	if e.source == nil {
+		zf, err := u.dataFile(e)
+		if err != nil {
+			return err
+		}
+		if zf.zip.decompressor(zf.Method) == nil {
+			return ErrAlgorithm
 		}
 	}
-	u.files = newfiles
 
-	if _, ok := u.entries[name]; ok {
-		delete(u.entries, name)
+	if e.header.Method == Implode {
+		e.header.Flags &^= FlagImplode8KDictionary | FlagImplodeLiteralTree
 	}
+	e.header.Method = method
+	e.recompress = true
+	e.level = level
 	return nil
 }
 
+// RecompressAll calls Recompress for every file, except directories,
+// for which selector returns true.
+func (u *Updater) RecompressAll(selector func(fh *FileHeader) bool, method uint16, level int) error {
+	for _, e := range u.entries {
+		if strings.HasSuffix(e.header.Name, "/") || !selector(e.header) {
+			continue
+		}
+		if err := u.recompressEntry(e, method, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataFlags are the flag bits describing the compressed data: the data
+// descriptor, and the bits 1 and 2 given by the method.
+const dataFlags = FlagDataDescriptor | FlagDeflateSuperFast
+
+// recompressEntries compresses the files marked by Recompress into
+// pending entries. The files are compressed concurrently.
+func (u *Updater) recompressEntries() error {
+	var entries []*entry
+	for _, e := range u.entries {
+		if e.recompress {
+			entries = append(entries, e)
+		}
+	}
+
+	bufs := make([]EntryBuffer, 0, len(entries))
+	release := func() {
+		for _, buf := range bufs {
+			buf.Close()
+		}
+	}
+	for range entries {
+		buf, err := u.storage.NewBuffer()
+		if err != nil {
+			release()
+			return err
+		}
+		bufs = append(bufs, buf)
+	}
+
+	errs := make([]error, len(entries))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, e *entry) {
+			defer wg.Done()
+			errs[i] = u.recompressTo(bufs[i], e)
+			<-sem
+		}(i, e)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			release()
+			return err
+		}
+	}
+
+	for i, e := range entries {
+		zr, err := u.newReader(bufs[i], bufs[i].Size())
+		if err != nil {
+			release()
+			return err
+		}
+		nf := zr.File[0]
+
+		// keep the metadata, except the fields describing the data
+		fh := e.header
+		fh.Flags = fh.Flags&^dataFlags | nf.Flags&dataFlags
+		if fh.ReaderVersion < nf.ReaderVersion {
+			fh.ReaderVersion = nf.ReaderVersion
+		}
+		fh.CRC32 = nf.CRC32
+		fh.CompressedSize = nf.CompressedSize
+		fh.CompressedSize64 = nf.CompressedSize64
+		fh.UncompressedSize = nf.UncompressedSize
+		fh.UncompressedSize64 = nf.UncompressedSize64
+	}
+
+	for i, e := range entries {
+		old := e.buf
+		e.buf = bufs[i]
+		u.releaseBuffer(old)
+		if e.source != nil && !e.source.reopen {
+			u.replaceSource(e.source, e)
+		}
+		e.origin = nil
+		e.source = nil
+		e.recompress = false
+	}
+	return nil
+}
+
+// recompressTo compresses the contents of e into buf,
+// as a zip archive holding a single file.
+func (u *Updater) recompressTo(buf EntryBuffer, e *entry) error {
+	var rc io.ReadCloser
+	if e.source != nil {
+		var err error
+		if rc, err = e.source.open(); err != nil {
+			return err
+		}
+	} else {
+		zf, err := u.dataFile(e)
+		if err != nil {
+			return err
+		}
+		if rc, err = zf.Open(); err != nil {
+			return err
+		}
+	}
+
+	fh := *e.header
+	fh.Flags &^= FlagDeflateSuperFast // set by Writer for the new method
+	fh.Level = 0
+	if e.level != -1 {
+		fh.Level = e.level
+	}
+	z := u.newWriter(buf)
+	err := copyToWriter(z, &fh, rc)
+	if err == nil {
+		err = z.Close()
+	}
+	if err1 := rc.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// dataFile returns the File holding the compressed data of e,
+// in an archive or in its pending entry.
+func (u *Updater) dataFile(e *entry) (*File, error) {
+	if e.buf != nil {
+		zr, err := u.newReader(e.buf, e.buf.Size())
+		if err != nil {
+			return nil, err
+		}
+		if len(zr.File) == 0 {
+			return nil, fmt.Errorf("internal error: %s is not exist", e.header.Name)
+		}
+		return zr.File[0], nil
+	}
+	if e.origin != nil {
+		return e.origin, nil
+	}
+	return nil, fmt.Errorf("internal error: %s is not exist", e.header.Name)
+}
+
 // SaveAs saves the changes to w.
 // If data descriptor is not used, w must implement io.WriterAt.
 func (u *Updater) SaveAs(w io.Writer) error {
-	z := NewWriter(w)
+	if err := u.recompressEntries(); err != nil {
+		return err
+	}
 
+	z := u.newWriter(w)
 	if err := z.SetComment(u.Comment); err != nil {
 		return err
 	}
 
-	for _, name := range u.files {
-		offset := z.cw.count
-
-		fh := u.headers[name]
-		if err := writeHeader(z.cw, fh); err != nil {
+	for _, e := range u.entries {
+		if err := u.writeEntry(z, e); err != nil {
 			return err
 		}
-		z.dir = append(z.dir, &header{
-			FileHeader: fh,
-			offset:     uint64(offset),
-		})
-
-		var zfile *File
-		if entry, ok := u.entries[name]; ok {
-			// write new file
-			zr, err := NewReader(bytes.NewReader(entry.Bytes()), int64(entry.Len()))
-			if err != nil {
-				return err
-			}
-			if len(zr.File) == 0 {
-				return fmt.Errorf("internal error: %s is not exist", name)
-			}
-			zfile = zr.File[0]
+	}
+
+	return z.Close()
+}
+
+// writeEntry writes the local header and the compressed data of e to z.
+func (u *Updater) writeEntry(z *Writer, e *entry) error {
+	if e.source != nil {
+		// compress the source into z
+		fh := new(FileHeader)
+		*fh = *e.header
+		return e.source.writeTo(z, fh)
+	}
+
+	offset := z.cw.count
+
+	// Copy the header, so that Writer does not modify the Updater's one.
+	fh := new(FileHeader)
+	*fh = *e.header
+	if v := methodVersion(fh.Method); fh.ReaderVersion < v {
+		// the version of an old archive may be wrong, as by CopyFile
+		fh.ReaderVersion = v
+	}
+	if err := writeHeader(z.cw, fh); err != nil {
+		return err
+	}
+	z.dir = append(z.dir, &header{
+		FileHeader: fh,
+		offset:     uint64(offset),
+	})
+
+	// write the new file or the zip's content
+	zfile, err := u.dataFile(e)
+	if err != nil {
+		return err
+	}
+
+	size := int64(zfile.CompressedSize64)
+	if zfile.Flags&FlagDataDescriptor != 0 {
+		if fh.isZip64() {
+			size += dataDescriptor64Len
 		} else {
-			// write zip's content
-			for _, zf := range u.r.File {
-				if zf.Name == name {
-					zfile = zf
-				}
-			}
-			if zfile == nil {
-				return fmt.Errorf("internal error: %s is not exist", name)
-			}
+			size += dataDescriptorLen
+		}
+	}
+	bodyOffset, err := zfile.findBodyOffset()
+	if err != nil {
+		return err
+	}
+	r := io.NewSectionReader(zfile.zipr, zfile.headerOffset+bodyOffset, size)
+	if _, err := io.Copy(z.cw, r); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save saves the changes to the file opened by OpenUpdater.
+//
+// The archive is written to a temporary file in the same directory,
+// which is synced to disk and renamed over the original file, keeping
+// its mode. Thus the original file is left intact if Save fails.
+// After Save the Updater continues editing the saved archive.
+func (u *Updater) Save() error {
+	if u.file == nil {
+		return errors.New("zip: Save needs an Updater opened by OpenUpdater")
+	}
+	name := u.path
+	fi, err := u.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmp.Name())
 		}
+	}()
 
-		size := int64(zfile.CompressedSize64)
-		if zfile.Flags&FlagDataDescriptor != 0 {
-			if fh.isZip64() {
-				size += dataDescriptor64Len
-			} else {
-				size += dataDescriptorLen
+	if err := u.SaveAs(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	tfi, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	size := tfi.Size()
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	ok = true
+
+	// The renamed file is the new archive.
+	zr, err := u.newReader(tmp, size)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	u.file.Close()
+	u.file = tmp
+	u.reinit(zr, size)
+	syncDir(dir)
+	return nil
+}
+
+// syncDir commits a rename in dir to disk, where it is supported.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// SaveInPlace saves the changes back into the opened archive.
+// w must write to the same file that was passed to NewUpdater.
+//
+// Unchanged entries are kept where they are. New and modified entries,
+// the central directory and the end record are written after the last
+// entry that is kept or copied, and the file is truncated to the new end.
+// Until the new end record is complete, the old central directory and
+// end record remain intact at the end of the file, although the data of
+// removed files may be overwritten. When the changes do not fit in front
+// of the old central directory, or when their size is not known before
+// they are written, as for the sources of CreateFrom and AddPath, they
+// are appended after the old end record instead, leaving dead space that
+// Compact can reclaim; if that is interrupted, truncating the file to its
+// old size restores the old archive.
+// Truncating requires w to implement Truncate(size int64) error,
+// as *os.File does; without it, the changes are always appended.
+//
+// After SaveInPlace the Updater continues editing the saved archive.
+func (u *Updater) SaveInPlace(w io.WriterAt) error {
+	if err := u.recompressEntries(); err != nil {
+		return err
+	}
+
+	// Find the end of the entries that stay in place, and the end of
+	// the entries that are copied from the archive, which must not be
+	// overwritten before they are copied.
+	start := int64(0)
+	if len(u.r.File) > 0 {
+		start = u.r.File[0].headerOffset
+		for _, zf := range u.r.File {
+			if zf.headerOffset < start {
+				start = zf.headerOffset
 			}
 		}
-		bodyOffset, err := zfile.findBodyOffset()
+	}
+	inPlace := make(map[*entry]bool)
+	sized := true
+	for _, e := range u.entries {
+		if e.source != nil {
+			sized = false
+		}
+		zf := e.origin
+		if zf == nil || zf.zip != u.r {
+			// not in the opened archive, such as merged files
+			continue
+		}
+		end, err := zf.endOffset()
 		if err != nil {
 			return err
 		}
-		r := io.NewSectionReader(zfile.zipr, zfile.headerOffset+bodyOffset, size)
-		if _, err := io.Copy(z.cw, r); err != nil {
+		if end > start {
+			start = end
+		}
+		inPlace[e] = equalLocalHeader(e.header, &zf.FileHeader)
+	}
+
+	// Measure the output, then write it in front of the old central
+	// directory if possible, and after the old end record otherwise.
+	oldEnd := u.size
+	t, ok := w.(interface{ Truncate(size int64) error })
+	if !ok || !sized {
+		start = oldEnd
+	} else {
+		n, err := u.saveFrom(ioutil.Discard, start, inPlace)
+		if err != nil {
+			return err
+		}
+		end, err := readDirectoryEnd(u.r.r, u.size, nil)
+		if err != nil {
+			return err
+		}
+		if start+n > int64(end.directoryOffset) {
+			start = oldEnd
+		}
+	}
+	n, err := u.saveFrom(&offsetWriter{w: w, off: start}, start, inPlace)
+	if err != nil {
+		return err
+	}
+	if s, ok := w.(interface{ Sync() error }); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	newEnd := start + n
+	if newEnd < oldEnd {
+		if err := t.Truncate(newEnd); err != nil {
 			return err
 		}
 	}
 
-	return z.Close()
+	zr, err := u.newReader(u.r.r, newEnd)
+	if err != nil {
+		return err
+	}
+	u.reinit(zr, newEnd)
+	return nil
+}
+
+// reinit continues editing the saved archive zr, which holds the files
+// of u in order, keeping their handles.
+func (u *Updater) reinit(zr *Reader, size int64) {
+	handles := u.Handles()
+	u.releaseEntries()
+	u.init(zr, size, handles)
+}
+
+// saveFrom writes the entries not in inPlace, the central directory and
+// the end record to w, which is at offset start of the archive.
+// It returns the number of bytes written.
+func (u *Updater) saveFrom(w io.Writer, start int64, inPlace map[*entry]bool) (int64, error) {
+	z := u.newWriter(w)
+	z.SetOffset(start)
+
+	if err := z.SetComment(u.Comment); err != nil {
+		return 0, err
+	}
+
+	for _, e := range u.entries {
+		if inPlace[e] {
+			fh := *e.header
+			z.dir = append(z.dir, &header{
+				FileHeader: &fh,
+				offset:     uint64(e.origin.headerOffset),
+			})
+			continue
+		}
+		if err := u.writeEntry(z, e); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := z.Close(); err != nil {
+		return 0, err
+	}
+	return z.cw.count - start, nil
 }
 
 // Sort updates the file name list to the output of f.
+// Files with the same name keep their relative order.
 func (u *Updater) Sort(f func([]string) []string) error {
-	files := f(u.files)
+	names := make([]string, len(u.entries))
+	for i, e := range u.entries {
+		names[i] = e.header.Name
+	}
+	files := f(names)
 
-	if len(files) != len(u.files) {
+	if len(files) != len(u.entries) {
 		return errors.New("files length are different")
 	}
 
-	exists := make(map[string]bool)
-	for _, name := range files {
-		exists[name] = true
+	rest := make(map[string][]*entry, len(u.names))
+	for name, entries := range u.names {
+		rest[name] = entries
 	}
-	for _, name := range u.files {
-		if _, ok := exists[name]; !ok {
-			return fmt.Errorf("%s is not found in new files", name)
+	entries := make([]*entry, len(files))
+	for i, name := range files {
+		if len(rest[name]) == 0 {
+			return fmt.Errorf("%s is not found in files", name)
 		}
+		entries[i] = rest[name][0]
+		rest[name] = rest[name][1:]
 	}
 
-	u.files = files
+	u.entries = entries
 	return nil
 }
 
-// Cancel discards the changes and ends editing.
+// Cancel discards the changes, restoring the files, their handles and
+// the comment of the opened archive (or of the last saved archive,
+// after SaveInPlace or Save).
 func (u *Updater) Cancel() error {
-	u.files = make([]string, 0)
-	u.headers = make(map[string]*FileHeader, 0)
-	u.entries = make(map[string]*bytesEX.BufferAt, 0)
-	u.r = nil
+	if u.r == nil {
+		return errors.New("zip: Updater is closed")
+	}
+	u.releaseEntries()
+	u.init(u.r, u.size, u.initial)
+	return nil
+}
+
+// CreateFrom adds the file name with the contents of r.
+// If fi is not nil, the file's mode and modification time are taken from it.
+// r is not read until the file is saved or opened; if it implements
+// io.Closer, it is closed after it has been read.
+// SaveAs streams r into the output, so the file cannot be opened or
+// saved by SaveAs again, until Save or SaveInPlace reopens the saved archive.
+func (u *Updater) CreateFrom(name string, r io.Reader, fi os.FileInfo) error {
+	if u.lookup(name) != nil {
+		return errors.New("invalid duplicate file name")
+	}
+
+	fh := &FileHeader{Name: name}
+	if fi != nil {
+		var err error
+		if fh, err = FileInfoHeader(fi); err != nil {
+			return err
+		}
+		fh.Name = name
+	}
+	fh.Method = Deflate
+
+	u.add(&entry{header: fh, source: readerSource(r)})
 	return nil
 }
 
+// UpdateFrom overwrites the contents of the file name with the contents of r.
+// If fi is not nil, the file's mode and modification time are taken from it.
+// r is read as by CreateFrom.
+func (u *Updater) UpdateFrom(name string, r io.Reader, fi os.FileInfo) error {
+	return u.updateFrom(name, readerSource(r), fi)
+}
+
+// AddPath adds the file name with the contents of the local file path,
+// taking the file's mode and modification time from it.
+// The local file is not read until the file is saved.
+func (u *Updater) AddPath(name, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() && !strings.HasSuffix(name, "/") {
+		name += "/"
+	}
+	if u.lookup(name) != nil {
+		return errors.New("invalid duplicate file name")
+	}
+
+	fh, err := FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.Method = Deflate
+
+	e := &entry{header: fh}
+	u.add(e)
+	if !fi.IsDir() {
+		e.source = pathSource(path)
+	} else if err := u.bufferSource(e); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReplacePath overwrites the file name with the contents of the local
+// file path, taking the file's mode and modification time from it.
+// The local file is not read until the file is saved.
+func (u *Updater) ReplacePath(name, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return errors.New("zip: ReplacePath of a directory")
+	}
+	return u.updateFrom(name, pathSource(path), fi)
+}
+
+func (u *Updater) updateFrom(name string, src *entrySource, fi os.FileInfo) error {
+	e := u.lookup(name)
+	if e == nil {
+		return errors.New("not found file name")
+	}
+	fh := e.header
+	u.migrateMethod(fh)
+	if fi != nil {
+		fh.SetModTime(fi.ModTime())
+		fh.SetMode(fi.Mode())
+		fh.UncompressedSize64 = uint64(fi.Size())
+	}
+
+	old := e.buf
+	e.buf = nil
+	u.releaseBuffer(old)
+	e.origin = nil
+	e.source = src
+	return nil
+}
+
+// bufferSource compresses the source of e (or an empty content if
+// there is none) into a pending entry.
+func (u *Updater) bufferSource(e *entry) error {
+	buf, err := u.newBuffer(e)
+	if err != nil {
+		return err
+	}
+	z := u.newWriter(buf)
+
+	if e.source != nil {
+		if err := e.source.writeTo(z, e.header); err != nil {
+			return err
+		}
+	} else if _, err := z.CreateHeader(e.header); err != nil {
+		return err
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	if e.source != nil && !e.source.reopen {
+		u.replaceSource(e.source, e)
+	}
+	e.origin = nil
+	e.source = nil
+	return nil
+}
+
+// An entrySource provides the uncompressed contents of an entry.
+type entrySource struct {
+	open   func() (io.ReadCloser, error)
+	reopen bool // whether open can be called more than once
+}
+
+func readerSource(r io.Reader) *entrySource {
+	read := false
+	return &entrySource{
+		open: func() (io.ReadCloser, error) {
+			if read {
+				return nil, errors.New("zip: source reader has already been read by SaveAs")
+			}
+			read = true
+			if rc, ok := r.(io.ReadCloser); ok {
+				return rc, nil
+			}
+			return ioutil.NopCloser(r), nil
+		},
+	}
+}
+
+func pathSource(path string) *entrySource {
+	return &entrySource{
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+		reopen: true,
+	}
+}
+
+// writeTo compresses the source into a new file of z described by fh.
+func (src *entrySource) writeTo(z *Writer, fh *FileHeader) error {
+	rc, err := src.open()
+	if err != nil {
+		return err
+	}
+	err = copyToWriter(z, fh, rc)
+	if err1 := rc.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// copyToWriter adds a file described by fh with the contents of r to z.
+func copyToWriter(z *Writer, fh *FileHeader, r io.Reader) error {
+	w, err := z.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if z.last != nil {
+		// finish the file, as the next one may be written without Writer
+		if err := z.last.close(); err != nil {
+			return err
+		}
+		z.last = nil
+	}
+	return nil
+}
+
+// newBuffer returns a new buffer for the pending data of e,
+// releasing the previous one.
+func (u *Updater) newBuffer(e *entry) (EntryBuffer, error) {
+	buf, err := u.storage.NewBuffer()
+	if err != nil {
+		return nil, err
+	}
+	old := e.buf
+	e.buf = buf
+	u.releaseBuffer(old)
+	return buf, nil
+}
+
+// releaseEntries releases the buffers of all pending entries,
+// and discards the savepoints.
+func (u *Updater) releaseEntries() {
+	var bufs []EntryBuffer
+	for _, e := range u.entries {
+		bufs = append(bufs, e.buf)
+		e.buf = nil
+	}
+	for _, sp := range u.savepoints {
+		for _, e := range sp.entries {
+			bufs = append(bufs, e.buf)
+		}
+	}
+	u.savepoints = nil
+	u.releaseBuffers(bufs...)
+}
+
+// lookup returns the last file named name, or nil.
+func (u *Updater) lookup(name string) *entry {
+	if entries := u.names[name]; len(entries) > 0 {
+		return entries[len(entries)-1]
+	}
+	return nil
+}
+
+// add appends e to the files, with a new handle.
+func (u *Updater) add(e *entry) {
+	u.nextID++
+	e.id = u.nextID
+	u.entries = append(u.entries, e)
+	u.names[e.header.Name] = append(u.names[e.header.Name], e)
+	u.handles[e.id] = e
+}
+
+// remove deletes e from the files, releasing its buffer.
+func (u *Updater) remove(e *entry) {
+	u.entries = removeEntry(u.entries, e)
+	u.unname(e)
+	delete(u.handles, e.id)
+	old := e.buf
+	e.buf = nil
+	u.releaseBuffer(old)
+}
+
+// rename changes the name of e, which may be the name of other files.
+func (u *Updater) rename(e *entry, name string) {
+	u.unname(e)
+	e.header.Name = name
+	u.names[name] = append(u.names[name], e)
+	u.sortNames(name)
+}
+
+func (u *Updater) unname(e *entry) {
+	name := e.header.Name
+	u.names[name] = removeEntry(u.names[name], e)
+	if len(u.names[name]) == 0 {
+		delete(u.names, name)
+	}
+}
+
+// sortNames restores the file order of the files named name.
+func (u *Updater) sortNames(name string) {
+	entries := u.names[name]
+	if len(entries) < 2 {
+		return
+	}
+	index := make(map[*entry]int, len(u.entries))
+	for i, e := range u.entries {
+		index[e] = i
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return index[entries[i]] < index[entries[j]]
+	})
+}
+
+// removeEntry returns entries without e.
+func removeEntry(entries []*entry, e *entry) []*entry {
+	for i, v := range entries {
+		if v == e {
+			return append(entries[:i:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
+
 // Close discards the changes and ends editing.
 func (u *Updater) Close() error {
-	return u.Cancel()
+	u.releaseEntries()
+	u.entries = make([]*entry, 0)
+	u.names = make(map[string][]*entry)
+	u.handles = make(map[Handle]*entry)
+	u.r = nil
+
+	if u.file == nil {
+		return nil
+	}
+	err := u.file.Close()
+	u.file = nil
+	return err
+}
+
+// equalLocalHeader reports whether the local file headers of a and b
+// are the same. Fields stored only in the central directory are ignored.
+func equalLocalHeader(a, b *FileHeader) bool {
+	return a.Name == b.Name &&
+		a.ReaderVersion == b.ReaderVersion &&
+		a.Flags == b.Flags &&
+		a.Method == b.Method &&
+		a.ModifiedTime == b.ModifiedTime &&
+		a.ModifiedDate == b.ModifiedDate &&
+		a.CRC32 == b.CRC32 &&
+		a.CompressedSize64 == b.CompressedSize64 &&
+		a.UncompressedSize64 == b.UncompressedSize64 &&
+		bytes.Equal(removeExtra(a.Extra, zip64ExtraID), removeExtra(b.Extra, zip64ExtraID))
+}
+
+// removeExtra returns extra without the extra fields tagged id.
+func removeExtra(extra []byte, id uint16) []byte {
+	out := make([]byte, 0, len(extra))
+	for b := readBuf(extra); len(b) > 0; {
+		if len(b) < 4 {
+			// keep malformed data as is
+			return append(out, b...)
+		}
+		field := b
+		tag := b.uint16()
+		size := int(b.uint16())
+		if len(b) < size {
+			return append(out, field...)
+		}
+		b = b[size:]
+		if tag != id {
+			out = append(out, field[:4+size]...)
+		}
+	}
+	return out
+}
+
+// offsetWriter writes to w sequentially, starting at off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
 }
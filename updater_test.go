@@ -6,10 +6,17 @@ package zip
 
 import (
 	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"testing"
+	"time"
 )
 
 var updateTest = ZipTest{
@@ -407,3 +414,1231 @@ func compareContent(t *testing.T, z *Updater, ztf ZipTestFile) {
 		}
 	}
 }
+
+func TestUpdaterSaveInPlace(t *testing.T) {
+	updatefile := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+
+	// open file
+	file, z := testOpenTempFile(t, "testdata/"+updateTest.Name)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	defer z.Close()
+
+	// add & update & rename file
+	testAddFile(t, z, addfile)
+	testUpdateFile(t, z, updatefile)
+	if err := z.Rename("hello", "hello2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// save
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	for i := range testcase {
+		switch testcase[i].Name {
+		case updatefile.Name:
+			testcase[i] = updatefile
+		case "hello":
+			testcase[i].Name = "hello2"
+		}
+	}
+	testcase = append(testcase, addfile)
+
+	// check updater
+	compareContents(t, z, testcase)
+
+	// check file
+	st, _ := file.Stat()
+	zr, err := NewUpdater(file, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zr, testcase)
+}
+
+func TestUpdaterSaveInPlaceTruncate(t *testing.T) {
+	largeData := make([]byte, 1<<16)
+	rand.New(rand.NewSource(1)).Read(largeData)
+	testcase := []ZipTestFile{
+		{Name: "foo", Content: []byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.")},
+		{Name: "large", Content: largeData},
+	}
+
+	// create file
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, ztf := range testcase {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.TempFile("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(src.Name())
+	_, err = src.Write(buf.Bytes())
+	src.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// open file
+	file, z := testOpenTempFile(t, src.Name())
+	defer os.Remove(file.Name())
+	defer file.Close()
+	defer z.Close()
+
+	// remove last file, update first file
+	if err := z.Remove("large"); err != nil {
+		t.Fatal(err)
+	}
+	updatefile := ZipTestFile{Name: "foo", Content: []byte("update string")}
+	testUpdateFile(t, z, updatefile)
+
+	// save
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, z, []ZipTestFile{updatefile})
+
+	// check file
+	st, _ := file.Stat()
+	if st.Size() >= int64(buf.Len()) {
+		t.Fatalf("file size=%d, want < %d", st.Size(), buf.Len())
+	}
+	zr, err := NewUpdater(file, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zr, []ZipTestFile{updatefile})
+
+	// saves changing only the comment do not keep growing the file
+	var max int64
+	for i := 0; i < 10; i++ {
+		z.Comment = fmt.Sprint("comment", i)
+		if err := z.SaveInPlace(file); err != nil {
+			t.Fatal(err)
+		}
+		st, _ = file.Stat()
+		if i < 2 && st.Size() > max {
+			max = st.Size()
+		}
+		if st.Size() > max {
+			t.Fatalf("file size=%d, want <= %d", st.Size(), max)
+		}
+	}
+
+	// a file not fitting in front of the old central directory is
+	// appended after the old archive
+	old := make([]byte, st.Size())
+	if _, err := file.ReadAt(old, 0); err != nil {
+		t.Fatal(err)
+	}
+	testAddFile(t, z, testcase[1])
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+	cur := make([]byte, len(old))
+	if _, err := file.ReadAt(cur, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cur, old) {
+		t.Fatalf("old archive is overwritten")
+	}
+	st, _ = file.Stat()
+	zr, err = NewUpdater(file, st.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zr, []ZipTestFile{updatefile, testcase[1]})
+}
+
+func testOpenTempFile(t *testing.T, src string) (*os.File, *Updater) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(file, int64(len(b)))
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		t.Fatal(err)
+	}
+	return file, z
+}
+
+func TestUpdaterCreateFrom(t *testing.T) {
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+	updatefile := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	// add & update file
+	if err := z.CreateFrom(addfile.Name, bytes.NewReader(addfile.Content), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.UpdateFrom(updatefile.Name, bytes.NewReader(updatefile.Content), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.CreateFrom(addfile.Name, bytes.NewReader(addfile.Content), nil); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	for i := range testcase {
+		if testcase[i].Name == updatefile.Name {
+			testcase[i] = updatefile
+		}
+	}
+	testcase = append(testcase, addfile)
+
+	// sources are streamed into the output, and can be read only once
+	if _, err := z.Open(addfile.Name); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.SaveAs(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// check file
+	zr, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zr, testcase)
+}
+
+func TestUpdaterAddPath(t *testing.T) {
+	src := "testdata/gophercolor16x16.png"
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addfile := ZipTestFile{
+		Name:    "gopher.png",
+		Content: content,
+	}
+	updatefile := ZipTestFile{
+		Name:    "hello",
+		Content: content,
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	// add & replace file
+	if err := z.AddPath(addfile.Name, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.ReplacePath(updatefile.Name, src); err != nil {
+		t.Fatal(err)
+	}
+
+	// check updater
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	for i := range testcase {
+		if testcase[i].Name == updatefile.Name {
+			testcase[i] = updatefile
+		}
+	}
+	testcase = append(testcase, addfile)
+	compareContents(t, z, testcase)
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		if zf.Name != addfile.Name && zf.Name != updatefile.Name {
+			continue
+		}
+		if zf.Mode() != fi.Mode() {
+			t.Fatalf("%s: mode=%v, want %v", zf.Name, zf.Mode(), fi.Mode())
+		}
+		if !zf.Modified.Equal(fi.ModTime().Truncate(time.Second)) {
+			t.Fatalf("%s: modified=%v, want %v", zf.Name, zf.Modified, fi.ModTime())
+		}
+	}
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, testcase)
+}
+
+func TestUpdaterUpdateHeader(t *testing.T) {
+	modified := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	// update header
+	err := z.UpdateHeader("hello", func(fh *FileHeader) {
+		fh.Comment = "new comment"
+		fh.Modified = modified
+		fh.SetMode(0600)
+		fh.Method = Store // ignored
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.UpdateHeader("not-exist", func(fh *FileHeader) {}); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, after *File
+	for _, zf := range z.r.File {
+		if zf.Name == "hello" {
+			before = zf
+		}
+	}
+	for _, zf := range zr.File {
+		if zf.Name == "hello" {
+			after = zf
+		}
+	}
+	if after.Comment != "new comment" {
+		t.Fatalf("comment=%q, want %q", after.Comment, "new comment")
+	}
+	if !after.Modified.Equal(modified) {
+		t.Fatalf("modified=%v, want %v", after.Modified, modified)
+	}
+	if mode := after.Mode(); mode != 0600 {
+		t.Fatalf("mode=%v, want %v", mode, 0600)
+	}
+	if after.Method != before.Method || after.CRC32 != before.CRC32 {
+		t.Fatalf("contents changed")
+	}
+
+	// compressed data is copied as is
+	oldRaw := testRawContent(t, before)
+	newRaw := testRawContent(t, after)
+	if !bytes.Equal(oldRaw, newRaw) {
+		t.Fatalf("compressed data changed")
+	}
+
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, updateTest.File)
+}
+
+func TestUpdaterUpdateHeaderInPlace(t *testing.T) {
+	// open file
+	file, z := testOpenTempFile(t, "testdata/"+updateTest.Name)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	defer z.Close()
+
+	offset := z.r.File[0].headerOffset
+
+	// central directory only change
+	err := z.UpdateHeader(z.r.File[0].Name, func(fh *FileHeader) {
+		fh.Comment = "new comment"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := z.r.File[0].headerOffset; got != offset {
+		t.Fatalf("offset=%d, want %d", got, offset)
+	}
+	if got := z.r.File[0].Comment; got != "new comment" {
+		t.Fatalf("comment=%q, want %q", got, "new comment")
+	}
+	compareContents(t, z, updateTest.File)
+}
+
+func testRawContent(t *testing.T, zf *File) []byte {
+	t.Helper()
+
+	offset, err := zf.findBodyOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, zf.CompressedSize64)
+	if _, err := zf.zipr.ReadAt(b, zf.headerOffset+offset); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUpdaterRecompress(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 200)
+	testcase := []ZipTestFile{
+		{Name: "stored1", Content: text},
+		{Name: "stored2", Content: text[:100]},
+		{Name: "dir/", Content: []byte{}},
+		{Name: "deflated", Content: text},
+	}
+
+	// create file
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	for _, ztf := range testcase {
+		fh := &FileHeader{Name: ztf.Name, Method: Store, Comment: "comment of " + ztf.Name}
+		if ztf.Name == "deflated" {
+			fh.Method = Deflate
+		}
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	// recompress
+	err = z.RecompressAll(func(fh *FileHeader) bool {
+		return fh.Method == Store
+	}, Deflate, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Recompress("deflated", Store, flate.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Recompress("dir/", Deflate, flate.DefaultCompression); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.Recompress("stored1", Deflate, 10); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.Recompress("stored1", 0xffff, flate.DefaultCompression); err != ErrAlgorithm {
+		t.Fatalf("err=%v, want %v", err, ErrAlgorithm)
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := []uint16{Deflate, Deflate, Store, Store}
+	for i, zf := range zr.File {
+		if zf.Method != methods[i] {
+			t.Fatalf("%s: method=%d, want %d", zf.Name, zf.Method, methods[i])
+		}
+		if want := "comment of " + zf.Name; zf.Comment != want {
+			t.Fatalf("%s: comment=%q, want %q", zf.Name, zf.Comment, want)
+		}
+	}
+	if size := zr.File[0].CompressedSize64; size >= uint64(len(text)) {
+		t.Fatalf("compressed size=%d, want < %d", size, len(text))
+	}
+
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, testcase)
+
+	// the changes are kept after saving
+	compareContents(t, z, testcase)
+}
+
+func TestUpdaterRecompressFlags(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 200)
+	tests := []struct {
+		name    string
+		method  uint16
+		level   int
+		to      uint16
+		toLevel int
+		flags   uint16 // flag bits 1 and 2 after the recompression
+	}{
+		{"super-fast", Deflate, 1, Deflate, flate.BestCompression, FlagDeflateMaximum},
+		{"lzma", LZMA, 0, Deflate, flate.DefaultCompression, 0},
+		{"stored", Store, 0, LZMA, 0, FlagLZMAEndMarker},
+		{"lzma-stored", LZMA, 0, Store, 0, 0},
+		{"stored-default", Store, 0, Deflate, 0, 0},
+	}
+
+	// create file
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	for _, tt := range tests {
+		fw, err := w.CreateHeader(&FileHeader{Name: tt.name, Method: tt.method, Level: tt.level})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	for _, tt := range tests {
+		if err := z.Recompress(tt.name, tt.to, tt.toLevel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, zf := range zr.File {
+		if flags := zf.Flags & FlagDeflateSuperFast; flags != tests[i].flags {
+			t.Fatalf("%s: flags=%#x, want %#x", zf.Name, flags, tests[i].flags)
+		}
+		if zf.Method != Store && zf.CompressedSize64 >= zf.UncompressedSize64 {
+			t.Fatalf("%s: compressed size=%d of %d", zf.Name, zf.CompressedSize64, zf.UncompressedSize64)
+		}
+		testReadFile(t, zf, &WriteTest{Name: zf.Name, Data: text, Mode: zf.Mode()})
+	}
+}
+
+func TestUpdaterZstd(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 200)
+	testcase := []ZipTestFile{
+		{Name: "fast", Content: text},
+		{Name: "best", Content: text[:1000]},
+		{Name: "default", Content: text},
+	}
+
+	// create file
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	for _, ztf := range testcase {
+		fw, err := w.CreateHeader(&FileHeader{Name: ztf.Name, Method: Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	levels := []int{1, 22, flate.DefaultCompression}
+	for i, ztf := range testcase {
+		if err := z.Recompress(ztf.Name, Zstd, levels[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Recompress("fast", Zstd, 23); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		if zf.Method != Zstd || zf.ReaderVersion != zipVersion63 {
+			t.Fatalf("%s: method=%d version=%d, want %d %d", zf.Name, zf.Method, zf.ReaderVersion, Zstd, zipVersion63)
+		}
+	}
+
+	// copy the entries
+	wcopy := new(bytes.Buffer)
+	zw := NewWriter(wcopy)
+	for _, zf := range zr.File {
+		if err := zw.CopyFile(zf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wcopy.Bytes(), wdump.Bytes()) {
+		t.Fatalf("copied archive is different")
+	}
+
+	zu, err := NewUpdater(bytes.NewReader(wcopy.Bytes()), int64(wcopy.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareContents(t, zu, testcase)
+
+	// the entries are saved as they are
+	wdump2 := new(bytes.Buffer)
+	if err := zu.SaveAs(wdump2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wdump2.Bytes(), wdump.Bytes()) {
+		t.Fatalf("saved archive is different")
+	}
+
+	// the wrong versions of an old archive are raised
+	old := append([]byte(nil), wdump.Bytes()...)
+	sig := []byte{'P', 'K', 1, 2}
+	for i := bytes.Index(old, sig); i >= 0; {
+		binary.LittleEndian.PutUint16(old[i+6:], zipVersion20)
+		j := bytes.Index(old[i+1:], sig)
+		if j < 0 {
+			break
+		}
+		i += 1 + j
+	}
+	zu, err = NewUpdater(bytes.NewReader(old), int64(len(old)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := zu.Files()[0].ReaderVersion; v != zipVersion20 {
+		t.Fatalf("version=%d, want %d", v, zipVersion20)
+	}
+	wdump3 := new(bytes.Buffer)
+	if err := zu.SaveAs(wdump3); err != nil {
+		t.Fatal(err)
+	}
+	zr, err = NewReader(bytes.NewReader(wdump3.Bytes()), int64(wdump3.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		if zf.ReaderVersion != zipVersion63 {
+			t.Fatalf("%s: version=%d, want %d", zf.Name, zf.ReaderVersion, zipVersion63)
+		}
+	}
+}
+
+func TestUpdaterMethodSelector(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 200)
+	random := make([]byte, len(text))
+	rand.New(rand.NewSource(1)).Read(random)
+	testcase := []ZipTestFile{
+		{Name: "text.txt", Content: text},
+		{Name: "random.bin", Content: random},
+		{Name: "text.zst", Content: text},
+	}
+	methods := []uint16{Deflate, Store, Zstd}
+
+	src := new(bytes.Buffer)
+	if err := NewWriter(src).Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewUpdater(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	z.SetMethodSelector(ExtensionSelector(map[string]uint16{".zst": Zstd}, Deflate))
+	z.SetAdaptive(0.05)
+	for _, ztf := range testcase {
+		testAddFile(t, z, ztf)
+	}
+	compareContents(t, z, testcase)
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, zf := range zr.File {
+		if zf.Method != methods[i] {
+			t.Fatalf("%s: method=%d, want %d", zf.Name, zf.Method, methods[i])
+		}
+	}
+}
+
+// xorWriter and xorReader implement a test method inverting the bits.
+type xorWriter struct{ w io.Writer }
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	return x.w.Write(xorBytes(p))
+}
+
+func (x xorWriter) Close() error { return nil }
+
+type xorReader struct{ r io.Reader }
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	copy(p, xorBytes(p[:n]))
+	return n, err
+}
+
+func xorBytes(p []byte) []byte {
+	b := make([]byte, len(p))
+	for i, c := range p {
+		b[i] = c ^ 0xff
+	}
+	return b
+}
+
+func TestUpdaterRegisterCompressor(t *testing.T) {
+	const xorMethod = 0xfff1
+	xorComp := func(w io.Writer) (io.WriteCloser, error) { return xorWriter{w}, nil }
+	xorDecomp := func(r io.Reader) io.ReadCloser { return ioutil.NopCloser(xorReader{r}) }
+	testcase := []ZipTestFile{
+		{Name: "update", Content: []byte("updated contents")},
+		{Name: "recompress", Content: []byte("recompressed contents")},
+		{Name: "create", Content: []byte("created contents")},
+	}
+
+	// create file
+	src := new(bytes.Buffer)
+	w := NewWriter(src)
+	w.RegisterCompressor(xorMethod, xorComp)
+	for _, fh := range []*FileHeader{
+		{Name: "update", Method: xorMethod},
+		{Name: "recompress", Method: Deflate},
+	} {
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte("original contents")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewUpdater(bytes.NewReader(src.Bytes()), int64(src.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if _, err := z.Open("update"); err != ErrAlgorithm {
+		t.Fatalf("err=%v, want %v", err, ErrAlgorithm)
+	}
+	if err := z.Recompress("recompress", xorMethod, flate.DefaultCompression); err != ErrAlgorithm {
+		t.Fatalf("err=%v, want %v", err, ErrAlgorithm)
+	}
+
+	z.RegisterCompressor(xorMethod, xorComp)
+	z.RegisterDecompressor(xorMethod, xorDecomp)
+	z.SetMethodSelector(func(*FileHeader) uint16 { return xorMethod })
+	compareContent(t, z, ZipTestFile{Name: "update", Content: []byte("original contents")})
+	testUpdateFile(t, z, testcase[0])
+	testUpdateFile(t, z, testcase[1])
+	if err := z.Recompress("recompress", xorMethod, flate.DefaultCompression); err != nil {
+		t.Fatal(err)
+	}
+	testAddFile(t, z, testcase[2])
+	compareContents(t, z, testcase)
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// check file
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.RegisterDecompressor(xorMethod, xorDecomp)
+	for i, zf := range zr.File {
+		if zf.Method != xorMethod {
+			t.Fatalf("%s: method=%d, want %d", zf.Name, zf.Method, xorMethod)
+		}
+		testReadFile(t, zf, &WriteTest{Name: testcase[i].Name, Data: testcase[i].Content, Mode: zf.Mode()})
+	}
+}
+
+func TestUpdaterImplode(t *testing.T) {
+	readme, err := ioutil.ReadFile("testdata/readme.notzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updatefile := ZipTestFile{
+		Name:    "readme-8k-literals.txt",
+		Content: []byte("update string"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/implode.zip")
+	defer file.Close()
+	defer z.Close()
+
+	png, err := ioutil.ReadFile("testdata/gophercolor16x16.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcase := []ZipTestFile{
+		{Name: "readme-4k.txt", Content: readme},
+		{Name: "readme-4k-literals.txt", Content: readme},
+		{Name: "readme-8k.txt", Content: readme},
+		updatefile,
+		{Name: "gophercolor16x16.png", Content: png},
+	}
+
+	testUpdateFile(t, z, updatefile)
+	compareContents(t, z, testcase)
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+
+	// the updated file is written with Deflate
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		method, flags := Implode, zf.Flags&(FlagImplode8KDictionary|FlagImplodeLiteralTree)
+		if zf.Name == updatefile.Name {
+			method = Deflate
+			if flags != 0 {
+				t.Fatalf("%s: flags=%#x, want no implode flags", zf.Name, zf.Flags)
+			}
+		}
+		if zf.Method != method {
+			t.Fatalf("%s: method=%d, want %d", zf.Name, zf.Method, method)
+		}
+	}
+
+	zu, err := NewUpdater(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zu.Close()
+	compareContents(t, zu, testcase)
+}
+
+var duplicateTest = []ZipTestFile{
+	{Name: "a", Content: []byte("first a")},
+	{Name: "b", Content: []byte("b")},
+	{Name: "a", Content: []byte("second a")},
+	{Name: "d/", Content: []byte{}},
+	{Name: "a", Content: []byte("third a")},
+	{Name: "d/", Content: []byte{}},
+}
+
+func testDuplicateArchive(t *testing.T) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, ztf := range duplicateTest {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testHandleContent(t *testing.T, z *Updater, h Handle, want []byte) {
+	t.Helper()
+
+	r, err := z.OpenHandle(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("content=%q, want %q", b, want)
+	}
+}
+
+func TestUpdaterDuplicate(t *testing.T) {
+	b := testDuplicateArchive(t)
+	z, err := NewUpdater(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if len(z.Files()) != len(duplicateTest) {
+		t.Fatalf("file count=%d, want %d", len(z.Files()), len(duplicateTest))
+	}
+	handles := z.Lookup("a")
+	if len(handles) != 3 {
+		t.Fatalf("handle count=%d, want %d", len(handles), 3)
+	}
+
+	// the name addresses the last file
+	compareContent(t, z, duplicateTest[4])
+	testHandleContent(t, z, handles[0], duplicateTest[0].Content)
+	testHandleContent(t, z, handles[1], duplicateTest[2].Content)
+
+	// edit by handle
+	w, err := z.UpdateHandle(handles[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("updated a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RenameHandle(handles[1], "b"); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := z.RenameHandle(handles[1], "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.RemoveHandle(z.Lookup("d/")[1]); err != nil {
+		t.Fatal(err)
+	}
+	if fh := z.Header(handles[1]); fh == nil || fh.Name != "c" {
+		t.Fatalf("header=%v, want c", fh)
+	}
+
+	testcase := []ZipTestFile{
+		{Name: "a", Content: []byte("updated a")},
+		duplicateTest[1],
+		{Name: "c", Content: duplicateTest[2].Content},
+		duplicateTest[3],
+		duplicateTest[4],
+	}
+
+	// save
+	wdump := new(bytes.Buffer)
+	if err := z.SaveAs(wdump); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := NewReader(bytes.NewReader(wdump.Bytes()), int64(wdump.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMultiVolumeContents(t, zr, testcase)
+}
+
+func TestUpdaterDuplicateSaveInPlace(t *testing.T) {
+	b := testDuplicateArchive(t)
+	file, err := ioutil.TempFile("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewUpdater(file, int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	handles := z.Lookup("a")
+	if err := z.RemoveHandle(handles[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.SaveInPlace(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// handles are kept
+	if got := z.Lookup("a"); len(got) != 2 || got[0] != handles[0] || got[1] != handles[2] {
+		t.Fatalf("handles=%v, want %v", got, []Handle{handles[0], handles[2]})
+	}
+	testHandleContent(t, z, handles[0], duplicateTest[0].Content)
+	testHandleContent(t, z, handles[2], duplicateTest[4].Content)
+}
+
+func TestUpdaterDeduplicate(t *testing.T) {
+	tests := []struct {
+		policy ConflictPolicy
+		want   []ZipTestFile
+	}{
+		{ConflictSkip, []ZipTestFile{duplicateTest[0], duplicateTest[1], duplicateTest[3]}},
+		{ConflictOverwrite, []ZipTestFile{duplicateTest[1], duplicateTest[4], duplicateTest[5]}},
+		{ConflictRename, []ZipTestFile{
+			duplicateTest[0],
+			duplicateTest[1],
+			{Name: "a (1)", Content: duplicateTest[2].Content},
+			duplicateTest[3],
+			{Name: "a (2)", Content: duplicateTest[4].Content},
+		}},
+	}
+
+	b := testDuplicateArchive(t)
+	for _, test := range tests {
+		z, err := NewUpdater(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Deduplicate(test.policy); err != nil {
+			t.Fatal(err)
+		}
+		compareContents(t, z, test.want)
+		z.Close()
+	}
+
+	z, err := NewUpdater(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if err := z.Deduplicate(ConflictError); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if len(z.Files()) != len(duplicateTest) {
+		t.Fatalf("file count=%d, want %d", len(z.Files()), len(duplicateTest))
+	}
+}
+
+func TestUpdaterSave(t *testing.T) {
+	addfile := ZipTestFile{
+		Name:    "test",
+		Content: []byte("text string"),
+	}
+
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := ioutil.ReadFile("testdata/" + updateTest.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, updateTest.Name)
+	if err := ioutil.WriteFile(name, b, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := OpenUpdater(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	// save twice, editing the saved archive
+	testAddFile(t, z, addfile)
+	if err := z.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Remove("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	testcase := append(updateTest.File[1:len(updateTest.File):len(updateTest.File)], addfile)
+	compareContents(t, z, testcase)
+
+	// check file
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0640 {
+		t.Fatalf("mode=%v, want %v", mode, os.FileMode(0640))
+	}
+	zu, err := OpenUpdater(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zu.Close()
+	compareContents(t, zu, testcase)
+
+	// no temporary file is left
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("file count=%d, want %d", len(files), 1)
+	}
+
+	// not opened by OpenUpdater
+	file, zf := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer zf.Close()
+	if err := zf.Save(); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestUpdaterUpdateIfChanged(t *testing.T) {
+	updatefile := ZipTestFile{
+		Name:    "dir/bar",
+		Content: []byte("update string"),
+	}
+
+	// open file
+	file, z := testOpenFile(t, "testdata/"+updateTest.Name)
+	defer file.Close()
+	defer z.Close()
+
+	same := updateTest.File[0]
+	before := *z.Files()[0]
+	changed, err := z.UpdateIfChanged(same.Name, bytes.NewReader(same.Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatalf("changed=%v, want %v", changed, false)
+	}
+	changed, err = z.UpdateIfChanged(updatefile.Name, bytes.NewReader(updatefile.Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatalf("changed=%v, want %v", changed, true)
+	}
+	if _, err := z.UpdateIfChanged("not-exist", bytes.NewReader(nil)); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// the unchanged file keeps its data and metadata
+	c := z.Changes()
+	testChangeNames(t, "modified", c.Modified, []string{updatefile.Name})
+	if len(c.MetadataOnly) != 0 {
+		t.Fatalf("metadata change count=%d, want %d", len(c.MetadataOnly), 0)
+	}
+	if after := z.Files()[0]; !after.Modified.Equal(before.Modified) || after.Method != before.Method {
+		t.Fatalf("header=%+v, want %+v", after, before)
+	}
+
+	testcase := make([]ZipTestFile, len(updateTest.File))
+	copy(testcase, updateTest.File)
+	testcase[1] = updatefile
+	compareContents(t, z, testcase)
+
+	// an updated file is compared with its new contents
+	changed, err = z.UpdateIfChanged(updatefile.Name, bytes.NewReader(updatefile.Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatalf("changed=%v, want %v", changed, false)
+	}
+	compareContents(t, z, testcase)
+}
@@ -0,0 +1,364 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var errMissingVolume = errors.New("zip: missing volume of multi-volume archive")
+
+// NewMultiVolumeReader returns a new Reader reading a split or spanned
+// archive. volumes must be given in disk order (.z01, .z02, ..., .zip),
+// and sizes holds the size in bytes of each volume.
+//
+// The returned Reader presents the archive as a single file: offsets
+// reported by File.DataOffset are relative to the beginning of the
+// first volume.
+func NewMultiVolumeReader(volumes []io.ReaderAt, sizes []int64) (*Reader, error) {
+	mv, err := newMultiVolume(volumes, sizes)
+	if err != nil {
+		return nil, err
+	}
+	zr := new(Reader)
+	if err := zr.init(mv, mv.size, mv.start); err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
+
+// OpenMultiVolumeReader will open the split archive whose last volume is
+// name (for example "backup.zip"), together with the preceding volumes
+// "backup.z01", "backup.z02", ... found next to it.
+func OpenMultiVolumeReader(name string) (*ReadCloser, error) {
+	names := volumeNames(name)
+	files := make([]*os.File, 0, len(names))
+	closeAll := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	volumes := make([]io.ReaderAt, len(names))
+	sizes := make([]int64, len(names))
+	for i, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		files = append(files, f)
+		fi, err := f.Stat()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		volumes[i] = f
+		sizes[i] = fi.Size()
+	}
+
+	mv, err := newMultiVolume(volumes, sizes)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	r := new(ReadCloser)
+	if err := r.init(mv, mv.size, mv.start); err != nil {
+		closeAll()
+		return nil, err
+	}
+	r.f = files
+	return r, nil
+}
+
+// volumeNames returns the volume file names of the split archive
+// whose last volume is name, in disk order.
+func volumeNames(name string) []string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	names := make([]string, 0)
+	for i := 1; ; i++ {
+		vname := fmt.Sprintf("%s.z%02d", base, i)
+		if _, err := os.Stat(vname); err != nil {
+			break
+		}
+		names = append(names, vname)
+	}
+	return append(names, name)
+}
+
+// diskOffset converts off, relative to the beginning of disk, into an
+// offset relative to the beginning of the first disk.
+// Disk numbers are ignored when the archive is not a multi-volume archive.
+func diskOffset(disks []int64, disk uint32, off int64) (int64, error) {
+	if disks == nil {
+		return off, nil
+	}
+	if int64(disk) >= int64(len(disks)) {
+		return 0, errMissingVolume
+	}
+	return disks[disk] + off, nil
+}
+
+// multiVolume joins the volumes of a multi-volume archive
+// into a single io.ReaderAt.
+type multiVolume struct {
+	volumes []io.ReaderAt
+	start   []int64 // offset of each volume
+	size    int64   // total size
+}
+
+func newMultiVolume(volumes []io.ReaderAt, sizes []int64) (*multiVolume, error) {
+	if len(volumes) == 0 {
+		return nil, errors.New("zip: no volumes")
+	}
+	if len(volumes) != len(sizes) {
+		return nil, errors.New("zip: volumes and sizes length are different")
+	}
+
+	mv := &multiVolume{
+		volumes: volumes,
+		start:   make([]int64, len(volumes)),
+	}
+	for i, size := range sizes {
+		mv.start[i] = mv.size
+		mv.size += size
+	}
+
+	if len(volumes) > 1 {
+		// The first volume of a split archive starts with the split
+		// signature, which local header offsets already account for.
+		// Checking it here catches volumes given in the wrong order.
+		var buf [4]byte
+		if _, err := mv.ReadAt(buf[:], 0); err != nil {
+			return nil, err
+		}
+		b := readBuf(buf[:])
+		switch b.uint32() {
+		case splitArchiveSignature, fileHeaderSignature:
+		default:
+			return nil, ErrFormat
+		}
+	}
+	return mv, nil
+}
+
+func (mv *multiVolume) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("zip: negative offset")
+	}
+	if off >= mv.size {
+		return 0, io.EOF
+	}
+
+	// find the volume containing off
+	i := sort.Search(len(mv.start), func(i int) bool { return mv.start[i] > off }) - 1
+	for n < len(p) && i < len(mv.volumes) {
+		vsize := mv.size - mv.start[i]
+		if i+1 < len(mv.start) {
+			vsize = mv.start[i+1] - mv.start[i]
+		}
+		voff := off + int64(n) - mv.start[i]
+		buf := p[n:]
+		if rest := vsize - voff; int64(len(buf)) > rest {
+			buf = buf[:rest]
+		}
+		m, err := mv.volumes[i].ReadAt(buf, voff)
+		n += m
+		if err != nil && !(err == io.EOF && m == len(buf)) {
+			return n, err
+		}
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// minVolumeSize is the smallest volume size accepted by NewSplitWriter.
+// It keeps every header and end record within a single volume.
+const minVolumeSize = 64 * 1024
+
+// NewSplitWriter returns a new Writer writing a split archive.
+// A new volume is started whenever the current one reaches size bytes;
+// next is called with the 0-based disk number to obtain the writer for
+// each volume. By convention disk i is named ".z(i+1)" (".z01", ".z02",
+// ...) and the last disk is renamed to ".zip" after Close.
+//
+// Headers and end records are never split across volumes, so a volume
+// may be slightly shorter than size. If a volume writer implements
+// io.Closer, it is closed once the volume is complete.
+// Split archives always use data descriptors.
+func NewSplitWriter(size int64, next func(disk int) (io.Writer, error)) (*Writer, error) {
+	if size < minVolumeSize {
+		return nil, fmt.Errorf("zip: volume size must be at least %d bytes", minVolumeSize)
+	}
+	s := &splitWriter{
+		size:   size,
+		next:   next,
+		starts: []int64{0},
+		disk:   -1,
+	}
+	w := &Writer{cw: &countWriter{w: bufio.NewWriter(s)}, raww: s, split: s}
+
+	var buf [4]byte
+	b := writeBuf(buf[:])
+	b.uint32(splitArchiveSignature)
+	if _, err := w.cw.Write(buf[:]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// A MultiVolumeWriter writes a split archive to files.
+type MultiVolumeWriter struct {
+	*Writer
+	name  string
+	files []*os.File
+}
+
+// CreateMultiVolumeWriter creates the split archive name (for example
+// "backup.zip") with volumes of at most size bytes: "backup.z01",
+// "backup.z02", ... and finally "backup.zip".
+func CreateMultiVolumeWriter(name string, size int64) (*MultiVolumeWriter, error) {
+	mw := &MultiVolumeWriter{name: name}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	w, err := NewSplitWriter(size, func(disk int) (io.Writer, error) {
+		f, err := os.Create(fmt.Sprintf("%s.z%02d", base, disk+1))
+		if err != nil {
+			return nil, err
+		}
+		mw.files = append(mw.files, f)
+		return f, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	mw.Writer = w
+	return mw, nil
+}
+
+// Close finishes writing the archive, closes every volume and renames
+// the last volume to the archive name.
+func (mw *MultiVolumeWriter) Close() error {
+	// The volumes are closed by the splitWriter once complete.
+	if err := mw.Writer.Close(); err != nil {
+		mw.split.closeVolume()
+		return err
+	}
+	last := mw.files[len(mw.files)-1]
+	return os.Rename(last.Name(), mw.name)
+}
+
+// splitWriter distributes the archive bytes over volumes.
+// Volume boundaries are tracked as offsets within the whole archive,
+// so that the Writer can convert its offsets into disk-relative ones
+// before the bytes are flushed.
+type splitWriter struct {
+	size   int64 // maximum size of a volume
+	next   func(disk int) (io.Writer, error)
+	starts []int64 // offset of each volume decided so far
+	w      io.Writer
+	disk   int   // disk number of w
+	count  int64 // bytes written
+}
+
+// locate returns the disk number and the disk-relative offset of off.
+// A nil splitWriter describes an ordinary archive on disk 0.
+func (s *splitWriter) locate(off int64) (disk int, diskOff int64) {
+	if s == nil {
+		return 0, off
+	}
+	i := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > off }) - 1
+	diskOff = off - s.starts[i]
+	if i < len(s.starts)-1 {
+		return i, diskOff
+	}
+	// volumes after the last decided one are filled up to size
+	return i + int(diskOff/s.size), diskOff % s.size
+}
+
+// reserve makes sure that a record of n bytes written at off is not
+// split across volumes, by starting a new volume at off if necessary.
+func (s *splitWriter) reserve(off int64, n int) {
+	if s == nil {
+		return
+	}
+	disk, diskOff := s.locate(off)
+	if diskOff == 0 || diskOff+int64(n) <= s.size {
+		return
+	}
+	for len(s.starts) <= disk {
+		s.starts = append(s.starts, s.starts[len(s.starts)-1]+s.size)
+	}
+	s.starts = append(s.starts, off)
+}
+
+func (s *splitWriter) Write(p []byte) (n int, err error) {
+	for n < len(p) {
+		disk, diskOff := s.locate(s.count)
+		if disk != s.disk {
+			if err := s.closeVolume(); err != nil {
+				return n, err
+			}
+			w, err := s.next(disk)
+			if err != nil {
+				return n, err
+			}
+			s.w = w
+			s.disk = disk
+		}
+
+		rest := s.size - diskOff
+		if disk+1 < len(s.starts) {
+			rest = s.starts[disk+1] - s.count
+		}
+		b := p[n:]
+		if int64(len(b)) > rest {
+			b = b[:rest]
+		}
+		m, err := s.w.Write(b)
+		n += m
+		s.count += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// closeVolume closes the current volume, if it is open.
+func (s *splitWriter) closeVolume() error {
+	c, ok := s.w.(io.Closer)
+	s.w = nil
+	if ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// close completes the last volume.
+func (s *splitWriter) close() error {
+	if s.disk == 0 {
+		// The archive fit in a single volume. Mark it as such,
+		// if the split signature can still be changed.
+		if wa, ok := s.w.(io.WriterAt); ok {
+			var buf [4]byte
+			b := writeBuf(buf[:])
+			b.uint32(spanningMarkerSignature)
+			if _, err := wa.WriteAt(buf[:], 0); err != nil {
+				return err
+			}
+		}
+	}
+	return s.closeVolume()
+}
@@ -0,0 +1,299 @@
+// Copyright 2018 hidez8891. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var splitTest = []ZipTestFile{
+	{
+		Name:    "hello.txt",
+		Content: []byte("Hello, split world!\n"),
+	},
+	{
+		Name:    "data.bin",
+		Content: splitTestData(),
+	},
+}
+
+func splitTestData() []byte {
+	b := make([]byte, 70000)
+	for i := range b {
+		b[i] = byte(i * 7 % 251)
+	}
+	return b
+}
+
+func TestOpenMultiVolumeReader(t *testing.T) {
+	z, err := OpenMultiVolumeReader("testdata/split.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	testMultiVolumeContents(t, &z.Reader, splitTest)
+}
+
+func TestNewMultiVolumeReader(t *testing.T) {
+	var volumes []io.ReaderAt
+	var sizes []int64
+	for _, name := range []string{"testdata/split.z01", "testdata/split.zip"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		volumes = append(volumes, bytes.NewReader(b))
+		sizes = append(sizes, int64(len(b)))
+	}
+
+	z, err := NewMultiVolumeReader(volumes, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMultiVolumeContents(t, z, splitTest)
+
+	// missing volume
+	if _, err := NewMultiVolumeReader(volumes[1:], sizes[1:]); err == nil {
+		t.Fatalf("need raise error")
+	}
+
+	// wrong order
+	volumes[0], volumes[1] = volumes[1], volumes[0]
+	sizes[0], sizes[1] = sizes[1], sizes[0]
+	if _, err := NewMultiVolumeReader(volumes, sizes); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestMultiVolumeReaderSingle(t *testing.T) {
+	f, err := os.Open("testdata/test.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewMultiVolumeReader([]io.ReaderAt{f}, []int64{fi.Size()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(z.File) != 2 {
+		t.Fatalf("file count=%d, want %d", len(z.File), 2)
+	}
+}
+
+func testMultiVolumeContents(t *testing.T, z *Reader, testcase []ZipTestFile) {
+	t.Helper()
+
+	if len(z.File) != len(testcase) {
+		t.Fatalf("file count=%d, want %d", len(z.File), len(testcase))
+	}
+	for i, ztf := range testcase {
+		f := z.File[i]
+		if f.Name != ztf.Name {
+			t.Fatalf("name=%q, want %q", f.Name, ztf.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, ztf.Content) {
+			t.Fatalf("%s: content mismatch", f.Name)
+		}
+	}
+}
+
+func TestSplitWriter(t *testing.T) {
+	largeData := make([]byte, 150000)
+	rand.New(rand.NewSource(1)).Read(largeData)
+	testcase := []ZipTestFile{
+		{Name: "foo", Content: []byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.")},
+		{Name: "dir/", Content: []byte{}},
+		{Name: "large", Content: largeData},
+		{Name: "bar", Content: []byte("last file")},
+	}
+
+	var volumes []*bytes.Buffer
+	w, err := NewSplitWriter(minVolumeSize, func(disk int) (io.Writer, error) {
+		if disk != len(volumes) {
+			t.Fatalf("disk=%d, want %d", disk, len(volumes))
+		}
+		volumes = append(volumes, new(bytes.Buffer))
+		return volumes[disk], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ztf := range testcase {
+		fw, err := w.Create(ztf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(ztf.Content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(volumes) != 3 {
+		t.Fatalf("volume count=%d, want %d", len(volumes), 3)
+	}
+	readers := make([]io.ReaderAt, len(volumes))
+	sizes := make([]int64, len(volumes))
+	for i, v := range volumes {
+		if v.Len() > minVolumeSize {
+			t.Fatalf("volume %d size=%d, want <= %d", i, v.Len(), minVolumeSize)
+		}
+		readers[i] = bytes.NewReader(v.Bytes())
+		sizes[i] = int64(v.Len())
+	}
+	if !bytes.HasPrefix(volumes[0].Bytes(), []byte("PK\x07\x08")) {
+		t.Fatalf("missing split signature")
+	}
+
+	z, err := NewMultiVolumeReader(readers, sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMultiVolumeContents(t, z, testcase)
+	if got := z.File[len(z.File)-1].diskNbr; got != 2 {
+		t.Fatalf("disk number=%d, want %d", got, 2)
+	}
+}
+
+func TestSplitWriterZip64Directory(t *testing.T) {
+	var volumes []*bytes.Buffer
+	w, err := NewSplitWriter(minVolumeSize, func(disk int) (io.Writer, error) {
+		volumes = append(volumes, new(bytes.Buffer))
+		return volumes[disk], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// headers of large files, whose records have a zip64 extra field
+	const n = 2000
+	for i := 0; i < n; i++ {
+		w.dir = append(w.dir, &header{
+			FileHeader: &FileHeader{
+				Name:               fmt.Sprintf("f%05d", i),
+				CompressedSize64:   1 << 32,
+				UncompressedSize64: 1 << 32,
+			},
+			offset: 4,
+		})
+	}
+	// the first file starts on a disk beyond the 16-bit disk numbers
+	w.dir[0].offset = 70000*minVolumeSize + 4
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []byte
+	var ends []int
+	for _, v := range volumes {
+		all = append(all, v.Bytes()...)
+		ends = append(ends, len(all))
+	}
+	start := bytes.Index(all, []byte("PK\x01\x02"))
+	if start < 0 {
+		t.Fatal("missing central directory")
+	}
+	const (
+		firstLen  = directoryHeaderLen + 6 + 32
+		recordLen = directoryHeaderLen + 6 + 28
+	)
+	for i := 0; i < n; i++ {
+		off := start
+		if i > 0 {
+			off += firstLen + (i-1)*recordLen
+		}
+		for _, end := range ends {
+			if off < end && end < off+recordLen {
+				t.Fatalf("record %d at %d is split at %d", i, off, end)
+			}
+		}
+		if binary.LittleEndian.Uint32(all[off:]) != directoryHeaderSignature {
+			t.Fatalf("record %d: missing signature", i)
+		}
+	}
+
+	// the disk start field follows the offset field
+	b := readBuf(all[start:])
+	b.sub(34)
+	if disk := b.uint16(); disk != uint16max {
+		t.Fatalf("disk=%d, want %d", disk, uint16max)
+	}
+	b.sub(6)
+	if offset := b.uint32(); offset != uint32max {
+		t.Fatalf("offset=%d, want %d", offset, uint32max)
+	}
+	b.sub(6)
+	if tag, size := b.uint16(), b.uint16(); tag != zip64ExtraID || size != 28 {
+		t.Fatalf("extra tag=%#x size=%d, want %#x 28", tag, size, zip64ExtraID)
+	}
+	b.sub(16)
+	if offset := b.uint64(); offset != 4 {
+		t.Fatalf("zip64 offset=%d, want 4", offset)
+	}
+	if disk := b.uint32(); disk != 70000 {
+		t.Fatalf("zip64 disk=%d, want 70000", disk)
+	}
+}
+
+func TestSplitWriterSingleVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "single.zip")
+	w, err := CreateMultiVolumeWriter(name, minVolumeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw, err := w.Create(splitTest[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(splitTest[0].Content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("PK00")) {
+		t.Fatalf("missing spanning marker")
+	}
+	z, err := NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMultiVolumeContents(t, z, splitTest[:1])
+}
@@ -6,17 +6,29 @@ package zip
 
 import (
 	"bufio"
+	"compress/flate"
 	"encoding/binary"
 	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"path"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
 const (
-	FlagDataDescriptor uint16 = 0x8
+	FlagDataDescriptor      uint16 = 0x8
+	FlagLZMAEndMarker       uint16 = 0x2 // the LZMA data ends with the end marker
+	FlagImplode8KDictionary uint16 = 0x2 // the imploded data uses an 8K dictionary
+	FlagImplodeLiteralTree  uint16 = 0x4 // the imploded data codes the literals with a tree
+
+	// The flag bits 1 and 2 of Deflate record the level class of the data.
+	FlagDeflateMaximum   uint16 = 0x2 // the data is deflated with the maximum levels 8 and 9
+	FlagDeflateFast      uint16 = 0x4 // the data is deflated with the fast level 2
+	FlagDeflateSuperFast uint16 = 0x6 // the data is deflated with the super fast level 1
 )
 
 var (
@@ -31,8 +43,12 @@ type Writer struct {
 	dir         []*header
 	last        *fileWriter
 	closed      bool
-	compressors map[uint16]Compressor
+	compressors map[uint16]MethodCompressor
+	options     CompressOptions
+	selector    MethodSelector
+	minSaving   float64 // adaptive when > 0
 	comment     string
+	split       *splitWriter // non-nil when writing a split archive
 
 	// testHookCloseSizeOffset if non-nil is called with the size
 	// of offset of the central directory at Close.
@@ -76,6 +92,49 @@ func (w *Writer) SetComment(comment string) error {
 	return nil
 }
 
+// A MethodSelector chooses the compression method of a file by its
+// FileHeader, typically by the extension of its name.
+type MethodSelector func(fh *FileHeader) uint16
+
+// ExtensionSelector returns a MethodSelector choosing the method of the
+// extension of the file name in methods, such as Store for ".jpg".
+// The extensions are matched without regard to case. The files of the
+// other extensions are given the method def.
+func ExtensionSelector(methods map[string]uint16, def uint16) MethodSelector {
+	m := make(map[string]uint16, len(methods))
+	for ext, method := range methods {
+		m[strings.ToLower(ext)] = method
+	}
+	return func(fh *FileHeader) uint16 {
+		if method, ok := m[strings.ToLower(path.Ext(fh.Name))]; ok {
+			return method
+		}
+		return def
+	}
+}
+
+// SetMethodSelector sets the MethodSelector consulted by Create, and by
+// CreateHeader for a FileHeader whose Method is SelectMethod. The other
+// methods given to CreateHeader are used as they are.
+// A nil sel restores the default, Deflate.
+func (w *Writer) SetMethodSelector(sel MethodSelector) {
+	w.selector = sel
+}
+
+// adaptiveSampleSize is the size of the start of a file trial-compressed
+// by the adaptive method selection.
+const adaptiveSampleSize = 64 << 10
+
+// SetAdaptive enables the adaptive method selection when minSaving is
+// positive: the first 64 KiB of each file to be compressed are compressed
+// as a trial, and the file is stored instead when the trial saves less than
+// the fraction minSaving of them, such as 0.05 for 5%. This avoids spending
+// time on data which is already compressed, such as JPEG or MP4.
+// The local file header is written once the method is decided.
+func (w *Writer) SetAdaptive(minSaving float64) {
+	w.minSaving = minSaving
+}
+
 // Close finishes writing the zip file by writing the central directory.
 // It does not close the underlying writer.
 func (w *Writer) Close() error {
@@ -92,7 +151,48 @@ func (w *Writer) Close() error {
 
 	// write central directory
 	start := w.cw.count
-	for _, h := range w.dir {
+	var dirDisk, lastDisk int
+	var dirRecordsThisDisk uint64
+	for i, h := range w.dir {
+		headerDisk, headerOffset := w.split.locate(int64(h.offset))
+		offset := uint64(headerOffset)
+		// The disk number needs the zip64 disk start field,
+		// which follows the offset field.
+		bigDisk := headerDisk >= uint16max
+
+		zip64 := h.isZip64() || offset >= uint32max || bigDisk
+		if zip64 {
+			// append a zip64 extra block to Extra
+			var buf [32]byte // 2x uint16 + 3x uint64 + uint32
+			eb := writeBuf(buf[:])
+			eb.uint16(zip64ExtraID)
+			if bigDisk {
+				eb.uint16(28) // size = 3x uint64 + uint32
+			} else {
+				eb.uint16(24) // size = 3x uint64
+			}
+			eb.uint64(h.UncompressedSize64)
+			eb.uint64(h.CompressedSize64)
+			eb.uint64(offset)
+			if bigDisk {
+				eb.uint32(uint32(headerDisk))
+				h.Extra = append(h.Extra, buf[:]...)
+			} else {
+				h.Extra = append(h.Extra, buf[:28]...)
+			}
+		}
+
+		w.split.reserve(w.cw.count, directoryHeaderLen+len(h.Name)+len(h.Extra)+len(h.Comment))
+		disk, _ := w.split.locate(w.cw.count)
+		if i == 0 {
+			dirDisk = disk
+		}
+		if disk != lastDisk {
+			lastDisk = disk
+			dirRecordsThisDisk = 0
+		}
+		dirRecordsThisDisk++
+
 		var buf [directoryHeaderLen]byte
 		b := writeBuf(buf[:])
 		b.uint32(uint32(directoryHeaderSignature))
@@ -103,22 +203,12 @@ func (w *Writer) Close() error {
 		b.uint16(h.ModifiedTime)
 		b.uint16(h.ModifiedDate)
 		b.uint32(h.CRC32)
-		if h.isZip64() || h.offset >= uint32max {
+		if zip64 {
 			// the file needs a zip64 header. store maxint in both
 			// 32 bit size fields (and offset later) to signal that the
 			// zip64 extra header should be used.
 			b.uint32(uint32max) // compressed size
 			b.uint32(uint32max) // uncompressed size
-
-			// append a zip64 extra block to Extra
-			var buf [28]byte // 2x uint16 + 3x uint64
-			eb := writeBuf(buf[:])
-			eb.uint16(zip64ExtraID)
-			eb.uint16(24) // size = 3x uint64
-			eb.uint64(h.UncompressedSize64)
-			eb.uint64(h.CompressedSize64)
-			eb.uint64(h.offset)
-			h.Extra = append(h.Extra, buf[:]...)
 		} else {
 			b.uint32(h.CompressedSize)
 			b.uint32(h.UncompressedSize)
@@ -127,12 +217,17 @@ func (w *Writer) Close() error {
 		b.uint16(uint16(len(h.Name)))
 		b.uint16(uint16(len(h.Extra)))
 		b.uint16(uint16(len(h.Comment)))
-		b = b[4:] // skip disk number start and internal file attr (2x uint16)
+		if bigDisk {
+			b.uint16(uint16max)
+		} else {
+			b.uint16(uint16(headerDisk))
+		}
+		b = b[2:] // skip internal file attr (uint16)
 		b.uint32(h.ExternalAttrs)
-		if h.offset > uint32max {
+		if offset > uint32max || bigDisk {
 			b.uint32(uint32max)
 		} else {
-			b.uint32(uint32(h.offset))
+			b.uint32(uint32(offset))
 		}
 		if _, err := w.cw.Write(buf[:]); err != nil {
 			return err
@@ -152,12 +247,29 @@ func (w *Writer) Close() error {
 	records := uint64(len(w.dir))
 	size := uint64(end - start)
 	offset := uint64(start)
+	if w.split != nil && records > 0 {
+		// the central directory offset is relative to its first disk
+		_, o := w.split.locate(start)
+		offset = uint64(o)
+	}
 
 	if f := w.testHookCloseSizeOffset; f != nil {
 		f(size, offset)
 	}
 
-	if records >= uint16max || size >= uint32max || offset >= uint32max {
+	// The end records must not be split across volumes.
+	w.split.reserve(end, directory64EndLen+directory64LocLen+directoryEndLen+len(w.comment))
+	endDisk, endOffset := w.split.locate(end)
+	if records == 0 {
+		dirDisk = endDisk
+		lastDisk = endDisk
+	}
+	if lastDisk != endDisk {
+		dirRecordsThisDisk = 0
+	}
+	disks := uint32(endDisk + 1)
+
+	if records >= uint16max || size >= uint32max || offset >= uint32max || disks > uint16max {
 		var buf [directory64EndLen + directory64LocLen]byte
 		b := writeBuf(buf[:])
 
@@ -166,18 +278,18 @@ func (w *Writer) Close() error {
 		b.uint64(directory64EndLen - 12) // length minus signature (uint32) and length fields (uint64)
 		b.uint16(zipVersion45)           // version made by
 		b.uint16(zipVersion45)           // version needed to extract
-		b.uint32(0)                      // number of this disk
-		b.uint32(0)                      // number of the disk with the start of the central directory
-		b.uint64(records)                // total number of entries in the central directory on this disk
+		b.uint32(uint32(endDisk))        // number of this disk
+		b.uint32(uint32(dirDisk))        // number of the disk with the start of the central directory
+		b.uint64(dirRecordsThisDisk)     // total number of entries in the central directory on this disk
 		b.uint64(records)                // total number of entries in the central directory
 		b.uint64(size)                   // size of the central directory
 		b.uint64(offset)                 // offset of start of central directory with respect to the starting disk number
 
 		// zip64 end of central directory locator
 		b.uint32(directory64LocSignature)
-		b.uint32(0)           // number of the disk with the start of the zip64 end of central directory
-		b.uint64(uint64(end)) // relative offset of the zip64 end of central directory record
-		b.uint32(1)           // total number of disks
+		b.uint32(uint32(endDisk))   // number of the disk with the start of the zip64 end of central directory
+		b.uint64(uint64(endOffset)) // relative offset of the zip64 end of central directory record
+		b.uint32(disks)             // total number of disks
 
 		if _, err := w.cw.Write(buf[:]); err != nil {
 			return err
@@ -186,20 +298,26 @@ func (w *Writer) Close() error {
 		// store max values in the regular end record to signal that
 		// that the zip64 values should be used instead
 		records = uint16max
+		dirRecordsThisDisk = uint16max
 		size = uint32max
 		offset = uint32max
 	}
+	if endDisk >= uint16max {
+		endDisk = uint16max
+		dirDisk = uint16max
+	}
 
 	// write end record
 	var buf [directoryEndLen]byte
 	b := writeBuf(buf[:])
 	b.uint32(uint32(directoryEndSignature))
-	b = b[4:]                        // skip over disk number and first disk number (2x uint16)
-	b.uint16(uint16(records))        // number of entries this disk
-	b.uint16(uint16(records))        // number of entries total
-	b.uint32(uint32(size))           // size of directory
-	b.uint32(uint32(offset))         // start of directory
-	b.uint16(uint16(len(w.comment))) // byte size of EOCD comment
+	b.uint16(uint16(endDisk))            // number of this disk
+	b.uint16(uint16(dirDisk))            // number of the disk with the start of the central directory
+	b.uint16(uint16(dirRecordsThisDisk)) // number of entries this disk
+	b.uint16(uint16(records))            // number of entries total
+	b.uint32(uint32(size))               // size of directory
+	b.uint32(uint32(offset))             // start of directory
+	b.uint16(uint16(len(w.comment)))     // byte size of EOCD comment
 	if _, err := w.cw.Write(buf[:]); err != nil {
 		return err
 	}
@@ -207,12 +325,19 @@ func (w *Writer) Close() error {
 		return err
 	}
 
-	return w.cw.w.(*bufio.Writer).Flush()
+	if err := w.cw.w.(*bufio.Writer).Flush(); err != nil {
+		return err
+	}
+	if w.split != nil {
+		return w.split.close()
+	}
+	return nil
 }
 
 // Create adds a file to the zip file using the provided name.
 // It returns a Writer to which the file contents should be written.
-// The file contents will be compressed using the Deflate method.
+// The file contents will be compressed using the Deflate method,
+// or the method chosen by the MethodSelector set by SetMethodSelector.
 // The name must be a relative path: it must not start with a drive
 // letter (e.g. C:) or leading slash, and only forward slashes are
 // allowed. To create a directory instead of a file, add a trailing
@@ -222,7 +347,7 @@ func (w *Writer) Close() error {
 func (w *Writer) Create(name string) (io.Writer, error) {
 	header := &FileHeader{
 		Name:   name,
-		Method: Deflate,
+		Method: SelectMethod,
 	}
 	return w.CreateHeader(header)
 }
@@ -253,6 +378,7 @@ func detectUTF8(s string) (valid, require bool) {
 // CreateHeader adds a file to the zip archive using the provided FileHeader
 // for the file metadata. Writer takes ownership of fh and may mutate
 // its fields. The caller must not modify fh after calling CreateHeader.
+// If fh.Method is SelectMethod, the method is chosen as by Create.
 //
 // This returns a Writer to which the file contents should be written.
 // The file's contents must be written to the io.Writer before the next
@@ -267,6 +393,12 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 		// See https://golang.org/issue/11144 confusion.
 		return nil, errors.New("archive/zip: invalid duplicate FileHeader")
 	}
+	if fh.Method == SelectMethod {
+		fh.Method = Deflate
+		if w.selector != nil {
+			fh.Method = w.selector(fh)
+		}
+	}
 
 	// The ZIP format has a sad state of affairs regarding character encoding.
 	// Officially, the name and comment fields are supposed to be encoded
@@ -314,22 +446,16 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 		//
 		// This format happens to be identical for both local and central header
 		// if modification time is the only timestamp being encoded.
-		var mbuf [9]byte // 2*SizeOf(uint16) + SizeOf(uint8) + SizeOf(uint32)
-		mt := uint32(fh.Modified.Unix())
-		eb := writeBuf(mbuf[:])
-		eb.uint16(extTimeExtraID)
-		eb.uint16(5)  // Size: SizeOf(uint8) + SizeOf(uint32)
-		eb.uint8(1)   // Flags: ModTime
-		eb.uint32(mt) // ModTime
-
+		//
 		// Create a new Extra fields. (prevent infinite append)
-		fh.Extra = mbuf[:]
+		fh.Extra = extTimeExtra(fh.Modified)
 	}
 
 	var (
 		ow io.Writer
 		fw *fileWriter
 	)
+	w.split.reserve(w.cw.count, fileHeaderLen+len(fh.Name)+len(fh.Extra))
 	h := &header{
 		FileHeader: fh,
 		offset:     uint64(w.cw.count),
@@ -361,23 +487,52 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 		fh.UncompressedSize64 = 0
 
 		fw = &fileWriter{
+			header:    h,
 			raww:      w.raww,
 			zipw:      w.cw,
+			split:     w.split,
 			compCount: &countWriter{w: w.cw},
 			crc32:     crc32.NewIEEE(),
 		}
 		comp := w.compressor(fh.Method)
+		fw.opts = w.options
+		if fh.Level != 0 {
+			fw.opts.Level = fh.Level
+		}
+		if level := fw.opts.Level; level != 0 {
+			if !validLevel(fh.Method, level) {
+				return nil, errors.New("zip: invalid compression level")
+			}
+			// The Compressor functions have no level.
+			if _, ok := comp.(Compressor); ok {
+				if c := levelCompressor(fh.Method, level); c != nil {
+					comp = c
+				}
+			}
+		}
 		if comp == nil {
 			return nil, ErrAlgorithm
 		}
-		var err error
-		fw.comp, err = comp(fw.compCount)
-		if err != nil {
+		ow = fw
+		if w.minSaving > 0 && fh.Method != Store {
+			// The local file header is written by fw
+			// once the method is decided.
+			if len(fh.Name) > uint16max {
+				return nil, errLongName
+			}
+			if len(fh.Extra) > uint16max {
+				return nil, errLongExtra
+			}
+			fw.trial = comp
+			fw.minSaving = w.minSaving
+			fw.sample = make([]byte, 0, adaptiveSampleSize)
+			w.dir = append(w.dir, h)
+			w.last = fw
+			return ow, nil
+		}
+		if err := fw.setMethod(comp); err != nil {
 			return nil, err
 		}
-		fw.rawCount = &countWriter{w: fw.comp}
-		fw.header = h
-		ow = fw
 	}
 	w.dir = append(w.dir, h)
 	if err := writeHeader(w.cw, fh); err != nil {
@@ -388,6 +543,55 @@ func (w *Writer) CreateHeader(fh *FileHeader) (io.Writer, error) {
 	return ow, nil
 }
 
+// setMethod prepares w to compress with comp, as the method of its header.
+func (w *fileWriter) setMethod(comp MethodCompressor) error {
+	fh := w.header.FileHeader
+	if v := methodVersion(fh.Method); v > fh.ReaderVersion {
+		fh.CreatorVersion = fh.CreatorVersion&0xff00 | v
+		fh.ReaderVersion = v
+	}
+	switch fh.Method {
+	case LZMA:
+		fh.Flags |= FlagLZMAEndMarker
+	case Deflate:
+		fh.Flags = fh.Flags&^FlagDeflateSuperFast | deflateFlags(w.opts.Level)
+	}
+	var err error
+	w.comp, err = comp.NewWriter(w.compCount, fh, &w.opts)
+	if err != nil {
+		return err
+	}
+	w.rawCount = &countWriter{w: w.comp}
+	return nil
+}
+
+// deflateFlags returns the flag bits 1 and 2 of the Deflate level,
+// as Info-ZIP records them. The default level is recorded as normal.
+func deflateFlags(level int) uint16 {
+	switch level {
+	case 8, 9:
+		return FlagDeflateMaximum
+	case 2:
+		return FlagDeflateFast
+	case 1, flate.HuffmanOnly:
+		return FlagDeflateSuperFast
+	}
+	return 0
+}
+
+// extTimeExtra returns an extended timestamp extra field holding
+// the modification time t.
+func extTimeExtra(t time.Time) []byte {
+	var mbuf [9]byte // 2*SizeOf(uint16) + SizeOf(uint8) + SizeOf(uint32)
+	mt := uint32(t.Unix())
+	eb := writeBuf(mbuf[:])
+	eb.uint16(extTimeExtraID)
+	eb.uint16(5)  // Size: SizeOf(uint8) + SizeOf(uint32)
+	eb.uint8(1)   // Flags: ModTime
+	eb.uint32(mt) // ModTime
+	return mbuf[:]
+}
+
 func encodeHeader(h *FileHeader) []byte {
 	var buf [fileHeaderLen]byte
 	b := writeBuf(buf[:])
@@ -474,12 +678,20 @@ func (w *Writer) CopyFile(f *File) error {
 	}
 
 	// Write header
+	fh := &f.FileHeader
+	if v := methodVersion(fh.Method); fh.ReaderVersion < v {
+		// the version of an old archive may be wrong
+		fh = new(FileHeader)
+		*fh = f.FileHeader
+		fh.ReaderVersion = v
+	}
+	w.split.reserve(w.cw.count, fileHeaderLen+len(f.Name)+len(f.Extra))
 	h := &header{
-		FileHeader: &f.FileHeader,
+		FileHeader: fh,
 		offset:     uint64(w.cw.count),
 	}
 	w.dir = append(w.dir, h)
-	if err := writeHeader(w.cw, &f.FileHeader); err != nil {
+	if err := writeHeader(w.cw, fh); err != nil {
 		return err
 	}
 
@@ -518,6 +730,7 @@ func (w *Writer) CopyFile(f *File) error {
 		b.uint32(f.FileHeader.CompressedSize)
 		b.uint32(f.FileHeader.UncompressedSize)
 	}
+	w.split.reserve(w.cw.count, len(buf))
 	_, err := w.cw.Write(buf)
 	return err
 }
@@ -526,13 +739,30 @@ func (w *Writer) CopyFile(f *File) error {
 // method ID. If a compressor for a given method is not found, Writer will
 // default to looking up the compressor at the package level.
 func (w *Writer) RegisterCompressor(method uint16, comp Compressor) {
+	if comp == nil {
+		w.RegisterMethodCompressor(method, nil)
+		return
+	}
+	w.RegisterMethodCompressor(method, comp)
+}
+
+// RegisterMethodCompressor is like RegisterCompressor,
+// for a MethodCompressor.
+func (w *Writer) RegisterMethodCompressor(method uint16, comp MethodCompressor) {
 	if w.compressors == nil {
-		w.compressors = make(map[uint16]Compressor)
+		w.compressors = make(map[uint16]MethodCompressor)
 	}
 	w.compressors[method] = comp
 }
 
-func (w *Writer) compressor(method uint16) Compressor {
+// SetCompressOptions sets the options given to the compressors by
+// Create and CreateHeader. A non-zero FileHeader.Level overrides
+// the Level of opts.
+func (w *Writer) SetCompressOptions(opts CompressOptions) {
+	w.options = opts
+}
+
+func (w *Writer) compressor(method uint16) MethodCompressor {
 	comp := w.compressors[method]
 	if comp == nil {
 		comp = compressor(method)
@@ -553,26 +783,100 @@ type fileWriter struct {
 	*header
 	raww      io.Writer
 	zipw      io.Writer
+	split     *splitWriter
 	rawCount  *countWriter
 	comp      io.WriteCloser
 	compCount *countWriter
 	crc32     hash.Hash32
+	opts      CompressOptions
 	closed    bool
+
+	// The adaptive method selection holds the sample
+	// until it is full or the file is closed.
+	sample    []byte
+	trial     MethodCompressor
+	minSaving float64
 }
 
 func (w *fileWriter) Write(p []byte) (int, error) {
 	if w.closed {
 		return 0, errors.New("zip: write to closed file")
 	}
+	if w.sample != nil {
+		n := cap(w.sample) - len(w.sample)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.sample = append(w.sample, p[:n]...)
+		w.crc32.Write(p[:n])
+		if len(w.sample) < cap(w.sample) {
+			return n, nil
+		}
+		if err := w.writeSample(); err != nil {
+			return n, err
+		}
+		m, err := w.Write(p[n:])
+		return n + m, err
+	}
 	w.crc32.Write(p)
 	return w.rawCount.Write(p)
 }
 
+// writeSample decides the method of the file by the trial compression
+// of the sample, then writes the local file header and the sample.
+func (w *fileWriter) writeSample() error {
+	sample := w.sample
+	w.sample = nil
+	ok, err := compresses(w.trial, w.header.FileHeader, &w.opts, sample, w.minSaving)
+	if err != nil {
+		return err
+	}
+	comp := w.trial
+	if !ok {
+		fh := w.header.FileHeader
+		fh.Method = Store
+		fh.Level = 0
+		w.opts.Level = 0
+		comp = compressor(Store)
+	}
+	if err := w.setMethod(comp); err != nil {
+		return err
+	}
+	if err := writeHeader(w.zipw, w.header.FileHeader); err != nil {
+		return err
+	}
+	_, err = w.rawCount.Write(sample)
+	return err
+}
+
+// compresses reports whether comp saves at least the fraction minSaving of p,
+// the data of the file fh.
+func compresses(comp MethodCompressor, fh *FileHeader, opts *CompressOptions, p []byte, minSaving float64) (bool, error) {
+	cw := &countWriter{w: ioutil.Discard}
+	zw, err := comp.NewWriter(cw, fh, opts)
+	if err != nil {
+		return false, err
+	}
+	if _, err := zw.Write(p); err != nil {
+		zw.Close()
+		return false, err
+	}
+	if err := zw.Close(); err != nil {
+		return false, err
+	}
+	return float64(cw.count) <= float64(len(p))*(1-minSaving), nil
+}
+
 func (w *fileWriter) close() error {
 	if w.closed {
 		return errors.New("zip: file closed twice")
 	}
 	w.closed = true
+	if w.sample != nil {
+		if err := w.writeSample(); err != nil {
+			return err
+		}
+	}
 	if err := w.comp.Close(); err != nil {
 		return err
 	}
@@ -587,7 +891,9 @@ func (w *fileWriter) close() error {
 		fh.Flags |= FlagDataDescriptor
 		fh.CompressedSize = uint32max
 		fh.UncompressedSize = uint32max
-		fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+		if fh.ReaderVersion < zipVersion45 {
+			fh.ReaderVersion = zipVersion45 // requires 4.5 - File uses ZIP64 format extensions
+		}
 	} else {
 		fh.CompressedSize = uint32(fh.CompressedSize64)
 		fh.UncompressedSize = uint32(fh.UncompressedSize64)
@@ -626,6 +932,7 @@ func (w *fileWriter) close() error {
 			b.uint32(fh.CompressedSize)
 			b.uint32(fh.UncompressedSize)
 		}
+		w.split.reserve(w.zipw.(*countWriter).count, len(buf))
 		_, err := w.zipw.Write(buf)
 		return err
 	}
@@ -6,7 +6,9 @@ package zip
 
 import (
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -454,6 +456,421 @@ func TestWriterCopyFile(t *testing.T) {
 	}
 }
 
+func TestWriterZstd(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, level := range []int{0, 1, 19} {
+		if level != 0 {
+			w.RegisterCompressor(Zstd, ZstdCompressor(level))
+		}
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("level", level), Data: data, Method: Zstd, Mode: 0644})
+	}
+	testCreate(t, w, &WriteTest{Name: "dir/", Method: Zstd})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File[:3] {
+		testReadFile(t, f, &WriteTest{Name: f.Name, Data: data, Mode: 0644})
+		if f.Method != Zstd || f.ReaderVersion != zipVersion63 {
+			t.Fatalf("%s: method=%d version=%d, want %d %d", f.Name, f.Method, f.ReaderVersion, Zstd, zipVersion63)
+		}
+		if f.CompressedSize64 >= uint64(len(data))/10 {
+			t.Fatalf("%s: compressed size=%d", f.Name, f.CompressedSize64)
+		}
+	}
+	if f := r.File[3]; f.Method != Store || f.ReaderVersion != zipVersion20 {
+		t.Fatalf("%s: method=%d version=%d, want %d %d", f.Name, f.Method, f.ReaderVersion, Store, zipVersion20)
+	}
+
+	if _, err := ZstdCompressor(23)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterLevel(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+	tests := []struct {
+		method uint16
+		level  int
+		flags  uint16
+	}{
+		{Deflate, 0, 0},
+		{Deflate, 1, FlagDeflateSuperFast},
+		{Deflate, 2, FlagDeflateFast},
+		{Deflate, 5, 0},
+		{Deflate, 9, FlagDeflateMaximum},
+		{Deflate, flate.HuffmanOnly, FlagDeflateSuperFast},
+		{Deflate, -1, 0},
+		{Zstd, 19, 0},
+		{Store, 9, 0},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	// the Level overrides the Writer's compressor
+	w.RegisterCompressor(Zstd, func(io.Writer) (io.WriteCloser, error) {
+		return nil, errors.New("unexpected compressor")
+	})
+	for i, tt := range tests {
+		fh := &FileHeader{Name: fmt.Sprint(i), Method: tt.method, Level: tt.level}
+		fh.SetMode(0644)
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.CreateHeader(&FileHeader{Name: "invalid", Method: Deflate, Level: 10}); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != len(tests) {
+		t.Fatalf("files=%d, want %d", len(r.File), len(tests))
+	}
+	for i, tt := range tests {
+		f := r.File[i]
+		testReadFile(t, f, &WriteTest{Name: f.Name, Data: data, Mode: 0644})
+		if f.Method != tt.method || f.Flags&FlagDeflateSuperFast != tt.flags {
+			t.Fatalf("%s: method=%d flags=%#x, want %d %#x", f.Name, f.Method, f.Flags, tt.method, tt.flags)
+		}
+		if f.CompressedSize64 >= f.UncompressedSize64 && tt.method != Store {
+			t.Fatalf("%s: compressed size=%d of %d", f.Name, f.CompressedSize64, f.UncompressedSize64)
+		}
+	}
+
+	if _, err := DeflateCompressor(10)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterAdaptive(t *testing.T) {
+	text := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 3000)
+	random := make([]byte, len(text))
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name   string
+		data   []byte
+		method uint16
+	}{
+		{"text.txt", text, Deflate},
+		{"random.bin", random, Store},
+		{"short.txt", text[:1000], Deflate},
+		{"empty.txt", nil, Store},
+		{"photo.JPG", text, Store},
+		{"text.zst", text, Zstd},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.SetMethodSelector(ExtensionSelector(map[string]uint16{".jpg": Store, ".zst": Zstd}, Deflate))
+	w.SetAdaptive(0.05)
+	for _, tt := range tests {
+		fw, err := w.Create(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the writes cross the end of the sample
+		for p := tt.data; len(p) > 0; {
+			n := 10000
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := fw.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+	}
+	// the selector is consulted by CreateHeader only for SelectMethod
+	for _, tt := range []struct {
+		fh     *FileHeader
+		method uint16
+	}{
+		{&FileHeader{Name: "photo.jpg", Method: Deflate}, Deflate},
+		{&FileHeader{Name: "text.zst", Method: Store}, Store},
+		{&FileHeader{Name: "photo2.jpg", Method: SelectMethod}, Store},
+		{&FileHeader{Name: "text2.zst", Method: SelectMethod}, Zstd},
+	} {
+		tt.fh.SetMode(0666)
+		fw, err := w.CreateHeader(tt.fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(text); err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, struct {
+			name   string
+			data   []byte
+			method uint16
+		}{tt.fh.Name, text, tt.method})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != len(tests) {
+		t.Fatalf("files=%d, want %d", len(r.File), len(tests))
+	}
+	for i, tt := range tests {
+		f := r.File[i]
+		testReadFile(t, f, &WriteTest{Name: tt.name, Data: tt.data, Mode: 0666})
+		if f.Method != tt.method {
+			t.Fatalf("%s: method=%d, want %d", f.Name, f.Method, tt.method)
+		}
+	}
+}
+
+type testMethod struct {
+	fh   *FileHeader
+	opts CompressOptions
+}
+
+func (m *testMethod) NewWriter(w io.Writer, fh *FileHeader, opts *CompressOptions) (io.WriteCloser, error) {
+	m.fh, m.opts = fh, *opts
+	return nopCloser{w}, nil
+}
+
+func (m *testMethod) NewReader(r io.Reader, fh *FileHeader, opts *DecompressOptions) (io.ReadCloser, error) {
+	return nil, errors.New("test error")
+}
+
+func TestWriterMethodCompressor(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+	dict := []byte("guinea pigs, marsupial rats")
+	const method = 0xfff0
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	m := new(testMethod)
+	w.RegisterMethodCompressor(method, m)
+	w.SetCompressOptions(CompressOptions{Level: 3, Dictionary: dict, Concurrency: 4})
+	for _, fh := range []*FileHeader{
+		{Name: "custom", Method: method, Level: 7},
+		{Name: "dict", Method: Deflate},
+	} {
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.fh == nil || m.fh.Name != "custom" {
+		t.Fatalf("header=%v, want %q", m.fh, "custom")
+	}
+	if m.opts.Level != 7 || !bytes.Equal(m.opts.Dictionary, dict) || m.opts.Concurrency != 4 {
+		t.Fatalf("options=%+v", m.opts)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the decompressor's error is returned by Open
+	r.RegisterMethodDecompressor(method, m)
+	if _, err := r.File[0].Open(); err == nil || err.Error() != "test error" {
+		t.Fatalf("err=%v, want test error", err)
+	}
+	readAll := func(f *File) ([]byte, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	// the dictionary is needed to read the data
+	if _, err := readAll(r.File[1]); err == nil {
+		t.Fatalf("need raise error")
+	}
+	r.SetDecompressOptions(DecompressOptions{Dictionary: dict})
+	got, err := readAll(r.File[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("contents mismatch")
+	}
+}
+
+// brokenWriter writes the broken header instead of the data.
+type brokenWriter struct {
+	w io.Writer
+}
+
+func (w brokenWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w brokenWriter) Close() error {
+	_, err := w.w.Write(bytes.Repeat([]byte{0xff}, 32))
+	return err
+}
+
+func TestWriterCorruptHeader(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+	methods := []uint16{Deflate64, Bzip2, LZMA, XZ, Zstd, PPMd}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, method := range methods {
+		w.RegisterCompressor(method, func(w io.Writer) (io.WriteCloser, error) {
+			return brokenWriter{w}, nil
+		})
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("method", method), Data: data, Method: method, Mode: 0644})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		// the header errors are returned by Open, not by Read
+		if rc, err := f.Open(); err == nil {
+			rc.Close()
+			t.Fatalf("%s: need raise error", f.Name)
+		}
+	}
+}
+
+func TestWriterPPMd(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, level := range []int{0, 1, 9} {
+		if level != 0 {
+			w.RegisterCompressor(PPMd, PPMdCompressor(level))
+		}
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("level", level), Data: data, Method: PPMd, Mode: 0644})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		testReadFile(t, f, &WriteTest{Name: f.Name, Data: data, Mode: 0644})
+		if f.Method != PPMd || f.ReaderVersion != zipVersion63 {
+			t.Fatalf("%s: method=%d version=%d, want %d %d", f.Name, f.Method, f.ReaderVersion, PPMd, zipVersion63)
+		}
+		if f.CompressedSize64 >= uint64(len(data))/10 {
+			t.Fatalf("%s: compressed size=%d", f.Name, f.CompressedSize64)
+		}
+	}
+
+	if _, err := PPMdCompressor(10)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterBzip2(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, level := range []int{0, 1} {
+		if level != 0 {
+			w.RegisterCompressor(Bzip2, Bzip2Compressor(level))
+		}
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("level", level), Data: data, Method: Bzip2, Mode: 0644})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		testReadFile(t, f, &WriteTest{Name: f.Name, Data: data, Mode: 0644})
+		if f.Method != Bzip2 || f.ReaderVersion != zipVersion46 {
+			t.Fatalf("%s: method=%d version=%d, want %d %d", f.Name, f.Method, f.ReaderVersion, Bzip2, zipVersion46)
+		}
+		if f.CompressedSize64 >= uint64(len(data))/10 {
+			t.Fatalf("%s: compressed size=%d", f.Name, f.CompressedSize64)
+		}
+	}
+
+	if _, err := Bzip2Compressor(10)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
+func TestWriterLZMA(t *testing.T) {
+	data := bytes.Repeat([]byte("Rabbits, guinea pigs, gophers, marsupial rats, and quolls.\n"), 100)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, method := range []uint16{LZMA, XZ} {
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("method", method), Data: data, Method: method, Mode: 0644})
+	}
+	w.RegisterCompressor(LZMA, LZMACompressor(1))
+	w.RegisterCompressor(XZ, XZCompressor(9))
+	for _, method := range []uint16{LZMA, XZ} {
+		testCreate(t, w, &WriteTest{Name: fmt.Sprint("level-method", method), Data: data, Method: method, Mode: 0644})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		testReadFile(t, f, &WriteTest{Name: f.Name, Data: data, Mode: 0644})
+		if f.ReaderVersion != zipVersion63 {
+			t.Fatalf("%s: version=%d, want %d", f.Name, f.ReaderVersion, zipVersion63)
+		}
+		if (f.Method == LZMA) != (f.Flags&FlagLZMAEndMarker != 0) {
+			t.Fatalf("%s: method=%d flags=%#x", f.Name, f.Method, f.Flags)
+		}
+		if f.CompressedSize64 >= uint64(len(data))/10 {
+			t.Fatalf("%s: compressed size=%d", f.Name, f.CompressedSize64)
+		}
+	}
+
+	if _, err := LZMACompressor(10)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+	if _, err := XZCompressor(10)(ioutil.Discard); err == nil {
+		t.Fatalf("need raise error")
+	}
+}
+
 func TestWriterNoDataDescriptor(t *testing.T) {
 	srcFile := "testdata/dd.zip"
 
@@ -424,7 +424,7 @@ func suffixIsZip64(t *testing.T, zip sizedReaderAt) bool {
 		return false
 	}
 
-	dirOff, err := findDirectory64End(zip, zip.Size()-int64(len(d))+int64(sigOff))
+	dirOff, err := findDirectory64End(zip, zip.Size()-int64(len(d))+int64(sigOff), nil)
 	if err != nil {
 		t.Fatalf("findDirectory64End: %v", err)
 	}